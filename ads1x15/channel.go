@@ -0,0 +1,86 @@
+package ads1x15
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/reef-pi/hal"
+)
+
+type channel struct {
+	driver     *driver
+	number     int
+	name       string
+	mux        uint16
+	mu         sync.Mutex // guards calibrator
+	calibrator hal.Calibrator
+}
+
+func NewChannel(d *driver, number int, name string, mux uint16) (*channel, error) {
+	c, err := hal.CalibratorFactory([]hal.Measurement{})
+	if err != nil {
+		return nil, err
+	}
+	return &channel{
+		driver:     d,
+		number:     number,
+		name:       name,
+		mux:        mux,
+		calibrator: c,
+	}, nil
+}
+
+func (c *channel) Name() string {
+	return c.name
+}
+
+func (c *channel) Number() int {
+	return c.number
+}
+
+func (c *channel) Calibrate(points []hal.Measurement) error {
+	cal, err := hal.CalibratorFactory(points)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.calibrator = cal
+	c.mu.Unlock()
+	return nil
+}
+
+// Read returns the input voltage
+func (c *channel) Read() (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+	defer cancel()
+	return c.ReadContext(ctx)
+}
+
+func (c *channel) ReadContext(ctx context.Context) (float64, error) {
+	return c.driver.convert(ctx, c.mux)
+}
+
+func (c *channel) Measure() (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+	defer cancel()
+	return c.MeasureContext(ctx)
+}
+
+func (c *channel) MeasureContext(ctx context.Context) (float64, error) {
+	v, err := c.ReadContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	cal := c.calibrator
+	c.mu.Unlock()
+	if cal == nil {
+		return 0, fmt.Errorf("Not calibrated")
+	}
+	return cal.Calibrate(v), nil
+}
+
+func (c *channel) Close() error {
+	return nil
+}
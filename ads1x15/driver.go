@@ -0,0 +1,403 @@
+// Package ads1x15 provides a driver for the TI ADS1115 (16 bit) and ADS1015
+// (12 bit) analog to digital converters.
+//
+// http://www.ti.com/lit/ds/symlink/ads1115.pdf
+package ads1x15
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/schema"
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+)
+
+var driverMeta = hal.Metadata{
+	Name:         "ads1x15",
+	Description:  "TI ADS1115/ADS1015 analog to digital converter",
+	Capabilities: []hal.Capability{hal.AnalogInput},
+}
+
+var configSchema = schema.Object(schema.Properties{
+	"address": schema.Address().WithDefault(0x48),
+	"chip":    schema.String().OneOf(ADS1115, ADS1015).WithDefault(ADS1115),
+	"gain": schema.Number().OneOf(6.144, 4.096, 2.048, 1.024, 0.512, 0.256).WithDefault(2.048).
+		Describe("Full scale range in volts"),
+	"data_rate": schema.Integer().OneOf(8, 16, 32, 64, 128, 250, 475, 490, 860, 920, 1600, 2400, 3300).
+		Describe("Samples per second, defaults to 128 (ads1115) or 1600 (ads1015)"),
+	"continuous": schema.Boolean().Describe("Continuous conversion instead of single-shot"),
+	"comparator": schema.Object(schema.Properties{
+		"channel":     schema.Integer().Range(0, 5).Describe("Input watched by the threshold comparator, needs continuous mode"),
+		"low":         schema.Number().Describe("Low threshold in volts"),
+		"high":        schema.Number().Describe("High threshold in volts"),
+		"window":      schema.Boolean(),
+		"active_high": schema.Boolean(),
+		"latching":    schema.Boolean(),
+		"queue":       schema.Integer().OneOf(1, 2, 4).Describe("Conversions beyond threshold before asserting"),
+		"ready":       schema.Boolean(),
+	}),
+})
+
+func init() {
+	drivers.Register(driverMeta, configSchema, HalAdapter)
+}
+
+const (
+	ADS1115 = "ads1115"
+	ADS1015 = "ads1015"
+
+	conversionReg = 0x00
+	configReg     = 0x01
+	loThreshReg   = 0x02
+	hiThreshReg   = 0x03
+
+	osBit         = 0x8000 // start a single conversion / conversion ready
+	modeSingle    = 0x0100
+	compWindow    = 0x0010
+	compActiveHi  = 0x0008
+	compLatch     = 0x0004
+	compQueueNone = 0x0003
+
+	readyRetries = 10
+
+	// deadline of the plain Read and Measure
+	_timeout = time.Second
+)
+
+// full scale range (volts) to PGA bits
+var gains = map[float64]uint16{
+	6.144: 0x0000,
+	4.096: 0x0200,
+	2.048: 0x0400,
+	1.024: 0x0600,
+	0.512: 0x0800,
+	0.256: 0x0A00,
+}
+
+// samples per second to DR bits
+var dataRates = map[string]map[int]uint16{
+	ADS1115: {8: 0x0000, 16: 0x0020, 32: 0x0040, 64: 0x0060, 128: 0x0080, 250: 0x00A0, 475: 0x00C0, 860: 0x00E0},
+	ADS1015: {128: 0x0000, 250: 0x0020, 490: 0x0040, 920: 0x0060, 1600: 0x0080, 2400: 0x00A0, 3300: 0x00C0},
+}
+
+var defaultDataRates = map[string]int{
+	ADS1115: 128,
+	ADS1015: 1600,
+}
+
+// input multiplexer settings, in pin order
+var inputs = []struct {
+	name string
+	mux  uint16
+}{
+	{"A0", 0x4000},
+	{"A1", 0x5000},
+	{"A2", 0x6000},
+	{"A3", 0x7000},
+	{"A0-A1", 0x0000},
+	{"A2-A3", 0x3000},
+}
+
+type Config struct {
+	Address    byte              `json:"address"`    // 0x48
+	Chip       string            `json:"chip"`       // ads1115 (default) or ads1015
+	Gain       float64           `json:"gain"`       // full scale range in volts, defaults to 2.048
+	DataRate   int               `json:"data_rate"`  // samples per second
+	Continuous bool              `json:"continuous"` // continuous conversion instead of single-shot
+	Comparator *ComparatorConfig `json:"comparator"`
+}
+
+// ComparatorConfig sets up the ALERT/RDY pin. With Ready set the pin
+// signals conversion ready instead of threshold crossings. The threshold
+// comparator needs continuous mode: the chip keeps converting Channel, and
+// reads of other inputs switch back to it afterwards
+type ComparatorConfig struct {
+	Channel    int     `json:"channel"` // input index, as for the analog input pins
+	Low        float64 `json:"low"`     // volts
+	High       float64 `json:"high"`    // volts
+	Window     bool    `json:"window"`
+	ActiveHigh bool    `json:"active_high"`
+	Latching   bool    `json:"latching"`
+	Queue      int     `json:"queue"` // conversions beyond threshold before asserting: 1, 2 or 4
+	Ready      bool    `json:"ready"`
+}
+
+type driver struct {
+	addr     byte
+	bus      i2c.Bus
+	chip     string
+	fsr      float64
+	config   uint16            // PGA, DR, MODE and comparator bits shared by all channels
+	comp     *ComparatorConfig // threshold comparator, nil when unset or signalling ready
+	delay    time.Duration
+	mux      uint16 // last mux used in continuous mode
+	started  bool
+	mu       *sync.Mutex // device lock, held across a conversion
+	errs     drivers.ErrorLog
+	channels []hal.AnalogInputPin
+	meta     hal.Metadata
+}
+
+func HalAdapter(c []byte, bus i2c.Bus) (hal.Driver, error) {
+	return NewDriver(c, bus)
+}
+
+func NewDriver(c []byte, bus i2c.Bus) (hal.AnalogInputDriver, error) {
+	c, err := configSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
+	config := Config{Address: 0x48}
+	if err := json.Unmarshal(c, &config); err != nil {
+		return nil, err
+	}
+	if config.Chip == "" {
+		config.Chip = ADS1115
+	}
+	rates, ok := dataRates[config.Chip]
+	if !ok {
+		return nil, fmt.Errorf("unsupported chip: %s", config.Chip)
+	}
+	if config.Gain == 0 {
+		config.Gain = 2.048
+	}
+	pga, ok := gains[config.Gain]
+	if !ok {
+		return nil, fmt.Errorf("unsupported gain: %f", config.Gain)
+	}
+	if config.DataRate == 0 {
+		config.DataRate = defaultDataRates[config.Chip]
+	}
+	dr, ok := rates[config.DataRate]
+	if !ok {
+		return nil, fmt.Errorf("unsupported data rate %d for %s", config.DataRate, config.Chip)
+	}
+	d := &driver{
+		mu:     drivers.DeviceLock(bus, config.Address),
+		addr:   config.Address,
+		bus:    bus,
+		chip:   config.Chip,
+		fsr:    config.Gain,
+		config: pga | dr | compQueueNone,
+		// one conversion period plus 10% for oscillator tolerance
+		delay: time.Second * 11 / time.Duration(config.DataRate*10),
+		meta:  driverMeta,
+	}
+	if !config.Continuous {
+		d.config |= modeSingle
+	}
+	for i, in := range inputs {
+		ch, err := NewChannel(d, i, in.name, in.mux)
+		if err != nil {
+			return nil, err
+		}
+		d.channels = append(d.channels, ch)
+	}
+	if config.Comparator != nil {
+		if err := d.setupComparator(*config.Comparator); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (d *driver) setupComparator(c ComparatorConfig) error {
+	if c.Channel < 0 || c.Channel >= len(inputs) {
+		return fmt.Errorf("invalid comparator channel %d", c.Channel)
+	}
+	queue := map[int]uint16{0: 0x0000, 1: 0x0000, 2: 0x0001, 4: 0x0002}
+	q, ok := queue[c.Queue]
+	if !ok {
+		return fmt.Errorf("invalid comparator queue: %d", c.Queue)
+	}
+	lo, hi := d.code(c.Low), d.code(c.High)
+	if c.Ready {
+		// MSB of hi set and MSB of lo cleared turns ALERT/RDY into a
+		// conversion ready signal
+		lo, hi = 0x0000, 0x8000
+	} else if c.Low > c.High {
+		return fmt.Errorf("comparator low threshold %f above high threshold %f", c.Low, c.High)
+	} else if d.config&modeSingle != 0 {
+		return fmt.Errorf("threshold comparator requires continuous mode")
+	}
+	if err := d.writeReg(loThreshReg, lo); err != nil {
+		return err
+	}
+	if err := d.writeReg(hiThreshReg, hi); err != nil {
+		return err
+	}
+	d.config = d.config&^compQueueNone | q
+	if c.Window {
+		d.config |= compWindow
+	}
+	if c.ActiveHigh {
+		d.config |= compActiveHi
+	}
+	if c.Latching {
+		d.config |= compLatch
+	}
+	if c.Ready {
+		return nil
+	}
+	d.comp = &c
+	// start converting the watched input. started stays false, so the first
+	// read waits for a conversion to complete
+	d.mux = inputs[c.Channel].mux
+	return d.writeReg(configReg, d.config|d.mux)
+}
+
+// code converts volts to a left justified conversion register value
+func (d *driver) code(v float64) uint16 {
+	c := math.Round(v / d.fsr * 32768)
+	c = math.Max(-32768, math.Min(32767, c))
+	return uint16(int16(c))
+}
+
+// volts converts a conversion register value to volts
+func (d *driver) volts(raw uint16) float64 {
+	v := int16(raw)
+	if d.chip == ADS1015 {
+		v >>= 4
+		return float64(v) / 2048 * d.fsr
+	}
+	return float64(v) / 32768 * d.fsr
+}
+
+func (d *driver) writeReg(reg byte, v uint16) error {
+	return d.errs.Record(d.bus.WriteToReg(d.addr, reg, []byte{byte(v >> 8), byte(v)}))
+}
+
+func (d *driver) readReg(reg byte) (uint16, error) {
+	buf := make([]byte, 2)
+	if err := d.bus.ReadFromReg(d.addr, reg, buf); err != nil {
+		return 0, d.errs.Record(err)
+	}
+	return uint16(buf[0])<<8 | uint16(buf[1]), nil
+}
+
+// Ready reports whether no conversion is in progress
+func (d *driver) Ready() (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ready()
+}
+
+func (d *driver) ready() (bool, error) {
+	c, err := d.readReg(configReg)
+	if err != nil {
+		return false, err
+	}
+	return c&osBit != 0, nil
+}
+
+// convert returns the conversion result, in volts, for the given input. Waits
+// for the conversion end early when ctx is done
+func (d *driver) convert(ctx context.Context, mux uint16) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return math.NaN(), err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.config&modeSingle == 0 {
+		if !d.started || d.mux != mux {
+			if err := d.writeReg(configReg, d.config|mux); err != nil {
+				return math.NaN(), err
+			}
+			d.started, d.mux = true, mux
+			if err := drivers.Sleep(ctx, d.delay); err != nil {
+				return math.NaN(), err
+			}
+		}
+	} else {
+		if err := d.writeReg(configReg, d.config|mux|osBit); err != nil {
+			return math.NaN(), err
+		}
+		if err := d.waitReady(ctx); err != nil {
+			return math.NaN(), err
+		}
+	}
+	raw, err := d.readReg(conversionReg)
+	if err != nil {
+		return math.NaN(), err
+	}
+	if d.comp != nil && d.mux != inputs[d.comp.Channel].mux {
+		// hand the chip back to the threshold comparator
+		d.mux = inputs[d.comp.Channel].mux
+		d.started = false
+		if err := d.writeReg(configReg, d.config|d.mux); err != nil {
+			return math.NaN(), err
+		}
+	}
+	return d.volts(raw), nil
+}
+
+func (d *driver) waitReady(ctx context.Context) error {
+	if err := drivers.Sleep(ctx, d.delay); err != nil {
+		return err
+	}
+	for i := 0; i < readyRetries; i++ {
+		ok, err := d.ready()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if err := drivers.Sleep(ctx, d.delay/readyRetries); err != nil {
+			return err
+		}
+	}
+	return d.errs.Record(drivers.Transient(fmt.Errorf("conversion not ready")))
+}
+
+func (d *driver) Metadata() hal.Metadata {
+	return d.meta
+}
+
+func (d *driver) Pins(cap hal.Capability) ([]hal.Pin, error) {
+	return drivers.Pins(d, cap)
+}
+
+func (d *driver) AnalogInputPins() []hal.AnalogInputPin {
+	return d.channels
+}
+
+func (d *driver) AnalogInputPin(n int) (hal.AnalogInputPin, error) {
+	if n < 0 || n >= len(d.channels) {
+		return nil, fmt.Errorf("ads1x15 does not have channel %d", n)
+	}
+	return d.channels[n], nil
+}
+
+func (d *driver) Close() error {
+	return nil
+}
+
+// Diagnose reads back the config register and reports the chip settings
+func (d *driver) Diagnose(ctx context.Context) drivers.Diagnosis {
+	err := ctx.Err()
+	if err == nil {
+		d.mu.Lock()
+		_, err = d.readReg(configReg)
+		d.mu.Unlock()
+	}
+	diag := d.errs.Diagnosis(err)
+	diag.Hardware = d.chip
+	diag.Set("address", fmt.Sprintf("0x%02x", d.addr))
+	diag.Set("gain", d.fsr)
+	if d.config&modeSingle == 0 {
+		diag.Set("mode", "continuous")
+	} else {
+		diag.Set("mode", "single-shot")
+	}
+	if d.comp != nil {
+		diag.Set("comparator_channel", inputs[d.comp.Channel].name)
+	}
+	return diag
+}
@@ -0,0 +1,155 @@
+package ads1x15
+
+import (
+	"context"
+	"testing"
+
+	"github.com/reef-pi/hal"
+
+	"github.com/dmolavi/drivers/i2ctest"
+	"github.com/dmolavi/drivers/sim"
+)
+
+// simBus attaches a converter simulator at 0x48 to a bus
+func simBus(chip *sim.ADS1x15) *sim.Bus {
+	bus := sim.NewBus()
+	bus.Attach(0x48, chip)
+	return bus
+}
+
+func TestADS1115(t *testing.T) {
+	chip := sim.NewADS1115()
+	chip.SetInput(0, sim.Constant(2.048)) // half of full scale
+	chip.SetInput(1, sim.Constant(4.096))
+	bus := simBus(chip)
+	if _, err := HalAdapter([]byte("{"), bus); err == nil {
+		t.Error("Adapter creation should fail when json config is invalid")
+	}
+	d, err := NewDriver([]byte(`{"address":72, "gain":4.096, "data_rate":860}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.(*driver).delay = 0
+	if !d.Metadata().HasCapability(hal.AnalogInput) {
+		t.Error("Analog input Capability should exist")
+	}
+	if len(d.AnalogInputPins()) != 6 {
+		t.Error("Expected 6 channels, found:", len(d.AnalogInputPins()))
+	}
+	a0, err := d.AnalogInputPin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := a0.Read(); err != nil || v != 2.048 {
+		t.Error("Expected 2.048V, found:", v, err)
+	}
+	cfg := chip.Config()
+	if cfg&0x0E00 != 0x0200 || cfg&0x00E0 != 0x00E0 || cfg&modeSingle == 0 {
+		t.Errorf("Unexpected config register: 0x%x", cfg)
+	}
+	diff, _ := d.AnalogInputPin(4)
+	if diff.Name() != "A0-A1" {
+		t.Error("Unexpected channel name:", diff.Name())
+	}
+	if v, err := diff.Read(); err != nil || v != -2.048 {
+		t.Error("Expected -2.048V, found:", v, err)
+	}
+	if _, err := d.AnalogInputPin(6); err == nil {
+		t.Error("Expected error for invalid channel")
+	}
+	diag := d.(*driver).Diagnose(context.Background())
+	if !diag.Reachable || diag.Hardware != ADS1115 || diag.Fields["gain"] != "4.096" || diag.Fields["mode"] != "single-shot" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestADS1015Continuous(t *testing.T) {
+	chip := sim.NewADS1015()
+	chip.SetInput(1, sim.Constant(1.024))
+	bus := i2ctest.NewRecorder(simBus(chip))
+	d, err := NewDriver([]byte(`{"address":72, "chip":"ads1015", "continuous":true}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.(*driver).delay = 0
+	a1, _ := d.AnalogInputPin(1)
+	for i := 0; i < 3; i++ {
+		if v, err := a1.Read(); err != nil || v != 1.024 {
+			t.Error("Expected 1.024V, found:", v, err)
+		}
+	}
+	writes := 0
+	for _, tx := range bus.Transactions() {
+		if tx.Op == i2ctest.OpWriteReg {
+			writes++
+		}
+	}
+	if writes != 1 {
+		t.Error("Expected config to be written once in continuous mode, found:", writes)
+	}
+	if chip.Config()&modeSingle != 0 {
+		t.Error("Expected continuous conversion mode")
+	}
+	if _, err := NewDriver([]byte(`{"chip":"ads1015", "data_rate":860}`), bus); err == nil {
+		t.Error("860 SPS is not supported by ads1015")
+	}
+	if _, err := NewDriver([]byte(`{"gain":3}`), bus); err == nil {
+		t.Error("Invalid gain should fail")
+	}
+	if _, err := NewDriver([]byte(`{"chip":"ads1234"}`), bus); err == nil {
+		t.Error("Unknown chip should fail")
+	}
+}
+
+func TestComparator(t *testing.T) {
+	chip := sim.NewADS1115()
+	chip.SetInput(1, sim.Constant(0.512))
+	chip.SetInput(2, sim.Constant(1.024))
+	bus := simBus(chip)
+	d, err := NewDriver([]byte(`{"address":72, "continuous":true, "comparator":{"channel":2, "low":0.512, "high":1.024, "window":true, "latching":true, "queue":2}}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.(*driver).delay = 0
+	if v := chip.Register(loThreshReg); v != 0x2000 {
+		t.Errorf("Expected low threshold 0x2000, found: 0x%x", v)
+	}
+	if v := chip.Register(hiThreshReg); v != 0x4000 {
+		t.Errorf("Expected high threshold 0x4000, found: 0x%x", v)
+	}
+	if c := d.(*driver).config; c&0x001F != compWindow|compLatch|0x0001 {
+		t.Errorf("Unexpected comparator bits: 0x%x", c&0x001F)
+	}
+	if c := chip.Config(); c&0x7000 != 0x6000 || c&modeSingle != 0 {
+		t.Errorf("Expected continuous conversion of A2, found config: 0x%x", c)
+	}
+	a1, _ := d.AnalogInputPin(1)
+	if v, err := a1.Read(); err != nil || v != 0.512 {
+		t.Error("Expected 0.512V, found:", v, err)
+	}
+	if c := chip.Config(); c&0x7000 != 0x6000 {
+		t.Errorf("Expected comparator input A2 restored after reading A1, found config: 0x%x", c)
+	}
+	a2, _ := d.AnalogInputPin(2)
+	if v, err := a2.Read(); err != nil || v != 1.024 {
+		t.Error("Expected 1.024V, found:", v, err)
+	}
+	if _, err := NewDriver([]byte(`{"comparator":{"low":0.5, "high":1}}`), bus); err == nil {
+		t.Error("Threshold comparator in single-shot mode should fail")
+	}
+	if _, err := NewDriver([]byte(`{"address":72, "comparator":{"ready":true}}`), bus); err != nil {
+		t.Error(err)
+	}
+	if chip.Register(hiThreshReg) != 0x8000 || chip.Register(loThreshReg) != 0 {
+		t.Error("Expected conversion ready thresholds")
+	}
+	if _, err := NewDriver([]byte(`{"comparator":{"low":2, "high":1}}`), bus); err == nil {
+		t.Error("Low threshold above high threshold should fail")
+	}
+	if _, err := NewDriver([]byte(`{"comparator":{"queue":3}}`), bus); err == nil {
+		t.Error("Invalid queue should fail")
+	}
+}
@@ -0,0 +1,17 @@
+// Package all registers every driver in this repo with the default registry.
+// Import it for side effects:
+//
+//	import _ "github.com/dmolavi/drivers/all"
+package all
+
+import (
+	_ "github.com/dmolavi/drivers/ads1x15"
+	_ "github.com/dmolavi/drivers/dli"
+	_ "github.com/dmolavi/drivers/ezo"
+	_ "github.com/dmolavi/drivers/file"
+	_ "github.com/dmolavi/drivers/pca9685"
+	_ "github.com/dmolavi/drivers/ph_board"
+	_ "github.com/dmolavi/drivers/pico_board"
+	_ "github.com/dmolavi/drivers/tplink"
+	_ "github.com/dmolavi/drivers/w1"
+)
@@ -0,0 +1,99 @@
+package drivers
+
+import (
+	"fmt"
+
+	"github.com/reef-pi/hal"
+)
+
+var capabilities = []hal.Capability{hal.DigitalInput, hal.DigitalOutput, hal.PWM, hal.AnalogInput}
+
+// Pins returns the pins of d with the given capability. Pins are derived from
+// the typed pin sets d implements (AnalogInputPins, DigitalInputPins,
+// DigitalOutputPins and PWMChannels) and only for capabilities listed in its
+// metadata, so drivers implementing hal.Driver.Pins with it can not disagree
+// with their metadata
+func Pins(d hal.Driver, cap hal.Capability) ([]hal.Pin, error) {
+	if !d.Metadata().HasCapability(cap) {
+		return nil, fmt.Errorf("unsupported capability:%s", cap.String())
+	}
+	var pins []hal.Pin
+	switch cap {
+	case hal.DigitalInput:
+		if t, ok := d.(hal.DigitalInputDriver); ok {
+			for _, p := range t.DigitalInputPins() {
+				pins = append(pins, p)
+			}
+			return pins, nil
+		}
+	case hal.DigitalOutput:
+		if t, ok := d.(hal.DigitalOutputDriver); ok {
+			for _, p := range t.DigitalOutputPins() {
+				pins = append(pins, p)
+			}
+			return pins, nil
+		}
+	case hal.PWM:
+		if t, ok := d.(hal.PWMDriver); ok {
+			for _, p := range t.PWMChannels() {
+				pins = append(pins, p)
+			}
+			return pins, nil
+		}
+	case hal.AnalogInput:
+		if t, ok := d.(hal.AnalogInputDriver); ok {
+			for _, p := range t.AnalogInputPins() {
+				pins = append(pins, p)
+			}
+			return pins, nil
+		}
+	}
+	return nil, fmt.Errorf("driver %s advertises %s without implementing its pins", d.Metadata().Name, cap.String())
+}
+
+// CheckCapabilities verifies the capabilities advertised by d agree with the
+// pins it returns. Every advertised capability must return pins implementing
+// the matching typed interface, and every other capability must be rejected
+func CheckCapabilities(d hal.Driver) error {
+	meta := d.Metadata()
+	for _, cap := range capabilities {
+		pins, err := d.Pins(cap)
+		if !meta.HasCapability(cap) {
+			if err == nil {
+				return fmt.Errorf("%s returns pins for unadvertised capability %s", meta.Name, cap.String())
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s advertises %s but returns error: %v", meta.Name, cap.String(), err)
+		}
+		typed, err := Pins(d, cap)
+		if err != nil {
+			return err
+		}
+		if len(pins) != len(typed) {
+			return fmt.Errorf("%s returns %d %s pins, expected %d", meta.Name, len(pins), cap.String(), len(typed))
+		}
+		for _, p := range pins {
+			if !hasCapability(p, cap) {
+				return fmt.Errorf("%s pin %s does not support %s", meta.Name, p.Name(), cap.String())
+			}
+		}
+	}
+	return nil
+}
+
+func hasCapability(p hal.Pin, cap hal.Capability) bool {
+	var ok bool
+	switch cap {
+	case hal.DigitalInput:
+		_, ok = p.(hal.DigitalInputPin)
+	case hal.DigitalOutput:
+		_, ok = p.(hal.DigitalOutputPin)
+	case hal.PWM:
+		_, ok = p.(hal.PWMChannel)
+	case hal.AnalogInput:
+		_, ok = p.(hal.AnalogInputPin)
+	}
+	return ok
+}
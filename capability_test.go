@@ -0,0 +1,138 @@
+package drivers_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/ads1x15"
+	"github.com/dmolavi/drivers/dli"
+	"github.com/dmolavi/drivers/ezo"
+	"github.com/dmolavi/drivers/file"
+	"github.com/dmolavi/drivers/pca9685"
+	"github.com/dmolavi/drivers/ph_board"
+	"github.com/dmolavi/drivers/pico_board"
+	"github.com/dmolavi/drivers/tplink"
+	"github.com/dmolavi/drivers/w1"
+)
+
+type adapter func([]byte, i2c.Bus) (hal.Driver, error)
+
+func TestCapabilityConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capability")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixtures := map[string]string{
+		"analog":                      "1.5",
+		"digital":                     "0",
+		"gpio/gpio17/direction":       "in",
+		"gpio/gpio17/value":           "0",
+		"gpio/gpio18/direction":       "out",
+		"gpio/gpio18/value":           "0",
+		"iio/in_voltage0_raw":         "100",
+		"pwm/pwmchip0/pwm0/period":    "0",
+		"pwm/pwmchip0/pwm1/period":    "0",
+		"w1/28-000000000001/w1_slave": "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n",
+	}
+	for name, content := range fixtures {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+	hs300 := tplinkServer(t, "tplink/testdata/hs300_info.json")
+	defer hs300.Close()
+
+	cases := []struct {
+		name    string
+		adapter adapter
+		config  string
+	}{
+		{"ads1x15", ads1x15.HalAdapter, `{"address":72}`},
+		{"dli", dli.DLIWebProSwitchHALAdapter, `{"address":"127.0.0.1"}`},
+		{"ezo", ezo.EzoHalAdapter, `{"address":99}`},
+		{"file-analog", file.HalAnalogAdapter, fmt.Sprintf(`{"address":%q}`, path("analog"))},
+		{"file-digital", file.HalDigitalAdapter, fmt.Sprintf(`{"address":%q}`, path("digital"))},
+		{"file-analogs", file.HalAnalogAdapter, fmt.Sprintf(`{"channels":[{"path":%q}]}`, path("analog"))},
+		{"file-digitals", file.HalDigitalAdapter, fmt.Sprintf(`{"channels":[{"path":%q}]}`, path("digital"))},
+		{"file-gpio", file.HalGPIOAdapter, fmt.Sprintf(`{"path":%q, "pins":[{"number":17}, {"number":18, "direction":"out"}]}`, path("gpio"))},
+		{"file-pwm", file.HalPWMAdapter, fmt.Sprintf(`{"path":%q, "channels":[0, 1], "frequency":1000}`, path("pwm"))},
+		{"file-sensor", file.HalSensorAdapter, fmt.Sprintf(`{"path":%q}`, path("iio"))},
+		{"pca9685", pca9685.HALAdapter, `{"address":64, "frequency":1500}`},
+		{"ph-board", ph_board.HalAdapter, `{"address":64}`},
+		{"pico-board", pico_board.HalAdapter, `{"address":64}`},
+		{"tplink-hs103", tplink.HS103HALAdapter, `{"address":"127.0.0.1:9999"}`},
+		{"tplink-hs110", tplink.HS110HALAdapter, `{"address":"127.0.0.1:9999"}`},
+		{"tplink-hs300", tplink.HS300HALAdapter, fmt.Sprintf(`{"address":%q}`, hs300.Addr())},
+		{"w1", w1.HalAdapter, fmt.Sprintf(`{"path":%q}`, path("w1"))},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bus := i2c.MockBus()
+			bus.Bytes = make([]byte, 3)
+			d, err := c.adapter([]byte(c.config), bus)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			if err := drivers.CheckCapabilities(d); err != nil {
+				t.Error(err)
+			}
+			for _, cap := range d.Metadata().Capabilities {
+				if pins, _ := d.Pins(cap); len(pins) == 0 {
+					t.Error("No pins for advertised capability", cap.String())
+				}
+			}
+		})
+	}
+}
+
+// tplinkServer answers every request on a local port with the contents of
+// file, encrypted the way tplink devices do
+func tplinkServer(t *testing.T, file string) net.Listener {
+	resp, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, 4+len(resp))
+	binary.BigEndian.PutUint32(payload, uint32(len(resp)))
+	key := byte(0xAB)
+	for i, b := range resp {
+		key ^= b
+		payload[4+i] = key
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(c, header); err == nil {
+				io.CopyN(ioutil.Discard, c, int64(binary.BigEndian.Uint32(header)))
+				c.Write(payload)
+			}
+			c.Close()
+		}
+	}()
+	return l
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/reef-pi/hal"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/ezo"
+	"github.com/dmolavi/drivers/probe"
+)
+
+type command struct {
+	help string
+	run  func(*app, *flag.FlagSet, []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"analog":    {"Read an analog input pin", analog},
+		"calibrate": {"Calibrate an analog input pin interactively", calibrate},
+		"diag":      {"Report the health of the device behind a driver", diag},
+		"display":   {"Write four characters to the HT16K33 display", display},
+		"list":      {"List the registered drivers", list},
+		"outlet":    {"Switch a digital output, e.g. a TP-Link or DLI outlet, on or off", outlet},
+		"pwm":       {"Set a PWM channel, e.g. of a PCA9685, to a duty cycle", pwm},
+		"scan":      {"Scan the I2C bus, identify devices and suggest their configs", scan},
+		"schema":    {"Print the JSON Schema of a driver config", printSchema},
+	}
+}
+
+// driverFlags registers the flags selecting a driver and its config
+func driverFlags(fs *flag.FlagSet, name string) (*string, *string) {
+	driver := fs.String("driver", name, "Registered driver name, see list")
+	config := fs.String("config", "", "Driver config as JSON, or @file to read it from a file")
+	return driver, config
+}
+
+// reefPiDriver is the driver format exported by reef-pi
+type reefPiDriver struct {
+	Type       string          `json:"type"`
+	Parameters json.RawMessage `json:"parameters"`
+}
+
+// loadConfig resolves the config flag into its JSON. reef-pi driver exports
+// are unwrapped, their type is used when no driver is given
+func loadConfig(driver, config string) (string, []byte, error) {
+	data := []byte(config)
+	if strings.HasPrefix(config, "@") {
+		var err error
+		if data, err = ioutil.ReadFile(config[1:]); err != nil {
+			return "", nil, err
+		}
+	}
+	var r reefPiDriver
+	if err := json.Unmarshal(data, &r); err == nil && len(r.Parameters) > 0 {
+		data = r.Parameters
+		if driver == "" {
+			driver = r.Type
+		}
+	}
+	if driver == "" {
+		return "", nil, fmt.Errorf("missing driver, see list for registered drivers")
+	}
+	return driver, data, nil
+}
+
+func (a *app) build(driver, config string) (hal.Driver, error) {
+	name, data, err := loadConfig(driver, config)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.Build(name, data, a.bus)
+}
+
+func (a *app) analogPin(driver, config string, n int) (hal.Driver, hal.AnalogInputPin, error) {
+	d, err := a.build(driver, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	ad, ok := d.(hal.AnalogInputDriver)
+	if !ok {
+		d.Close()
+		return nil, nil, fmt.Errorf("driver %s has no analog inputs", d.Metadata().Name)
+	}
+	pin, err := ad.AnalogInputPin(n)
+	if err != nil {
+		d.Close()
+		return nil, nil, err
+	}
+	return d, pin, nil
+}
+
+func list(a *app, fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, m := range drivers.List() {
+		var caps []string
+		for _, c := range m.Capabilities {
+			caps = append(caps, c.String())
+		}
+		fmt.Fprintf(a.out, "%-26s %-40s %s\n", m.Name, strings.Join(caps, ","), m.Description)
+	}
+	return nil
+}
+
+func printSchema(a *app, fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: schema <driver>")
+	}
+	s, err := drivers.Schema(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(a.out, string(out))
+	return nil
+}
+
+func scan(a *app, fs *flag.FlagSet, args []string) error {
+	identify := fs.Bool("ezo", false, "Identify EZO circuits at 0x61-0x6f, this writes the information command to them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	probers := probe.DefaultProbers
+	if *identify {
+		probers = probe.AllProbers
+	}
+	devices, err := probe.Scan(a.bus, probers...)
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		fmt.Fprintln(a.out, "no devices found")
+	}
+	for _, d := range devices {
+		fmt.Fprintf(a.out, "0x%02x\t%s", d.Address, d.Name)
+		if d.Driver != "" {
+			fmt.Fprintf(a.out, "\t-driver %q -config '%s'", d.Driver, d.Config)
+		}
+		fmt.Fprintln(a.out)
+	}
+	return nil
+}
+
+func diag(a *app, fs *flag.FlagSet, args []string) error {
+	driver, config := driverFlags(fs, "")
+	timeout := fs.Duration("timeout", 5*time.Second, "Give up on the device after this long")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	d, err := a.build(*driver, *config)
+	if err != nil {
+		return err
+	}
+	// not closed, closing the pca9685 turns its outputs off by default
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	diagnosis, err := drivers.Diagnose(ctx, d)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(diagnosis, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(a.out, string(out))
+	return nil
+}
+
+func pwm(a *app, fs *flag.FlagSet, args []string) error {
+	driver, config := driverFlags(fs, "pca9685")
+	channel := fs.Int("channel", 0, "PWM channel")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: pwm [flags] <duty cycle 0-100>")
+	}
+	v, err := strconv.ParseFloat(fs.Arg(0), 64)
+	if err != nil {
+		return fmt.Errorf("invalid duty cycle %q: %v", fs.Arg(0), err)
+	}
+	d, err := a.build(*driver, *config)
+	if err != nil {
+		return err
+	}
+	// not closed, closing the pca9685 turns its outputs off by default
+	pd, ok := d.(hal.PWMDriver)
+	if !ok {
+		return fmt.Errorf("driver %s has no PWM channels", d.Metadata().Name)
+	}
+	ch, err := pd.PWMChannel(*channel)
+	if err != nil {
+		return err
+	}
+	return ch.Set(v)
+}
+
+func display(a *app, fs *flag.FlagSet, args []string) error {
+	blink := fs.Bool("blink", false, "Blink the display")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: display [flags] <text>")
+	}
+	h := drivers.NewHT16K33(a.bus)
+	if err := h.Setup(); err != nil {
+		return err
+	}
+	if err := h.Display(fmt.Sprintf("%-4s", strings.ToUpper(fs.Arg(0)))); err != nil {
+		return err
+	}
+	if *blink {
+		return h.Blink()
+	}
+	return nil
+}
+
+func outlet(a *app, fs *flag.FlagSet, args []string) error {
+	driver, config := driverFlags(fs, "")
+	n := fs.Int("pin", 0, "Outlet number")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (fs.Arg(0) != "on" && fs.Arg(0) != "off") {
+		return fmt.Errorf("usage: outlet [flags] on|off")
+	}
+	d, err := a.build(*driver, *config)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	od, ok := d.(hal.DigitalOutputDriver)
+	if !ok {
+		return fmt.Errorf("driver %s has no digital outputs", d.Metadata().Name)
+	}
+	pin, err := od.DigitalOutputPin(*n)
+	if err != nil {
+		return err
+	}
+	return pin.Write(fs.Arg(0) == "on")
+}
+
+func analog(a *app, fs *flag.FlagSet, args []string) error {
+	driver, config := driverFlags(fs, "")
+	n := fs.Int("pin", 0, "Analog input pin")
+	raw := fs.Bool("raw", false, "Print uncalibrated readings")
+	count := fs.Int("count", 1, "Number of readings")
+	interval := fs.Duration("interval", time.Second, "Delay between readings")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	d, pin, err := a.analogPin(*driver, *config, *n)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+		read := pin.Measure
+		if *raw {
+			read = pin.Read
+		}
+		v, err := read()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "%s\t%g\n", pin.Name(), v)
+	}
+	return nil
+}
+
+// calibrate prompts for the expected value of each calibration point and
+// reads the pin to observe it. The points are printed as JSON, reef-pi keeps
+// them with the sensor and passes them to Calibrate on start
+func calibrate(a *app, fs *flag.FlagSet, args []string) error {
+	driver, config := driverFlags(fs, "")
+	n := fs.Int("pin", 0, "Analog input pin")
+	points := fs.Int("points", 2, "Maximum number of calibration points")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	d, pin, err := a.analogPin(*driver, *config, *n)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	var ms []hal.Measurement
+	for i := 1; i <= *points; i++ {
+		fmt.Fprintf(a.out, "Point %d: place the probe in the calibration solution and enter its value (empty to finish): ", i)
+		line, err := a.in.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if err != nil && len(ms) == 0 {
+				return fmt.Errorf("no calibration points entered")
+			}
+			break
+		}
+		expected, perr := strconv.ParseFloat(line, 64)
+		if perr != nil {
+			return fmt.Errorf("invalid value %q: %v", line, perr)
+		}
+		observed, rerr := pin.Read()
+		if rerr != nil {
+			return rerr
+		}
+		fmt.Fprintf(a.out, "observed %g\n", observed)
+		// EZO circuits calibrate themselves, they take the solution value
+		if _, ok := drivers.UnwrapPin(pin).(*ezo.AtlasEZO); ok {
+			observed = expected
+		}
+		ms = append(ms, hal.Measurement{Expected: expected, Observed: observed})
+		if err != nil {
+			break
+		}
+	}
+	if len(ms) == 0 {
+		return fmt.Errorf("no calibration points entered")
+	}
+	if err := pin.Calibrate(ms); err != nil {
+		return err
+	}
+	out, err := json.Marshal(ms)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "calibration: %s\n", out)
+	v, err := pin.Measure()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "%s\t%g\n", pin.Name(), v)
+	return nil
+}
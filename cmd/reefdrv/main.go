@@ -0,0 +1,149 @@
+// Command reefdrv probes and operates devices supported by the drivers in
+// this repo, using the same adapters and JSON configs as reef-pi. It is meant
+// for commissioning a controller without writing Go:
+//
+//	reefdrv scan
+//	reefdrv pwm -config '{"address":"0x40","frequency":1500}' -channel 0 50
+//	reefdrv display REEF
+//	reefdrv outlet -driver tplink-hs103 -config '{"address":"192.168.1.10:9999"}' on
+//	reefdrv analog -driver ads1x15 -config @ads.json -pin 0
+//	reefdrv calibrate -driver "Atlas Scientific EZO(pH)" -config '{"address":99}'
+//	reefdrv diag -driver pca9685 -config '{"address":"0x40"}'
+//
+// Configs are given inline or read from a file with @path. reef-pi driver
+// exports ({"type":...,"parameters":{...}}) are accepted as well. Pass -sim
+// before the command to run it against the simulated devices of sim.NewTank.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/reef-pi/rpi/i2c"
+
+	_ "github.com/dmolavi/drivers/all"
+	"github.com/dmolavi/drivers/sim"
+)
+
+func main() {
+	fs := flag.NewFlagSet("reefdrv", flag.ExitOnError)
+	simulate := fs.Bool("sim", false, "Use simulated devices instead of /dev/i2c-1")
+	fs.Usage = func() { usage(fs) }
+	fs.Parse(os.Args[1:])
+
+	var bus i2c.Bus = new(lazyBus)
+	if *simulate {
+		bus = sim.NewTank()
+	}
+	defer bus.Close()
+	a := newApp(bus, os.Stdin, os.Stdout)
+	if err := a.run(fs.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "reefdrv:", err)
+		os.Exit(1)
+	}
+}
+
+func usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintln(out, "Usage: reefdrv [-sim] <command> [flags] [args]")
+	fmt.Fprintln(out, "\nCommands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].help)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	fs.PrintDefaults()
+}
+
+type app struct {
+	bus i2c.Bus
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newApp(bus i2c.Bus, in io.Reader, out io.Writer) *app {
+	return &app{
+		bus: bus,
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+func (a *app) run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command, run with -h for usage")
+	}
+	c, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(a.out)
+	return c.run(a, fs, args[1:])
+}
+
+// lazyBus opens the I2C bus on first use, so commands operating network
+// outlets or sysfs files work on hosts without /dev/i2c-1
+type lazyBus struct {
+	once sync.Once
+	bus  i2c.Bus
+	err  error
+}
+
+func (b *lazyBus) open() error {
+	b.once.Do(func() {
+		b.bus, b.err = i2c.New()
+	})
+	return b.err
+}
+
+func (b *lazyBus) SetAddress(addr byte) error {
+	if err := b.open(); err != nil {
+		return err
+	}
+	return b.bus.SetAddress(addr)
+}
+
+func (b *lazyBus) ReadBytes(addr byte, num int) ([]byte, error) {
+	if err := b.open(); err != nil {
+		return nil, err
+	}
+	return b.bus.ReadBytes(addr, num)
+}
+
+func (b *lazyBus) WriteBytes(addr byte, value []byte) error {
+	if err := b.open(); err != nil {
+		return err
+	}
+	return b.bus.WriteBytes(addr, value)
+}
+
+func (b *lazyBus) ReadFromReg(addr, reg byte, value []byte) error {
+	if err := b.open(); err != nil {
+		return err
+	}
+	return b.bus.ReadFromReg(addr, reg, value)
+}
+
+func (b *lazyBus) WriteToReg(addr, reg byte, value []byte) error {
+	if err := b.open(); err != nil {
+		return err
+	}
+	return b.bus.WriteToReg(addr, reg, value)
+}
+
+func (b *lazyBus) Close() error {
+	if b.bus == nil {
+		return nil
+	}
+	return b.bus.Close()
+}
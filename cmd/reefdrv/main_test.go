@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dmolavi/drivers/sim"
+)
+
+func runCmd(t *testing.T, tank *sim.Tank, in string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := newApp(tank, strings.NewReader(in), &out).run(args)
+	return out.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	tank := sim.NewTank()
+	if _, err := runCmd(t, tank, "", "bogus"); err == nil {
+		t.Error("Unknown commands should fail")
+	}
+	out, err := runCmd(t, tank, "", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "pca9685") || !strings.Contains(out, "tplink-hs103") {
+		t.Error("Missing drivers in list:", out)
+	}
+	if out, err = runCmd(t, tank, "", "schema", "pca9685"); err != nil || !strings.Contains(out, `"frequency"`) {
+		t.Error("Unexpected schema:", out, err)
+	}
+
+	if _, err := runCmd(t, tank, "", "pwm", "-config", `{"address":"0x40", "frequency":1500}`, "-channel", "3", "40"); err != nil {
+		t.Fatal(err)
+	}
+	if duty, _ := tank.PCA9685.Duty(3); math.Abs(duty-40) > 0.05 {
+		t.Error("Unexpected duty:", duty)
+	}
+
+	if _, err := runCmd(t, tank, "", "display", "-blink", "reef"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if tank.HT16K33.Segments(i) == 0 {
+			t.Error("Digit", i, "is blank")
+		}
+	}
+	if tank.HT16K33.Blink() == 0 {
+		t.Error("Display should blink")
+	}
+
+	tank.ADS1115.SetInput(0, sim.Constant(1.25))
+	out, err = runCmd(t, tank, "", "analog", "-driver", "ads1x15", "-config", `{"address":"0x48"}`, "-count", "2", "-interval", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "A0\t1.25\nA0\t1.25\n" {
+		t.Errorf("Unexpected readings: %q", out)
+	}
+
+	if _, err := runCmd(t, tank, "", "outlet", "-driver", "ads1x15", "-config", `{"address":"0x48"}`, "on"); err == nil {
+		t.Error("Switching a driver without digital outputs should fail")
+	}
+
+	out, err = runCmd(t, tank, "", "diag", "-driver", "pca9685", "-config", `{"address":"0x40"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `"reachable": true`) || !strings.Contains(out, `"hardware": "PCA9685"`) {
+		t.Error("Unexpected diagnosis:", out)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	tank := sim.NewTank()
+	tank.ADS1115.SetInput(0, sim.Constant(1.25))
+	out, err := runCmd(t, tank, "2\n\n", "calibrate", "-driver", "ads1x15", "-config", `{"address":"0x48"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `calibration: [{"expected":2,"observed":1.25}]`) {
+		t.Error("Missing calibration points:", out)
+	}
+	if !strings.HasSuffix(out, "A0\t2\n") {
+		t.Error("Calibrated reading should match the expected value:", out)
+	}
+	if _, err := runCmd(t, tank, "", "calibrate", "-driver", "ads1x15", "-config", `{"address":"0x48"}`); err == nil {
+		t.Error("Calibrating without points should fail")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reefdrv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "driver.json")
+	export := `{"id":"1", "name":"dosers", "type":"pca9685", "parameters":{"address":64}}`
+	if err := ioutil.WriteFile(p, []byte(export), 0644); err != nil {
+		t.Fatal(err)
+	}
+	name, conf, err := loadConfig("", "@"+p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "pca9685" || string(conf) != `{"address":64}` {
+		t.Error("Unexpected config:", name, string(conf))
+	}
+	if _, _, err := loadConfig("", `{"address":64}`); err == nil {
+		t.Error("Plain configs should require a driver name")
+	}
+}
+
+func TestScan(t *testing.T) {
+	tank := sim.NewTank()
+	tank.Detach(0x40)
+	tank.Detach(0x41)
+	tank.Detach(0x48)
+	out, err := runCmd(t, tank, "", "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "0x63\tunknown\n0x70\tHT16K33 (by address)\n"; out != expected {
+		t.Errorf("Unexpected scan: %q", out)
+	}
+	out, err = runCmd(t, tank, "", "scan", "-ezo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "0x63\tEZO pH (firmware 1.98)\t-driver \"Atlas Scientific EZO(pH)\" -config '{\"address\":99}'\n" +
+		"0x70\tHT16K33 (by address)\n"
+	if out != expected {
+		t.Errorf("Unexpected scan: %q", out)
+	}
+}
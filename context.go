@@ -0,0 +1,70 @@
+package drivers
+
+import (
+	"context"
+	"time"
+
+	"github.com/reef-pi/hal"
+)
+
+// AnalogInputContext is implemented by analog input pins whose reads can be
+// cancelled. Their plain Read and Measure run with a default deadline
+type AnalogInputContext interface {
+	ReadContext(context.Context) (float64, error)
+	MeasureContext(context.Context) (float64, error)
+}
+
+// DigitalOutputContext is implemented by digital output pins whose writes can
+// be cancelled. Their plain Write runs with a default deadline
+type DigitalOutputContext interface {
+	WriteContext(context.Context, bool) error
+}
+
+// ReadContext reads p, honouring ctx when p supports cancellation. Other pins
+// are only read when ctx is not done yet
+func ReadContext(ctx context.Context, p hal.AnalogInputPin) (float64, error) {
+	if c, ok := p.(AnalogInputContext); ok {
+		return c.ReadContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return p.Read()
+}
+
+// MeasureContext measures p, honouring ctx when p supports cancellation
+func MeasureContext(ctx context.Context, p hal.AnalogInputPin) (float64, error) {
+	if c, ok := p.(AnalogInputContext); ok {
+		return c.MeasureContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return p.Measure()
+}
+
+// WriteContext writes p, honouring ctx when p supports cancellation
+func WriteContext(ctx context.Context, p hal.DigitalOutputPin, state bool) error {
+	if c, ok := p.(DigitalOutputContext); ok {
+		return c.WriteContext(ctx, state)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.Write(state)
+}
+
+// Sleep pauses for d, returning early with the context error when ctx is done
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,64 @@
+package drivers_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/reef-pi/hal"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/ads1x15"
+	"github.com/dmolavi/drivers/sim"
+)
+
+func TestContext(t *testing.T) {
+	tank := sim.NewTank()
+	tank.ADS1115.SetInput(0, sim.Constant(1))
+	d, err := ads1x15.HalAdapter([]byte(`{"address":"0x48"}`), tank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ads, _ := d.(hal.AnalogInputDriver).AnalogInputPin(0)
+	noop := hal.NewNoopDriver()
+	plain, _ := noop.AnalogInputPin(0)
+	out, _ := noop.DigitalOutputPin(0)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, pin := range []hal.AnalogInputPin{ads, plain} {
+		if _, ok := pin.(drivers.AnalogInputContext); ok != (pin == ads) {
+			t.Error("Unexpected context support of", pin.Name())
+		}
+		if _, err := drivers.ReadContext(cancelled, pin); err != context.Canceled {
+			t.Error("Expected cancellation, found:", err)
+		}
+		if _, err := drivers.MeasureContext(cancelled, pin); err != context.Canceled {
+			t.Error("Expected cancellation, found:", err)
+		}
+		if _, err := drivers.MeasureContext(context.Background(), pin); err != nil {
+			t.Error(err)
+		}
+	}
+	if v, err := drivers.ReadContext(context.Background(), ads); err != nil || math.Abs(v-1) > 0.001 {
+		t.Error("Unexpected reading:", v, err)
+	}
+	if err := drivers.WriteContext(cancelled, out, true); err != context.Canceled {
+		t.Error("Expected cancellation, found:", err)
+	}
+	if err := drivers.WriteContext(context.Background(), out, true); err != nil {
+		t.Error(err)
+	}
+
+	start := time.Now()
+	if err := drivers.Sleep(cancelled, time.Minute); err != context.Canceled {
+		t.Error("Expected cancellation, found:", err)
+	}
+	if err := drivers.Sleep(context.Background(), time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Error("Cancelled sleep should return immediately, took:", d)
+	}
+}
@@ -0,0 +1,89 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/reef-pi/hal"
+)
+
+// Diagnosis is a snapshot of the health of the device behind a driver
+type Diagnosis struct {
+	// Reachable reports whether the device answered the diagnosis
+	Reachable     bool      `json:"reachable"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time"`
+	Firmware      string    `json:"firmware,omitempty"`
+	Hardware      string    `json:"hardware,omitempty"`
+	// SupplyVoltage is in volts, 0 when unknown
+	SupplyVoltage float64 `json:"supply_voltage,omitempty"`
+	// RSSI is the WiFi signal strength in dBm, 0 when unknown
+	RSSI float64 `json:"rssi,omitempty"`
+	// Fields holds driver specific details, like settings or counters
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Set adds a driver specific field
+func (d *Diagnosis) Set(name string, value interface{}) {
+	if d.Fields == nil {
+		d.Fields = make(map[string]string)
+	}
+	d.Fields[name] = fmt.Sprint(value)
+}
+
+// Diagnostics is implemented by drivers reporting the health of their device
+type Diagnostics interface {
+	// Diagnose queries the device, giving up when ctx is done
+	Diagnose(ctx context.Context) Diagnosis
+}
+
+// Diagnose returns the diagnosis of d, or an error when d does not
+// implement Diagnostics
+func Diagnose(ctx context.Context, d hal.Driver) (Diagnosis, error) {
+	dd, ok := d.(Diagnostics)
+	if !ok {
+		return Diagnosis{}, fmt.Errorf("driver %s does not support diagnostics", d.Metadata().Name)
+	}
+	return dd.Diagnose(ctx), nil
+}
+
+// ErrorLog keeps the last I/O error of a device for its Diagnosis. The zero
+// value is ready to use, and it is safe for concurrent use
+type ErrorLog struct {
+	mu  sync.Mutex
+	err string
+	at  time.Time
+}
+
+// Record notes err, when not nil, and returns it
+func (l *ErrorLog) Record(err error) error {
+	if err == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = err.Error()
+	l.at = time.Now()
+	return err
+}
+
+// Report sets the last error of d, unless d holds a more recent one
+func (l *ErrorLog) Report(d *Diagnosis) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != "" && !l.at.Before(d.LastErrorTime) {
+		d.LastError = l.err
+		d.LastErrorTime = l.at
+	}
+}
+
+// Diagnosis records the outcome of a probe of the device, and returns a
+// Diagnosis with its reachability and the last error
+func (l *ErrorLog) Diagnosis(err error) Diagnosis {
+	l.Record(err)
+	d := Diagnosis{Reachable: err == nil}
+	l.Report(&d)
+	return d
+}
@@ -0,0 +1,77 @@
+package drivers_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/sim"
+)
+
+func TestErrorLog(t *testing.T) {
+	var l drivers.ErrorLog
+	if d := l.Diagnosis(nil); !d.Reachable || d.LastError != "" || !d.LastErrorTime.IsZero() {
+		t.Errorf("Unexpected diagnosis: %+v", d)
+	}
+	if l.Record(nil) != nil {
+		t.Error("Recording nil should return nil")
+	}
+	err := errors.New("nack")
+	if l.Record(err) != err {
+		t.Error("Expected the recorded error")
+	}
+	d := l.Diagnosis(nil)
+	if !d.Reachable || d.LastError != "nack" || d.LastErrorTime.IsZero() {
+		t.Errorf("Unexpected diagnosis: %+v", d)
+	}
+	d.Set("gain", 2)
+	if d.Fields["gain"] != "2" {
+		t.Error("Unexpected fields:", d.Fields)
+	}
+	if _, err := drivers.Diagnose(context.Background(), hal.NewNoopDriver()); err == nil {
+		t.Error("Drivers without diagnostics should fail")
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	tank := sim.NewTank()
+	display := drivers.NewHT16K33(i2c.Bus(tank))
+	if err := display.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if d := display.Diagnose(context.Background()); !d.Reachable || d.Hardware != "HT16K33" {
+		t.Errorf("Unexpected diagnosis: %+v", d)
+	}
+
+	d, err := drivers.Build("pca9685", []byte(`{"address":64,"retry":{"attempts":2,"backoff":1,"threshold":1}}`), tank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diag, err := drivers.Diagnose(context.Background(), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diag.Reachable || diag.Hardware != "PCA9685" || diag.Fields["breaker"] != "closed" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+	ch, err := d.(hal.PWMDriver).PWMChannel(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tank.Detach(0x40)
+	tank.Detach(0x70)
+	if err := ch.Set(50); err == nil {
+		t.Error("Expected the detached device to fail")
+	}
+	diag, _ = drivers.Diagnose(context.Background(), d)
+	if diag.Reachable || diag.LastError == "" || diag.Fields["breaker"] != "open" || diag.Fields["retries"] != "1" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+	if d := display.Diagnose(context.Background()); d.Reachable || d.LastError == "" {
+		t.Errorf("Unexpected diagnosis: %+v", d)
+	}
+}
@@ -2,24 +2,57 @@ package dli
 
 import (
     "bytes"
+    "context"
     "crypto/md5"
     "crypto/rand"
     "encoding/hex"
     "fmt"
     "io"
     "io/ioutil"
-    "log"
     "net/http"
     "strings"
+    "sync"
     "encoding/json"
     "strconv"
+    "time"
+    "github.com/dmolavi/drivers"
+    "github.com/dmolavi/drivers/schema"
     "github.com/reef-pi/hal"
     "github.com/reef-pi/rpi/i2c"
 )
 
+var driverMeta = hal.Metadata {
+    Name: "dli-pro",
+    Description: "Digital Loggers Web Pro Switch driver",
+    Capabilities: []hal.Capability{
+        hal.DigitalOutput,
+    },
+}
+
+var configSchema = schema.Object(schema.Properties{
+    "address": schema.String().NonEmpty().Describe("host:port of the switch"),
+    "user": schema.String(),
+    "password": schema.String(),
+}, "address")
+
+func init() {
+    drivers.Register(driverMeta, configSchema, DLIWebProSwitchHALAdapter)
+}
+
+// deadline of the plain Write
+const _timeout = 5 * time.Second
+
+const _outlets = "/restapi/relay/outlets/"
+
 type DLIWebProSwitch struct {
+    mu sync.Mutex // guards state, held across writes
     state bool
+    address string
+    user string
+    password string
+    client *http.Client
     meta hal.Metadata
+    errs drivers.ErrorLog
 }
 
 type (
@@ -32,97 +65,104 @@ type (
 
 func NewDLIWebProSwitch(addr string, user string, password string) *DLIWebProSwitch {
     return &DLIWebProSwitch {
-        meta: hal.Metadata {
-            Name: "dli-pro",
-            Description: "Digital Loggers Web Pro Switch driver",
-            Capabilities: []hal.Capability{
-                hal.DigitalOutput,
-            },
-        },	
+        address: addr,
+        user: user,
+        password: password,
+        client: &http.Client{},
+        meta: driverMeta,
     }
 }
 
-func (p *DLIWebProSwitch) LastState() bool {
-    var conf Config
-    url := "http://"+conf.Address
-    var args = "/restapi/relay/outlets/"
-    method := "GET"
-	req, err := http.NewRequest(method, url+args, nil)
-    req.Header.Set("Content-Type", "application/json")
-    client := &http.Client{}
-    resp, err := client.Do(req)
+// request sends method to the outlets endpoint, answering the digest
+// challenge of the switch when it sends one. ctx bounds both round trips
+func (p *DLIWebProSwitch) request(ctx context.Context, method string, body []byte) (*http.Response, error) {
+    resp, err := p.roundTrip(ctx, method, body)
+    if err == nil && resp.StatusCode/100 != 2 {
+        p.errs.Record(fmt.Errorf("%s %s: status %d", method, _outlets, resp.StatusCode))
+    }
+    return resp, p.errs.Record(err)
+}
+
+func (p *DLIWebProSwitch) roundTrip(ctx context.Context, method string, body []byte) (*http.Response, error) {
+    newRequest := func() (*http.Request, error) {
+        req, err := http.NewRequestWithContext(ctx, method, "http://"+p.address+_outlets, bytes.NewReader(body))
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("Content-Type", "application/json")
+        req.Header.Set("X-Requested-With", "XMLHttpRequest")
+        return req, nil
+    }
+    req, err := newRequest()
     if err != nil {
-        panic(err)
+        return nil, err
+    }
+    resp, err := p.client.Do(req)
+    if err != nil {
+        return nil, err
     }
-    defer resp.Body.Close()
     if resp.StatusCode != http.StatusUnauthorized {
-        log.Printf("Recieved status code '%v' auth skipped", resp.StatusCode)
-        return true
+        return resp, nil
     }
+    resp.Body.Close()
     digestParts := digestParts(resp)
-    digestParts["uri"] = args
+    digestParts["uri"] = _outlets
     digestParts["method"] = method
-    digestParts["username"] = conf.User
-    digestParts["password"] = conf.Password
-    req, err = http.NewRequest(method, url+args, nil)
-    req.Header.Set("Authorization", getDigestAuthorization(digestParts))
-    req.Header.Set("Content-Type", "application/json")
-    resp, err = client.Do(req)
-    if err != nil {
-        panic(err)
+    digestParts["username"] = p.user
+    digestParts["password"] = p.password
+    if req, err = newRequest(); err != nil {
+        return nil, err
     }
-    defer resp.Body.Close()
-    if resp.StatusCode != http.StatusOK {
-        body, err := ioutil.ReadAll(resp.Body)
-        if err != nil {
-            panic(err)
+    req.Header.Set("Authorization", getDigestAuthorization(digestParts))
+    return p.client.Do(req)
+}
+
+// LastState returns the state set by the last successful write
+func (p *DLIWebProSwitch) LastState() bool {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.state
+}
+
+// Diagnose fetches the outlet states, which needs valid credentials
+func (p *DLIWebProSwitch) Diagnose(ctx context.Context) drivers.Diagnosis {
+    resp, err := p.request(ctx, "GET", nil)
+    if err == nil {
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            err = fmt.Errorf("failed to fetch outlet state, status %d", resp.StatusCode)
         }
-        log.Println("response body: ", string(body))
-        return false
     }
-    return true
+    diag := p.errs.Diagnosis(err)
+    diag.Hardware = "DLI Web Power Switch Pro"
+    diag.Set("address", p.address)
+    return diag
 }
 
 func (p *DLIWebProSwitch) Write(state bool) error {
-    var conf Config
-    url := "http://"+conf.Address
-    var args = "/restapi/relay/outlets/"
-    method := "PUT"
-	req, err := http.NewRequest(method, url+args, nil)
-    req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Requested-With", "XMLHttpRequest")	
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        panic(err)
-    }
-    defer resp.Body.Close()
-    if resp.StatusCode != http.StatusUnauthorized {
-        log.Printf("Recieved status code '%v' auth skipped", resp.StatusCode)
-        return nil	
-    }
-    digestParts := digestParts(resp)
-    digestParts["uri"] = args
-    digestParts["method"] = method
-    digestParts["username"] = "admin"
-    digestParts["password"] = "4321"
-	req, err = http.NewRequest(method, url+args, bytes.NewBuffer([]byte(strconv.FormatBool(state))))
-    req.Header.Set("Authorization", getDigestAuthorization(digestParts))
-    req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Requested-With", "XMLHttpRequest")
-    resp, err = client.Do(req)
+    ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+    defer cancel()
+    return p.WriteContext(ctx, state)
+}
+
+func (p *DLIWebProSwitch) WriteContext(ctx context.Context, state bool) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    resp, err := p.request(ctx, "PUT", []byte(strconv.FormatBool(state)))
     if err != nil {
-        panic(err)
+        return err
     }
     defer resp.Body.Close()
-    if resp.StatusCode != http.StatusOK {
-        body, err := ioutil.ReadAll(resp.Body)
-        if err != nil {
-            panic(err)
+    if resp.StatusCode/100 != 2 {
+        body, _ := ioutil.ReadAll(resp.Body)
+        err := fmt.Errorf("failed to switch outlet, status %d: %s", resp.StatusCode, string(body))
+        // the switch is busy or rebooting, unlike rejected credentials
+        if resp.StatusCode/100 == 5 {
+            return drivers.Transient(err)
         }
-        log.Println("response body: ", string(body))
-        return nil
+        return err
     }
+    p.state = state
     return nil
 }
 
@@ -175,6 +215,10 @@ func (p *DLIWebProSwitch) Number() int {
 }
 
 func DLIWebProSwitchHALAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
+	c, err := configSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
 	var conf Config
 	if err := json.Unmarshal(c, &conf); err != nil {
 		return nil, err
@@ -202,12 +246,7 @@ func (p *DLIWebProSwitch) Close() error {
 }
 
 func (p *DLIWebProSwitch) Pins(cap hal.Capability) ([]hal.Pin, error) {
-	switch cap {
-	case hal.DigitalOutput:
-		return []hal.Pin{p}, nil
-	default:
-		return nil, fmt.Errorf("unsupported capability:%s", cap.String())
-	}
+	return drivers.Pins(p, cap)
 }
 
 //func main() {
@@ -1,9 +1,71 @@
 package dli
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDLIWebProSwitch(t *testing.T) {
-	
+	var body, auth string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Digest realm="switch", nonce="abc", qop="auth", opaque="xyz", algorithm="MD5"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "GET" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		body, auth = string(b), r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	p := NewDLIWebProSwitch(strings.TrimPrefix(s.URL, "http://"), "admin", "secret")
+	if err := p.Write(true); err != nil {
+		t.Fatal(err)
+	}
+	if body != "true" {
+		t.Error("Expected state true, sent:", body)
+	}
+	if !p.LastState() {
+		t.Error("Expected last state true")
+	}
+	if !strings.Contains(auth, `username="admin"`) || !strings.Contains(auth, `nonce="abc"`) {
+		t.Error("Unexpected authorization:", auth)
+	}
+	if diag := p.Diagnose(context.Background()); !diag.Reachable || diag.LastError != "" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+	s.Close()
+	if diag := p.Diagnose(context.Background()); diag.Reachable || diag.LastError == "" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+}
+
+func TestDLIWebProSwitchContext(t *testing.T) {
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer s.Close()
+	defer close(release)
+
+	p := NewDLIWebProSwitch(strings.TrimPrefix(s.URL, "http://"), "", "")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.WriteContext(ctx, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected deadline exceeded, found:", err)
+	}
+	if p.LastState() {
+		t.Error("Failed writes should not change the state")
+	}
 }
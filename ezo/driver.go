@@ -1,22 +1,42 @@
 package ezo
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/schema"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
 
+var driverMeta = hal.Metadata{
+	Name:         _ezoName,
+	Description:  "Atlas Scientific EZO board for pH sensor",
+	Capabilities: []hal.Capability{hal.AnalogInput},
+}
+
+var configSchema = schema.Object(schema.Properties{
+	"address": schema.Address().WithDefault(0x63).Describe("I2C address of the EZO circuit"),
+})
+
+func init() {
+	drivers.Register(driverMeta, configSchema, EzoHalAdapter)
+}
+
 /*
 https://www.atlas-scientific.com/_files/_datasheets/_circuit/pH_EZO_datasheet.pdf
 */
 
 const (
 	_ezoName = "Atlas Scientific EZO(pH)"
+	// deadline of the plain Read and Measure, readings take 900ms
+	_timeout = 3 * time.Second
 )
 
 type AtlasEZO struct {
@@ -24,6 +44,10 @@ type AtlasEZO struct {
 	bus   i2c.Bus
 	delay time.Duration
 	meta  hal.Metadata
+	// held from sending a command until its response is read, shared with
+	// every AtlasEZO at the same address
+	mu   *sync.Mutex
+	errs drivers.ErrorLog
 }
 
 func NewAtlasEZO(addr byte, bus i2c.Bus) *AtlasEZO {
@@ -31,16 +55,13 @@ func NewAtlasEZO(addr byte, bus i2c.Bus) *AtlasEZO {
 		addr:  addr,
 		bus:   bus,
 		delay: time.Second,
-		meta: hal.Metadata{
-			Name:         _ezoName,
-			Description:  "Atlas Scientific EZO board for pH sensor",
-			Capabilities: []hal.Capability{hal.AnalogInput},
-		},
+		meta:  driverMeta,
+		mu:    drivers.DeviceLock(bus, addr),
 	}
 }
 
-func (a *AtlasEZO) extractIntResponse() (int, error) {
-	resp, err := a.read()
+func (a *AtlasEZO) extractIntResponse(cmd string) (int, error) {
+	resp, err := a.query(context.Background(), cmd)
 	if err != nil {
 		return 0, err
 	}
@@ -51,8 +72,8 @@ func (a *AtlasEZO) extractIntResponse() (int, error) {
 	return strconv.Atoi(parts[1])
 }
 
-func (a *AtlasEZO) extractFloatResponse() (float64, error) {
-	resp, err := a.read()
+func (a *AtlasEZO) extractFloatResponse(cmd string) (float64, error) {
+	resp, err := a.query(context.Background(), cmd)
 	if err != nil {
 		return 0, err
 	}
@@ -64,11 +85,43 @@ func (a *AtlasEZO) extractFloatResponse() (float64, error) {
 }
 
 func (a *AtlasEZO) command(cmd string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.errs.Record(a.commandContext(context.Background(), cmd))
+}
+
+// query sends cmd and reads its response
+func (a *AtlasEZO) query(ctx context.Context, cmd string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.commandContext(ctx, cmd); err != nil {
+		return "", a.errs.Record(err)
+	}
+	resp, err := a.read()
+	return resp, a.errs.Record(err)
+}
+
+// calibrate sends a calibration command, which takes longer than others
+func (a *AtlasEZO) calibrate(cmd string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.commandContext(context.Background(), cmd); err != nil {
+		return a.errs.Record(err)
+	}
+	time.Sleep(600 * time.Millisecond)
+	return nil
+}
+
+// commandContext sends cmd and waits for the circuit to process it, the wait
+// ends early when ctx is done
+func (a *AtlasEZO) commandContext(ctx context.Context, cmd string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if err := a.bus.WriteBytes(a.addr, []byte(cmd+"\000")); err != nil {
 		return err
 	}
-	time.Sleep(a.delay)
-	return nil
+	return drivers.Sleep(ctx, a.delay)
 }
 
 func (a *AtlasEZO) read() (string, error) {
@@ -77,7 +130,12 @@ func (a *AtlasEZO) read() (string, error) {
 		return "", err
 	}
 	if payload[0] != byte(1) {
-		return "", fmt.Errorf("Failed to execute. Error:%s", string(payload))
+		err := fmt.Errorf("Failed to execute. Error:%s", string(payload))
+		// 254: still processing, 255: no data to send
+		if payload[0] == 254 || payload[0] == 255 {
+			return "", drivers.Transient(err)
+		}
+		return "", err
 	}
 	p := strings.Trim(string(payload[1:]), "\000")
 	return p, nil
@@ -92,10 +150,7 @@ func (a *AtlasEZO) LedOff() error {
 }
 
 func (a *AtlasEZO) LedState() (bool, error) {
-	if err := a.command("L,?"); err != nil {
-		return false, err
-	}
-	i, err := a.extractIntResponse()
+	i, err := a.extractIntResponse("L,?")
 	if err != nil {
 		return false, err
 	}
@@ -107,27 +162,15 @@ func (a *AtlasEZO) Baud(n int) error {
 }
 
 func (a *AtlasEZO) CalibrateMid(n float64) error {
-	if err := a.command(fmt.Sprintf("Cal,mid,%f", n)); err != nil {
-		return err
-	}
-	time.Sleep(600 * time.Millisecond)
-	return nil
+	return a.calibrate(fmt.Sprintf("Cal,mid,%f", n))
 }
 
 func (a *AtlasEZO) CalibrateHigh(n float64) error {
-	if err := a.command(fmt.Sprintf("Cal,high,%f", n)); err != nil {
-		return err
-	}
-	time.Sleep(600 * time.Millisecond)
-	return nil
+	return a.calibrate(fmt.Sprintf("Cal,high,%f", n))
 }
 
 func (a *AtlasEZO) CalibrateLow(n float64) error {
-	if err := a.command(fmt.Sprintf("Cal,low,%f", n)); err != nil {
-		return err
-	}
-	time.Sleep(600 * time.Millisecond)
-	return nil
+	return a.calibrate(fmt.Sprintf("Cal,low,%f", n))
 }
 
 func (a *AtlasEZO) ClearCalibration() error {
@@ -135,10 +178,7 @@ func (a *AtlasEZO) ClearCalibration() error {
 }
 
 func (a *AtlasEZO) IsCalibrated() (int, error) {
-	if err := a.command("Cal,?"); err != nil {
-		return 0, err
-	}
-	return a.extractIntResponse()
+	return a.extractIntResponse("Cal,?")
 }
 
 func (a *AtlasEZO) Factory() error {
@@ -150,10 +190,7 @@ func (a *AtlasEZO) Find() error {
 }
 
 func (a *AtlasEZO) Information() (string, string, error) {
-	if err := a.command("i"); err != nil {
-		return "", "", err
-	}
-	resp, err := a.read()
+	resp, err := a.query(context.Background(), "i")
 	if err != nil {
 		return "", "", err
 	}
@@ -165,10 +202,13 @@ func (a *AtlasEZO) Information() (string, string, error) {
 }
 
 func (a *AtlasEZO) Read() (float64, error) {
-	if err := a.command("R"); err != nil {
-		return 0, err
-	}
-	v, err := a.read()
+	ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+	defer cancel()
+	return a.ReadContext(ctx)
+}
+
+func (a *AtlasEZO) ReadContext(ctx context.Context) (float64, error) {
+	v, err := a.query(ctx, "R")
 	if err != nil {
 		return 0, err
 	}
@@ -180,11 +220,8 @@ func (a *AtlasEZO) Sleep() error {
 }
 
 func (a *AtlasEZO) Status() (string, string, error) {
-	if err := a.command("Status"); err != nil {
-		return "", "", err
-	}
 	//?Status,P,5.038
-	resp, err := a.read()
+	resp, err := a.query(context.Background(), "Status")
 	if err != nil {
 		return "", "", err
 	}
@@ -195,11 +232,46 @@ func (a *AtlasEZO) Status() (string, string, error) {
 	return parts[1], parts[2], nil
 }
 
-func (a *AtlasEZO) GetTC() (float64, error) {
-	if err := a.command("T,?"); err != nil {
-		return 0, err
+var restartReasons = map[string]string{
+	"P": "powered off",
+	"S": "software reset",
+	"B": "brown out",
+	"W": "watchdog",
+	"U": "unknown",
+}
+
+// Diagnose reports the circuit type, firmware version, supply voltage,
+// restart reason and number of calibration points
+func (a *AtlasEZO) Diagnose(ctx context.Context) drivers.Diagnosis {
+	//?i,pH,1.98
+	resp, err := a.query(ctx, "i")
+	d := a.errs.Diagnosis(err)
+	if err != nil {
+		return d
+	}
+	if parts := strings.Split(resp, ","); len(parts) == 3 {
+		d.Hardware = "EZO " + parts[1]
+		d.Firmware = parts[2]
 	}
-	return a.extractFloatResponse()
+	//?Status,P,5.038
+	if resp, err := a.query(ctx, "Status"); err == nil {
+		if parts := strings.Split(resp, ","); len(parts) == 3 {
+			d.Set("restart_reason", restartReasons[parts[1]])
+			d.SupplyVoltage, _ = strconv.ParseFloat(parts[2], 64)
+		}
+	}
+	//?Cal,2
+	if resp, err := a.query(ctx, "Cal,?"); err == nil {
+		if parts := strings.Split(resp, ","); len(parts) == 2 {
+			d.Set("calibration_points", parts[1])
+		}
+	}
+	a.errs.Report(&d)
+	return d
+}
+
+func (a *AtlasEZO) GetTC() (float64, error) {
+	return a.extractFloatResponse("T,?")
 }
 
 func (a *AtlasEZO) SetTC(t float64) error {
@@ -241,6 +313,10 @@ func (a *AtlasEZO) Measure() (float64, error) {
 	return a.Read()
 }
 
+func (a *AtlasEZO) MeasureContext(ctx context.Context) (float64, error) {
+	return a.ReadContext(ctx)
+}
+
 func (a *AtlasEZO) AnalogInputPin(u int) (hal.AnalogInputPin, error) {
 	if u != 0 {
 		return nil, fmt.Errorf("EZO pH driver has only one valid channel: 0. Asked:%d", u)
@@ -252,12 +328,7 @@ func (a *AtlasEZO) AnalogInputPins() []hal.AnalogInputPin {
 	return []hal.AnalogInputPin{a}
 }
 func (a *AtlasEZO) Pins(cap hal.Capability) ([]hal.Pin, error) {
-	switch cap {
-	case hal.AnalogInput:
-		return []hal.Pin{a}, nil
-	default:
-		return nil, fmt.Errorf("unsupported capability:%s", cap.String())
-	}
+	return drivers.Pins(a, cap)
 }
 
 type EzoConfig struct {
@@ -265,6 +336,10 @@ type EzoConfig struct {
 }
 
 func EzoHalAdapter(conf []byte, b i2c.Bus) (hal.Driver, error) {
+	conf, err := configSchema.Apply(conf)
+	if err != nil {
+		return nil, err
+	}
 	var config EzoConfig
 	if err := json.Unmarshal(conf, &config); err != nil {
 		return nil, err
@@ -1,11 +1,17 @@
 package ezo
 
 import (
+	"context"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/reef-pi/hal"
 
 	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers/i2ctest"
+	"github.com/dmolavi/drivers/sim"
 )
 
 func TestEZO(t *testing.T) {
@@ -55,9 +61,67 @@ func TestEZO(t *testing.T) {
 
 }
 
+func TestEZOContext(t *testing.T) {
+	bus := i2c.MockBus()
+	bus.Bytes = append([]byte{1}, []byte("7.00")...)
+	e := NewAtlasEZO(byte(0x63), bus)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := e.ReadContext(ctx); err != context.DeadlineExceeded {
+		t.Error("Expected deadline exceeded, found:", err)
+	}
+	if d := time.Since(start); d > e.delay/2 {
+		t.Error("Read should stop waiting at the deadline, took:", d)
+	}
+	if _, err := e.MeasureContext(ctx); err == nil {
+		t.Error("Expired context should fail before writing")
+	}
+	e.delay = time.Millisecond
+	if v, err := e.MeasureContext(context.Background()); err != nil || v != 7 {
+		t.Error("Unexpected reading:", v, err)
+	}
+}
+
+func TestEZOConcurrency(t *testing.T) {
+	circuit := sim.NewEZO()
+	circuit.ReadDelay, circuit.CommandDelay = 0, 0
+	bus := sim.NewBus()
+	if err := bus.Attach(0x63, circuit); err != nil {
+		t.Fatal(err)
+	}
+	e := NewAtlasEZO(0x63, bus)
+	e.delay = time.Millisecond
+	// a second instance at the same address shares the device lock
+	other := NewAtlasEZO(0x63, bus)
+	other.delay = time.Millisecond
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if v, err := e.Read(); err != nil || v != 7 {
+					t.Error("Unexpected reading:", v, err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if on, err := other.LedState(); err != nil || !on {
+					t.Error("Unexpected led state:", on, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func TestEZOHalAdapter(t *testing.T) {
 	bus := i2c.MockBus()
-	_, err := EzoHalAdapter([]byte(""), bus)
+	_, err := EzoHalAdapter([]byte("{"), bus)
 	if err == nil {
 		t.Error("Adapter creation should fail when json config is invalid")
 	}
@@ -98,3 +162,75 @@ func TestEZOHalAdapter(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// response pads an EZO reply to the 31 byte read the driver issues
+func response(code byte, s string) []byte {
+	buf := make([]byte, 31)
+	buf[0] = code
+	copy(buf[1:], s)
+	return buf
+}
+
+func command(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func TestEZOProtocol(t *testing.T) {
+	bus := i2ctest.NewScript(
+		i2ctest.Write(0x63, command("R")...),
+		i2ctest.Read(0x63, response(1, "7.021")...),
+		i2ctest.Write(0x63, command("i")...),
+		i2ctest.Read(0x63, response(1, "?I,pH,1.98")...),
+		i2ctest.Write(0x63, command("L,?")...),
+		i2ctest.Read(0x63, response(1, "?L,1")...),
+		i2ctest.Write(0x63, command("T,19.500000")...),
+		i2ctest.Write(0x63, command("R")...),
+		i2ctest.Read(0x63, response(254, "")...),
+	)
+	ezo := NewAtlasEZO(0x63, bus)
+	ezo.delay = 0
+	if v, err := ezo.Read(); err != nil || v != 7.021 {
+		t.Error("Unexpected reading:", v, err)
+	}
+	if device, version, err := ezo.Information(); err != nil || device != "pH" || version != "1.98" {
+		t.Error("Unexpected information:", device, version, err)
+	}
+	if on, err := ezo.LedState(); err != nil || !on {
+		t.Error("Unexpected led state:", on, err)
+	}
+	if err := ezo.SetTC(19.5); err != nil {
+		t.Error(err)
+	}
+	if _, err := ezo.Read(); err == nil {
+		t.Error("Pending response should fail")
+	}
+	if err := bus.Done(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEZODiagnose(t *testing.T) {
+	circuit := sim.NewEZO()
+	circuit.ReadDelay, circuit.CommandDelay = 0, 0
+	bus := sim.NewBus()
+	if err := bus.Attach(0x63, circuit); err != nil {
+		t.Fatal(err)
+	}
+	e := NewAtlasEZO(0x63, bus)
+	e.delay = time.Millisecond
+	d := e.Diagnose(context.Background())
+	if !d.Reachable || d.Hardware != "EZO pH" || d.Firmware != "1.98" || d.SupplyVoltage != 5.038 {
+		t.Errorf("Unexpected diagnosis: %+v", d)
+	}
+	if d.Fields["restart_reason"] != "powered off" || d.Fields["calibration_points"] != "0" {
+		t.Error("Unexpected fields:", d.Fields)
+	}
+	bus.Detach(0x63)
+	if _, err := e.Read(); err == nil {
+		t.Error("Expected a read failure")
+	}
+	d = e.Diagnose(context.Background())
+	if d.Reachable || d.LastError == "" || d.LastErrorTime.IsZero() {
+		t.Errorf("Unexpected diagnosis: %+v", d)
+	}
+}
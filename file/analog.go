@@ -1,47 +1,110 @@
 package file
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"strconv"
-	"strings"
+	"sync"
 
 	"encoding/json"
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/schema"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
 
+var analogMeta = hal.Metadata{
+	Name:         "analog-file",
+	Description:  "A simple file based analog hal driver",
+	Capabilities: []hal.Capability{hal.AnalogInput},
+}
+
+func init() {
+	drivers.Register(analogMeta, configSchema, HalAnalogAdapter)
+}
+
+// configSchema describes Config, shared by the analog and digital drivers
+var configSchema = schema.Object(schema.Properties{
+	"address": schema.String().Describe("File holding the value, or the shared file of channels"),
+	"format":  schema.String().OneOf(FormatRaw, FormatJSON, FormatCSV, FormatKV).WithDefault(FormatRaw),
+	"channels": schema.Array(schema.Object(schema.Properties{
+		"name":  schema.String(),
+		"path":  schema.String().Describe("Defaults to address"),
+		"field": schema.String().Describe("Field selector for structured formats"),
+	})),
+	"watch":    schema.Boolean().Describe("Serve reads from a cache refreshed on file changes"),
+	"atomic":   schema.Boolean().Describe("Write through a temporary file renamed over the target, regular files only"),
+	"debounce": schema.Integer().Min(0).Describe("Notification debounce in milliseconds"),
+})
+
 type Config struct {
 	Address string `json:"address"`
+	// Format of the channel files, one of FormatRaw (default), FormatJSON,
+	// FormatCSV or FormatKV
+	Format string `json:"format"`
+	// Channels exposes several pins from one driver, each reading its own
+	// path or a field of the file at Address
+	Channels []ChannelConfig `json:"channels"`
+	// Watch serves reads from a cache refreshed on file change
+	// notifications, and enables Subscribe on the pins
+	Watch bool `json:"watch"`
+	// Debounce (in milliseconds) suppresses notification bursts in watch mode
+	Debounce int `json:"debounce"`
+	// Atomic writes through a temporary file renamed over the target, so
+	// readers never see a partial value. It only suits regular files: the
+	// rename replaces links and the owner, and fails in sysfs or /dev
+	Atomic bool `json:"atomic"`
+}
+
+type ChannelConfig struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`  // defaults to Address
+	Field string `json:"field"` // field selector for structured formats
 }
 
 type analog struct {
-	path       string
+	name       string
+	number     int
+	src        *field
 	meta       hal.Metadata
+	mu         sync.Mutex // guards calibrator
 	calibrator hal.Calibrator
 }
 
 func HalAnalogAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
+	c, err := configSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
 	var config Config
 	if err := json.Unmarshal(c, &config); err != nil {
 		return nil, err
 	}
+	if len(config.Channels) > 0 || config.Watch {
+		return NewAnalogs(config)
+	}
 	return NewAnalog(config.Address)
 }
 
 func NewAnalog(p string) (*analog, error) {
+	src, err := newField(p, FormatRaw, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return newAnalog(p, 0, src)
+}
+
+func newAnalog(name string, number int, src *field) (*analog, error) {
 	c, err := hal.CalibratorFactory([]hal.Measurement{})
 	if err != nil {
 		return nil, err
 	}
 	return &analog{
-		path:       p,
+		name:       name,
+		number:     number,
+		src:        src,
 		calibrator: c,
-		meta: hal.Metadata{
-			Name:         "analog-file",
-			Description:  "A simple file based analog hal driver",
-			Capabilities: []hal.Capability{hal.AnalogInput},
-		},
+		meta:       analogMeta,
 	}, nil
 }
 
@@ -54,18 +117,18 @@ func (f *analog) Close() error {
 }
 
 func (f *analog) Name() string {
-	return f.path
+	return f.name
 }
 
 func (f *analog) Number() int {
-	return 0
+	return f.number
 }
 func (f *analog) Read() (float64, error) {
-	data, err := ioutil.ReadFile(f.path)
+	v, err := f.src.read()
 	if err != nil {
 		return 0, err
 	}
-	return strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	return strconv.ParseFloat(v, 64)
 }
 
 func (f *analog) Measure() (float64, error) {
@@ -73,17 +136,41 @@ func (f *analog) Measure() (float64, error) {
 	if err != nil {
 		return 0, err
 	}
-	if f.calibrator == nil {
+	f.mu.Lock()
+	cal := f.calibrator
+	f.mu.Unlock()
+	if cal == nil {
 		return 0, fmt.Errorf("Not calibrated")
 	}
-	return f.calibrator.Calibrate(v), nil
+	return cal.Calibrate(v), nil
+}
+
+// Subscribe registers fn to be called after the pin's file changed, it
+// requires watch mode
+func (f *analog) Subscribe(fn func()) error {
+	if f.src.w == nil {
+		return fmt.Errorf("watch mode is not enabled for %s", f.name)
+	}
+	f.src.w.Subscribe(fn)
+	return nil
+}
+
+// Diagnose checks the file of the pin exists
+func (f *analog) Diagnose(_ context.Context) drivers.Diagnosis {
+	diag := drivers.Diagnosis{Reachable: true}
+	f.src.check(&diag)
+	diag.Set("path", f.src.path)
+	return diag
 }
+
 func (f *analog) Calibrate(points []hal.Measurement) error {
 	cal, err := hal.CalibratorFactory(points)
 	if err != nil {
 		return err
 	}
+	f.mu.Lock()
 	f.calibrator = cal
+	f.mu.Unlock()
 	return nil
 }
 
@@ -96,8 +183,5 @@ func (f *analog) AnalogInputPin(_ int) (hal.AnalogInputPin, error) {
 }
 
 func (f *analog) Pins(cap hal.Capability) ([]hal.Pin, error) {
-	if cap == hal.AnalogInput {
-		return []hal.Pin{f}, nil
-	}
-	return nil, fmt.Errorf("unsupported capability:%s", cap.String())
+	return drivers.Pins(f, cap)
 }
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -36,4 +37,12 @@ func TestAnalogInput(t *testing.T) {
 	if _, err := pin.Read(); err != nil {
 		t.Error(err)
 	}
+	if diag := d.Diagnose(context.Background()); !diag.Reachable || diag.Fields["path"] != temp.Name() {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+	os.Remove(temp.Name())
+	_, readErr := pin.Read()
+	if diag := d.Diagnose(context.Background()); diag.Reachable || readErr == nil || diag.LastError == "" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
 }
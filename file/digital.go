@@ -1,38 +1,64 @@
 package file
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"strconv"
-	"strings"
+	"sync"
 
 	"encoding/json"
+	"github.com/dmolavi/drivers"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
 
+var digitalMeta = hal.Metadata{
+	Name:         "digital-file",
+	Description:  "A simple file based digital hal driver",
+	Capabilities: []hal.Capability{hal.DigitalInput, hal.DigitalOutput, hal.PWM},
+}
+
+func init() {
+	drivers.Register(digitalMeta, configSchema, HalDigitalAdapter)
+}
+
 type digital struct {
-	path      string
+	name      string
+	number    int
+	src       *field
 	meta      hal.Metadata
+	mu        sync.Mutex // guards lastState, held across writes
 	lastState bool
 }
 
 func HalDigitalAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
+	c, err := configSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
 	var config Config
 	if err := json.Unmarshal(c, &config); err != nil {
 		return nil, err
 	}
-	return NewDigital(config.Address), nil
+	if len(config.Channels) > 0 || config.Watch {
+		return NewDigitals(config)
+	}
+	d := NewDigital(config.Address)
+	d.src.atomic = config.Atomic
+	return d, nil
 }
 
 func NewDigital(p string) *digital {
+	src, _ := newField(p, FormatRaw, "", nil)
+	return newDigital(p, 0, src)
+}
+
+func newDigital(name string, number int, src *field) *digital {
 	return &digital{
-		path: p,
-		meta: hal.Metadata{
-			Name:         "digital-file",
-			Description:  "A simple file based digital hal driver",
-			Capabilities: []hal.Capability{hal.DigitalInput, hal.DigitalOutput, hal.PWM},
-		},
+		name:   name,
+		number: number,
+		src:    src,
+		meta:   digitalMeta,
 	}
 }
 
@@ -41,39 +67,61 @@ func (f *digital) Metadata() hal.Metadata {
 }
 
 func (d *digital) Number() int {
-	return 0
+	return d.number
 }
 func (f *digital) Close() error {
 	return nil
 }
 
 func (f *digital) Name() string {
-	return f.path
+	return f.name
 }
 
 func (f *digital) Read() (bool, error) {
-	data, err := ioutil.ReadFile(f.path)
+	v, err := f.src.read()
 	if err != nil {
 		return false, err
 	}
-	return strings.TrimSpace(string(data)) == "1", nil
+	return v == "1", nil
+
+}
+
+// Subscribe registers fn to be called after the pin's file changed, it
+// requires watch mode
+func (f *digital) Subscribe(fn func()) error {
+	if f.src.w == nil {
+		return fmt.Errorf("watch mode is not enabled for %s", f.name)
+	}
+	f.src.w.Subscribe(fn)
+	return nil
+}
 
+// Diagnose checks the file of the pin exists
+func (f *digital) Diagnose(_ context.Context) drivers.Diagnosis {
+	diag := drivers.Diagnosis{Reachable: true}
+	f.src.check(&diag)
+	diag.Set("path", f.src.path)
+	return diag
 }
 
 func (f *digital) LastState() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	return f.lastState
 }
 
 func (f *digital) Write(b bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.lastState = b
 	if b {
-		return ioutil.WriteFile(f.path, []byte("1"), 0644)
+		return f.src.write("1")
 	}
-	return ioutil.WriteFile(f.path, []byte("0"), 0644)
+	return f.src.write("0")
 
 }
 func (f *digital) Set(v float64) error {
-	return ioutil.WriteFile(f.path, []byte(strconv.FormatFloat(v, 'f', -1, 64)), 0644)
+	return f.src.write(strconv.FormatFloat(v, 'f', -1, 64))
 }
 
 func (f *digital) DigitalInputPins() []hal.DigitalInputPin {
@@ -99,10 +147,5 @@ func (f *digital) PWMChannel(_ int) (hal.PWMChannel, error) {
 	return f, nil
 }
 func (f *digital) Pins(cap hal.Capability) ([]hal.Pin, error) {
-	switch cap {
-	case hal.DigitalInput, hal.DigitalOutput, hal.PWM:
-		return []hal.Pin{f}, nil
-	default:
-		return nil, fmt.Errorf("unsupported capability:%s", cap.String())
-	}
+	return drivers.Pins(f, cap)
 }
@@ -3,6 +3,7 @@ package file
 import (
 	"io/ioutil"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/reef-pi/hal"
@@ -71,3 +72,37 @@ func TestPWMOutput(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestDigitalConcurrency(t *testing.T) {
+	temp, err := ioutil.TempFile("", "hal-file-driver-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+	pin, err := NewDigital(temp.Name()).DigitalOutputPin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(b bool) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := pin.Write(b); err != nil {
+					t.Error(err)
+				}
+				pin.LastState()
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	data, err := ioutil.ReadFile(temp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (string(data) == "1") != pin.LastState() {
+		t.Error("Last state does not match the file content:", string(data))
+	}
+}
@@ -0,0 +1,299 @@
+package file
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dmolavi/drivers"
+)
+
+// Supported file formats
+const (
+	FormatRaw  = "raw"  // the whole file holds a single value
+	FormatJSON = "json" // a JSON document, fields are selected by dotted keys
+	FormatCSV  = "csv"  // comma separated values, with an optional header row
+	FormatKV   = "kv"   // key=value lines
+)
+
+// field addresses a single value, either a whole file or one field of a
+// structured file. Fields sharing a file share its lock
+type field struct {
+	path   string
+	format string
+	key    string
+	mu     *sync.Mutex
+	atomic bool // write through a temporary file and rename
+	w      *watcher
+	cache  string
+	cached bool
+	errs   drivers.ErrorLog
+}
+
+func newField(path, format, key string, mu *sync.Mutex) (*field, error) {
+	switch format {
+	case "", FormatRaw:
+		format = FormatRaw
+	case FormatJSON, FormatCSV, FormatKV:
+		if key == "" {
+			return nil, fmt.Errorf("%s format requires a field for %s", format, path)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file format: %s", format)
+	}
+	if mu == nil {
+		mu = &sync.Mutex{}
+	}
+	return &field{path: path, format: format, key: key, mu: mu}, nil
+}
+
+// watch serves reads from a cache, refreshed after w reports a change
+func (f *field) watch(w *watcher) {
+	f.w = w
+	w.hook(f.invalidate)
+}
+
+func (f *field) invalidate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cached = false
+}
+
+func (f *field) read() (string, error) {
+	v, err := f.load()
+	return v, f.errs.Record(err)
+}
+
+func (f *field) load() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cached {
+		return f.cache, nil
+	}
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return "", err
+	}
+	var v string
+	switch f.format {
+	case FormatJSON:
+		v, err = f.readJSON(data)
+	case FormatCSV:
+		v, err = f.readCSV(data)
+	case FormatKV:
+		v, err = f.readKV(data)
+	default:
+		v = strings.TrimSpace(string(data))
+	}
+	if err == nil && f.w != nil {
+		f.cache, f.cached = v, true
+	}
+	return v, err
+}
+
+func (f *field) writeFile(data []byte) error {
+	if f.atomic {
+		return writeAtomic(f.path, data)
+	}
+	return ioutil.WriteFile(f.path, data, 0644)
+}
+
+func (f *field) write(v string) error {
+	return f.errs.Record(f.store(v))
+}
+
+func (f *field) store(v string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// reads after the write must not be served from the cache
+	f.cached = false
+	if f.format == FormatRaw {
+		return f.writeFile([]byte(v))
+	}
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	switch f.format {
+	case FormatJSON:
+		data, err = f.writeJSON(data, v)
+	case FormatCSV:
+		data, err = f.writeCSV(data, v)
+	case FormatKV:
+		data, err = f.writeKV(data, v)
+	}
+	if err != nil {
+		return err
+	}
+	return f.writeFile(data)
+}
+
+// check adds the state of the file to a Diagnosis
+func (f *field) check(diag *drivers.Diagnosis) {
+	_, err := os.Stat(f.path)
+	check(diag, &f.errs, err)
+}
+
+// check adds the outcome of probing one file or line of a driver to diag,
+// recording err in the log of the file. Diagnoses start out reachable
+func check(diag *drivers.Diagnosis, errs *drivers.ErrorLog, err error) {
+	if errs.Record(err) != nil {
+		diag.Reachable = false
+	}
+	errs.Report(diag)
+}
+
+func (f *field) readJSON(data []byte) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", err
+	}
+	for _, k := range strings.Split(f.key, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("field %s not found in %s", f.key, f.path)
+		}
+		if doc, ok = m[k]; !ok {
+			return "", fmt.Errorf("field %s not found in %s", f.key, f.path)
+		}
+	}
+	switch v := doc.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case string:
+		return strings.TrimSpace(v), nil
+	default:
+		return "", fmt.Errorf("field %s in %s is not a scalar value", f.key, f.path)
+	}
+}
+
+func (f *field) writeJSON(data []byte, v string) ([]byte, error) {
+	doc := make(map[string]interface{})
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	}
+	keys := strings.Split(f.key, ".")
+	m := doc
+	for _, k := range keys[:len(keys)-1] {
+		child, ok := m[k].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[k] = child
+		}
+		m = child
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil {
+		m[keys[len(keys)-1]] = n
+	} else {
+		m[keys[len(keys)-1]] = v
+	}
+	return json.Marshal(doc)
+}
+
+// csvColumn locates the field column. With more than one row the first row
+// is a header and the field may be a column name, otherwise it is an index
+func (f *field) csvColumn(records [][]string) (int, error) {
+	if len(records) > 1 {
+		for i, h := range records[0] {
+			if strings.TrimSpace(h) == f.key {
+				return i, nil
+			}
+		}
+	}
+	i, err := strconv.Atoi(f.key)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("field %s not found in %s", f.key, f.path)
+	}
+	return i, nil
+}
+
+func (f *field) readCSV(data []byte) (string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return "", fmt.Errorf("no records in %s", f.path)
+	}
+	i, err := f.csvColumn(records)
+	if err != nil {
+		return "", err
+	}
+	row := records[len(records)-1]
+	if i >= len(row) {
+		return "", fmt.Errorf("field %s not found in %s", f.key, f.path)
+	}
+	return strings.TrimSpace(row[i]), nil
+}
+
+func (f *field) writeCSV(data []byte, v string) ([]byte, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		records = [][]string{{}}
+	}
+	i, err := f.csvColumn(records)
+	if err != nil {
+		return nil, err
+	}
+	row := records[len(records)-1]
+	for len(row) <= i {
+		row = append(row, "")
+	}
+	row[i] = v
+	records[len(records)-1] = row
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *field) readKV(data []byte) (string, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == f.key {
+			return strings.TrimSpace(parts[1]), nil
+		}
+	}
+	return "", fmt.Errorf("field %s not found in %s", f.key, f.path)
+}
+
+func (f *field) writeKV(data []byte, v string) ([]byte, error) {
+	var lines []string
+	found := false
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == f.key {
+			line = f.key + "=" + v
+			found = true
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if !found {
+		lines = append(lines, f.key+"="+v)
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
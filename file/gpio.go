@@ -0,0 +1,331 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/schema"
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+)
+
+var gpioMeta = hal.Metadata{
+	Name:         "gpio-file",
+	Description:  "Linux GPIO driver using sysfs or gpio character devices",
+	Capabilities: []hal.Capability{hal.DigitalInput, hal.DigitalOutput},
+}
+
+func init() {
+	drivers.Register(gpioMeta, gpioSchema, HalGPIOAdapter)
+}
+
+const (
+	_sysfsGPIO     = "/sys/class/gpio"
+	_gpioConsumer  = "reef-pi"
+	_exportRetries = 10
+	_exportDelay   = 10 * time.Millisecond
+)
+
+var gpioSchema = schema.Object(schema.Properties{
+	"path": schema.String().Describe("sysfs gpio root, defaults to " + _sysfsGPIO),
+	"chip": schema.String().Describe("gpio character device, e.g. /dev/gpiochip0"),
+	"pins": schema.Array(schema.Object(schema.Properties{
+		"number":     schema.Integer().Min(0),
+		"direction":  schema.String().OneOf("in", "out").WithDefault("in"),
+		"active_low": schema.Boolean(),
+		"edge":       schema.String().OneOf("none", "rising", "falling", "both"),
+	}, "number")),
+})
+
+type GPIOConfig struct {
+	// Path is the sysfs gpio root, defaults to /sys/class/gpio
+	Path string `json:"path"`
+	// Chip is an optional gpio character device (e.g. /dev/gpiochip0). When
+	// present, pins without an edge setting use line handles instead of sysfs
+	Chip string          `json:"chip"`
+	Pins []GPIOPinConfig `json:"pins"`
+}
+
+type GPIOPinConfig struct {
+	Number    int    `json:"number"`
+	Direction string `json:"direction"` // in or out
+	ActiveLow bool   `json:"active_low"`
+	Edge      string `json:"edge"` // none, rising, falling or both
+}
+
+type gpio struct {
+	root string
+	meta hal.Metadata
+	pins []*gpioPin
+}
+
+type gpioPin struct {
+	*digital
+	root   string
+	number int
+	output bool
+	line   *lineHandle
+}
+
+func HalGPIOAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
+	c, err := gpioSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
+	var config GPIOConfig
+	if err := json.Unmarshal(c, &config); err != nil {
+		return nil, err
+	}
+	return NewGPIO(config)
+}
+
+func NewGPIO(config GPIOConfig) (*gpio, error) {
+	root := config.Path
+	if root == "" {
+		root = _sysfsGPIO
+	}
+	useChip := false
+	if config.Chip != "" {
+		if _, err := os.Stat(config.Chip); err == nil {
+			useChip = true
+		}
+	}
+	g := &gpio{
+		root: root,
+		meta: gpioMeta,
+	}
+	for _, pc := range config.Pins {
+		var p *gpioPin
+		var err error
+		if useChip && (pc.Edge == "" || pc.Edge == "none") {
+			p, err = newLinePin(config.Chip, pc)
+		} else {
+			p, err = newSysfsPin(root, pc)
+		}
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		g.pins = append(g.pins, p)
+	}
+	return g, nil
+}
+
+func newLinePin(chip string, pc GPIOPinConfig) (*gpioPin, error) {
+	output, err := isOutput(pc.Direction)
+	if err != nil {
+		return nil, err
+	}
+	l, err := requestLine(chip, pc.Number, output, pc.ActiveLow)
+	if err != nil {
+		return nil, err
+	}
+	return &gpioPin{
+		digital: NewDigital(fmt.Sprintf("%s:%d", chip, pc.Number)),
+		number:  pc.Number,
+		output:  output,
+		line:    l,
+	}, nil
+}
+
+func newSysfsPin(root string, pc GPIOPinConfig) (*gpioPin, error) {
+	output, err := isOutput(pc.Direction)
+	if err != nil {
+		return nil, err
+	}
+	switch pc.Edge {
+	case "", "none", "rising", "falling", "both":
+	default:
+		return nil, fmt.Errorf("invalid edge '%s' for gpio %d", pc.Edge, pc.Number)
+	}
+	dir := filepath.Join(root, fmt.Sprintf("gpio%d", pc.Number))
+	if err := export(root, dir, pc.Number, "direction"); err != nil {
+		return nil, err
+	}
+	p := &gpioPin{
+		digital: NewDigital(filepath.Join(dir, "value")),
+		root:    root,
+		number:  pc.Number,
+		output:  output,
+	}
+	direction := "in"
+	if output {
+		direction = "out"
+	}
+	activeLow := "0"
+	if pc.ActiveLow {
+		activeLow = "1"
+	}
+	attrs := [][2]string{
+		{"active_low", activeLow},
+		{"direction", direction},
+	}
+	if pc.Edge != "" {
+		attrs = append(attrs, [2]string{"edge", pc.Edge})
+	}
+	for _, a := range attrs {
+		if err := ioutil.WriteFile(filepath.Join(dir, a[0]), []byte(a[1]), 0644); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func isOutput(direction string) (bool, error) {
+	switch direction {
+	case "in", "":
+		return false, nil
+	case "out":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid gpio direction: %s", direction)
+	}
+}
+
+// export makes a gpio or pwm channel available under sysfs, waiting for the
+// kernel (and udev permission rules) to populate attr in its directory
+func export(root, dir string, n int, attr string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "export"), []byte(strconv.Itoa(n)), 0644); err != nil {
+		return err
+	}
+	for i := 0; i < _exportRetries; i++ {
+		if _, err := os.Stat(filepath.Join(dir, attr)); err == nil {
+			return nil
+		}
+		time.Sleep(_exportDelay)
+	}
+	return fmt.Errorf("%s not available after export", dir)
+}
+
+func (p *gpioPin) Name() string {
+	return fmt.Sprintf("GP%d", p.number)
+}
+
+func (p *gpioPin) Number() int {
+	return p.number
+}
+
+func (p *gpioPin) Read() (bool, error) {
+	if p.line != nil {
+		b, err := p.line.get()
+		return b, p.src.errs.Record(err)
+	}
+	return p.digital.Read()
+}
+
+func (p *gpioPin) Write(b bool) error {
+	if !p.output {
+		return fmt.Errorf("gpio %d is not configured as output", p.number)
+	}
+	if p.line != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if err := p.line.set(b); err != nil {
+			return p.src.errs.Record(err)
+		}
+		p.lastState = b
+		return nil
+	}
+	return p.digital.Write(b)
+}
+
+func (p *gpioPin) Close() error {
+	if p.line != nil {
+		return p.line.close()
+	}
+	return ioutil.WriteFile(filepath.Join(p.root, "unexport"), []byte(strconv.Itoa(p.number)), 0644)
+}
+
+func (g *gpio) Metadata() hal.Metadata {
+	return g.meta
+}
+
+func (g *gpio) Close() error {
+	for _, p := range g.pins {
+		if err := p.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Diagnose reads character device lines and checks sysfs value files exist
+func (g *gpio) Diagnose(_ context.Context) drivers.Diagnosis {
+	diag := drivers.Diagnosis{Reachable: true}
+	for _, p := range g.pins {
+		if p.line != nil {
+			_, err := p.line.get()
+			check(&diag, &p.src.errs, err)
+			continue
+		}
+		p.src.check(&diag)
+	}
+	diag.Set("pins", len(g.pins))
+	return diag
+}
+
+func (g *gpio) pin(n int) (*gpioPin, error) {
+	for _, p := range g.pins {
+		if p.number == n {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("gpio %d is not configured", n)
+}
+
+func (g *gpio) DigitalInputPins() []hal.DigitalInputPin {
+	var pins []hal.DigitalInputPin
+	for _, p := range g.pins {
+		if !p.output {
+			pins = append(pins, p)
+		}
+	}
+	return pins
+}
+
+func (g *gpio) DigitalInputPin(n int) (hal.DigitalInputPin, error) {
+	p, err := g.pin(n)
+	if err != nil {
+		return nil, err
+	}
+	if p.output {
+		return nil, fmt.Errorf("gpio %d is not configured as input", n)
+	}
+	return p, nil
+}
+
+func (g *gpio) DigitalOutputPins() []hal.DigitalOutputPin {
+	var pins []hal.DigitalOutputPin
+	for _, p := range g.pins {
+		if p.output {
+			pins = append(pins, p)
+		}
+	}
+	return pins
+}
+
+func (g *gpio) DigitalOutputPin(n int) (hal.DigitalOutputPin, error) {
+	p, err := g.pin(n)
+	if err != nil {
+		return nil, err
+	}
+	if !p.output {
+		return nil, fmt.Errorf("gpio %d is not configured as output", n)
+	}
+	return p, nil
+}
+
+func (g *gpio) Pins(cap hal.Capability) ([]hal.Pin, error) {
+	return drivers.Pins(g, cap)
+}
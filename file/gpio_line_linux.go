@@ -0,0 +1,84 @@
+package file
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// gpio character device uapi (v1), see linux/gpio.h
+const (
+	_gpioGetLineHandle  = 0xC16CB403
+	_gpioGetLineValues  = 0xC040B408
+	_gpioSetLineValues  = 0xC040B409
+	_gpioHandleInput    = 1 << 0
+	_gpioHandleOutput   = 1 << 1
+	_gpioHandleActiveLo = 1 << 2
+)
+
+type gpioHandleRequest struct {
+	lineOffsets   [64]uint32
+	flags         uint32
+	defaultValues [64]uint8
+	consumerLabel [32]byte
+	lines         uint32
+	fd            int32
+}
+
+type gpioHandleData struct {
+	values [64]uint8
+}
+
+type lineHandle struct {
+	fd uintptr
+}
+
+func requestLine(chip string, offset int, output, activeLow bool) (*lineHandle, error) {
+	f, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var req gpioHandleRequest
+	req.lineOffsets[0] = uint32(offset)
+	req.lines = 1
+	req.flags = _gpioHandleInput
+	if output {
+		req.flags = _gpioHandleOutput
+	}
+	if activeLow {
+		req.flags |= _gpioHandleActiveLo
+	}
+	copy(req.consumerLabel[:], _gpioConsumer)
+	if err := ioctl(f.Fd(), _gpioGetLineHandle, unsafe.Pointer(&req)); err != nil {
+		return nil, err
+	}
+	return &lineHandle{fd: uintptr(req.fd)}, nil
+}
+
+func (l *lineHandle) get() (bool, error) {
+	var data gpioHandleData
+	if err := ioctl(l.fd, _gpioGetLineValues, unsafe.Pointer(&data)); err != nil {
+		return false, err
+	}
+	return data.values[0] == 1, nil
+}
+
+func (l *lineHandle) set(b bool) error {
+	var data gpioHandleData
+	if b {
+		data.values[0] = 1
+	}
+	return ioctl(l.fd, _gpioSetLineValues, unsafe.Pointer(&data))
+}
+
+func (l *lineHandle) close() error {
+	return syscall.Close(int(l.fd))
+}
+
+func ioctl(fd, cmd uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, cmd, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
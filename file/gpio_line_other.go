@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package file
+
+import "fmt"
+
+type lineHandle struct{}
+
+func requestLine(_ string, _ int, _, _ bool) (*lineHandle, error) {
+	return nil, fmt.Errorf("gpio character devices are only supported on linux")
+}
+
+func (l *lineHandle) get() (bool, error) { return false, nil }
+func (l *lineHandle) set(_ bool) error   { return nil }
+func (l *lineHandle) close() error       { return nil }
@@ -0,0 +1,123 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reef-pi/hal"
+)
+
+func fakeSysfsGPIO(t *testing.T, pins ...string) string {
+	root, err := ioutil.TempDir("", "hal-file-gpio-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"export", "unexport"} {
+		if err := ioutil.WriteFile(filepath.Join(root, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range pins {
+		dir := filepath.Join(root, p)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []string{"direction", "value", "active_low", "edge"} {
+			if err := ioutil.WriteFile(filepath.Join(dir, f), []byte("0"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+func readFile(t *testing.T, path ...string) string {
+	data, err := ioutil.ReadFile(filepath.Join(path...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestGPIO(t *testing.T) {
+	root := fakeSysfsGPIO(t, "gpio17", "gpio27")
+	defer os.RemoveAll(root)
+	conf := `{"path":"` + root + `", "pins":[
+		{"number":17, "direction":"out", "active_low":true},
+		{"number":27, "direction":"in", "edge":"both"}
+	]}`
+	d, err := HalGPIOAdapter([]byte(conf), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := readFile(t, root, "gpio17", "direction"); v != "out" {
+		t.Error("Expected direction out, found:", v)
+	}
+	if v := readFile(t, root, "gpio17", "active_low"); v != "1" {
+		t.Error("Expected active_low 1, found:", v)
+	}
+	if v := readFile(t, root, "gpio27", "edge"); v != "both" {
+		t.Error("Expected edge both, found:", v)
+	}
+
+	output := d.(hal.DigitalOutputDriver)
+	if len(output.DigitalOutputPins()) != 1 {
+		t.Error("Expected a single output pin, found:", len(output.DigitalOutputPins()))
+	}
+	pin, err := output.DigitalOutputPin(17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pin.Write(true); err != nil {
+		t.Error(err)
+	}
+	if v := readFile(t, root, "gpio17", "value"); v != "1" {
+		t.Error("Expected value 1, found:", v)
+	}
+	if !pin.LastState() {
+		t.Error("Expected last state to be true")
+	}
+	if _, err := output.DigitalOutputPin(27); err == nil {
+		t.Error("Input pins should not be returned as output")
+	}
+
+	input := d.(hal.DigitalInputDriver)
+	in, err := input.DigitalInputPin(27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(root, "gpio27", "value"), []byte("1\n"), 0644)
+	if v, err := in.Read(); err != nil || !v {
+		t.Error("Expected input to read true", err)
+	}
+	if pins, err := d.Pins(hal.DigitalInput); err != nil || len(pins) != 1 {
+		t.Error("Expected a single digital input pin", err)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+	if v := readFile(t, root, "unexport"); v != "27" {
+		t.Error("Expected gpio 27 to be unexported, found:", v)
+	}
+}
+
+func TestGPIOExport(t *testing.T) {
+	root := fakeSysfsGPIO(t)
+	defer os.RemoveAll(root)
+	_, err := NewGPIO(GPIOConfig{
+		Path: root,
+		Pins: []GPIOPinConfig{{Number: 4, Direction: "out"}},
+	})
+	if err == nil {
+		t.Error("Expected error when exported gpio never shows up")
+	}
+	if v := readFile(t, root, "export"); v != "4" {
+		t.Error("Expected gpio 4 to be exported, found:", v)
+	}
+	if _, err := NewGPIO(GPIOConfig{Path: root, Pins: []GPIOPinConfig{{Number: 4, Direction: "sideways"}}}); err == nil {
+		t.Error("Invalid direction should fail")
+	}
+}
@@ -0,0 +1,233 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dmolavi/drivers"
+	"github.com/reef-pi/hal"
+)
+
+type analogs struct {
+	meta     hal.Metadata
+	pins     []*analog
+	watchers []io.Closer
+}
+
+type digitals struct {
+	meta     hal.Metadata
+	pins     []*digital
+	watchers []io.Closer
+}
+
+type channelField struct {
+	name string
+	src  *field
+}
+
+// channelFields builds one field per configured channel, channels reading
+// the same file share a lock and watcher. Without channels Address is
+// exposed as a single channel
+func channelFields(config Config) ([]channelField, []io.Closer, error) {
+	channels := config.Channels
+	if len(channels) == 0 {
+		channels = []ChannelConfig{{}}
+	}
+	locks := make(map[string]*sync.Mutex)
+	watchers := make(map[string]*watcher)
+	var closers []io.Closer
+	var fields []channelField
+	for i, ch := range channels {
+		p := ch.Path
+		if p == "" {
+			p = config.Address
+		}
+		if p == "" {
+			closeAll(closers)
+			return nil, nil, fmt.Errorf("no path for channel %d", i)
+		}
+		if _, ok := locks[p]; !ok {
+			locks[p] = &sync.Mutex{}
+		}
+		src, err := newField(p, config.Format, ch.Field, locks[p])
+		if err != nil {
+			closeAll(closers)
+			return nil, nil, err
+		}
+		src.atomic = config.Atomic
+		if config.Watch {
+			if _, ok := watchers[p]; !ok {
+				w, err := newWatcher(p, time.Duration(config.Debounce)*time.Millisecond)
+				if err != nil {
+					closeAll(closers)
+					return nil, nil, err
+				}
+				watchers[p] = w
+				closers = append(closers, w)
+			}
+			src.watch(watchers[p])
+		}
+		name := ch.Name
+		switch {
+		case name != "":
+		case ch.Field != "":
+			name = ch.Field
+		default:
+			name = p
+		}
+		fields = append(fields, channelField{name: name, src: src})
+	}
+	return fields, closers, nil
+}
+
+func closeAll(closers []io.Closer) error {
+	var err error
+	for _, c := range closers {
+		if cErr := c.Close(); cErr != nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+// NewAnalogs returns a driver with one analog input pin per configured channel
+func NewAnalogs(config Config) (*analogs, error) {
+	fields, watchers, err := channelFields(config)
+	if err != nil {
+		return nil, err
+	}
+	d := &analogs{
+		watchers: watchers,
+		meta:     analogMeta,
+	}
+	for i, f := range fields {
+		pin, err := newAnalog(f.name, i, f.src)
+		if err != nil {
+			closeAll(watchers)
+			return nil, err
+		}
+		d.pins = append(d.pins, pin)
+	}
+	return d, nil
+}
+
+func (d *analogs) Metadata() hal.Metadata {
+	return d.meta
+}
+
+func (d *analogs) Close() error {
+	return closeAll(d.watchers)
+}
+
+// Diagnose checks the files of every channel exist
+func (d *analogs) Diagnose(_ context.Context) drivers.Diagnosis {
+	diag := drivers.Diagnosis{Reachable: true}
+	for _, p := range d.pins {
+		p.src.check(&diag)
+	}
+	diag.Set("channels", len(d.pins))
+	return diag
+}
+
+func (d *analogs) AnalogInputPins() []hal.AnalogInputPin {
+	var pins []hal.AnalogInputPin
+	for _, p := range d.pins {
+		pins = append(pins, p)
+	}
+	return pins
+}
+
+func (d *analogs) AnalogInputPin(n int) (hal.AnalogInputPin, error) {
+	if n < 0 || n >= len(d.pins) {
+		return nil, fmt.Errorf("invalid channel %d", n)
+	}
+	return d.pins[n], nil
+}
+
+func (d *analogs) Pins(cap hal.Capability) ([]hal.Pin, error) {
+	return drivers.Pins(d, cap)
+}
+
+// NewDigitals returns a driver with one digital/pwm pin per configured channel
+func NewDigitals(config Config) (*digitals, error) {
+	fields, watchers, err := channelFields(config)
+	if err != nil {
+		return nil, err
+	}
+	d := &digitals{
+		watchers: watchers,
+		meta:     digitalMeta,
+	}
+	for i, f := range fields {
+		d.pins = append(d.pins, newDigital(f.name, i, f.src))
+	}
+	return d, nil
+}
+
+func (d *digitals) Metadata() hal.Metadata {
+	return d.meta
+}
+
+func (d *digitals) Close() error {
+	return closeAll(d.watchers)
+}
+
+// Diagnose checks the files of every channel exist
+func (d *digitals) Diagnose(_ context.Context) drivers.Diagnosis {
+	diag := drivers.Diagnosis{Reachable: true}
+	for _, p := range d.pins {
+		p.src.check(&diag)
+	}
+	diag.Set("channels", len(d.pins))
+	return diag
+}
+
+func (d *digitals) pin(n int) (*digital, error) {
+	if n < 0 || n >= len(d.pins) {
+		return nil, fmt.Errorf("invalid channel %d", n)
+	}
+	return d.pins[n], nil
+}
+
+func (d *digitals) DigitalInputPins() []hal.DigitalInputPin {
+	var pins []hal.DigitalInputPin
+	for _, p := range d.pins {
+		pins = append(pins, p)
+	}
+	return pins
+}
+
+func (d *digitals) DigitalInputPin(n int) (hal.DigitalInputPin, error) {
+	return d.pin(n)
+}
+
+func (d *digitals) DigitalOutputPins() []hal.DigitalOutputPin {
+	var pins []hal.DigitalOutputPin
+	for _, p := range d.pins {
+		pins = append(pins, p)
+	}
+	return pins
+}
+
+func (d *digitals) DigitalOutputPin(n int) (hal.DigitalOutputPin, error) {
+	return d.pin(n)
+}
+
+func (d *digitals) PWMChannels() []hal.PWMChannel {
+	var pins []hal.PWMChannel
+	for _, p := range d.pins {
+		pins = append(pins, p)
+	}
+	return pins
+}
+
+func (d *digitals) PWMChannel(n int) (hal.PWMChannel, error) {
+	return d.pin(n)
+}
+
+func (d *digitals) Pins(cap hal.Capability) ([]hal.Pin, error) {
+	return drivers.Pins(d, cap)
+}
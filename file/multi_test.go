@@ -0,0 +1,148 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reef-pi/hal"
+)
+
+func TestMultiAnalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hal-file-multi-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"sensors.json": `{"tank":{"temp":25.5},"sump":{"level":"12"}}`,
+		"sensors.csv":  "temp,ph\n25.1,8.1\n25.2,8.2\n",
+		"values.csv":   "1.5,2.5\n",
+		"ragged.csv":   "temp,ph,orp\n25.1,8.1\n",
+		"sensors.kv":   "temp = 24.9\nph=8.05\n",
+		"raw":          "7.5\n",
+	}
+	for f, c := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		conf     Config
+		expected []float64
+	}{
+		{
+			conf: Config{Address: filepath.Join(dir, "sensors.json"), Format: FormatJSON, Channels: []ChannelConfig{
+				{Field: "tank.temp"}, {Name: "sump", Field: "sump.level"},
+			}},
+			expected: []float64{25.5, 12},
+		},
+		{
+			conf: Config{Address: filepath.Join(dir, "sensors.csv"), Format: FormatCSV, Channels: []ChannelConfig{
+				{Field: "ph"}, {Field: "0"},
+			}},
+			expected: []float64{8.2, 25.2},
+		},
+		{
+			conf: Config{Address: filepath.Join(dir, "values.csv"), Format: FormatCSV, Channels: []ChannelConfig{
+				{Field: "1"},
+			}},
+			expected: []float64{2.5},
+		},
+		{
+			conf: Config{Address: filepath.Join(dir, "ragged.csv"), Format: FormatCSV, Channels: []ChannelConfig{
+				{Field: "ph"},
+			}},
+			expected: []float64{8.1},
+		},
+		{
+			conf: Config{Address: filepath.Join(dir, "sensors.kv"), Format: FormatKV, Channels: []ChannelConfig{
+				{Field: "temp"}, {Field: "ph"},
+			}},
+			expected: []float64{24.9, 8.05},
+		},
+		{
+			conf: Config{Channels: []ChannelConfig{
+				{Name: "a", Path: filepath.Join(dir, "raw")},
+			}},
+			expected: []float64{7.5},
+		},
+	}
+	for _, c := range cases {
+		d, err := NewAnalogs(c.conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pins := d.AnalogInputPins()
+		if len(pins) != len(c.expected) {
+			t.Fatalf("Expected %d pins, found: %d", len(c.expected), len(pins))
+		}
+		for i, p := range pins {
+			v, err := p.Read()
+			if err != nil {
+				t.Error(err)
+			}
+			if v != c.expected[i] {
+				t.Errorf("%s: expected %f, found: %f", p.Name(), c.expected[i], v)
+			}
+			if p.Number() != i {
+				t.Errorf("%s: expected number %d, found: %d", p.Name(), i, p.Number())
+			}
+		}
+	}
+	d, _ := NewAnalogs(Config{Address: filepath.Join(dir, "sensors.json"), Format: FormatJSON, Channels: []ChannelConfig{{Field: "tank.missing"}}})
+	if _, err := d.pins[0].Read(); err == nil {
+		t.Error("Expected error for missing field")
+	}
+	if _, err := NewAnalogs(Config{Address: "x", Format: FormatJSON, Channels: []ChannelConfig{{}}}); err == nil {
+		t.Error("Structured formats should require a field")
+	}
+	if _, err := NewAnalogs(Config{Address: "x", Format: "xml", Channels: []ChannelConfig{{Field: "a"}}}); err == nil {
+		t.Error("Unsupported formats should fail")
+	}
+}
+
+func TestMultiDigital(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hal-file-multi-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, format := range []string{FormatJSON, FormatCSV, FormatKV} {
+		conf := `{"address":"` + filepath.Join(dir, "outputs."+format) + `", "format":"` + format + `", "channels":[
+			{"name":"pump", "field":"1"}, {"name":"light", "field":"3"}
+		]}`
+		d, err := HalDigitalAdapter([]byte(conf), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := d.(hal.PWMDriver)
+		if len(output.DigitalOutputPins()) != 2 {
+			t.Fatal("Expected 2 output pins, found:", len(output.DigitalOutputPins()))
+		}
+		pump, _ := output.DigitalOutputPin(0)
+		light, _ := output.PWMChannel(1)
+		if err := pump.Write(true); err != nil {
+			t.Fatal(format, err)
+		}
+		if err := light.Set(42.5); err != nil {
+			t.Fatal(format, err)
+		}
+		if err := pump.Write(false); err != nil {
+			t.Fatal(format, err)
+		}
+		input := d.(hal.DigitalInputDriver)
+		in, _ := input.DigitalInputPin(0)
+		if v, err := in.Read(); err != nil || v {
+			t.Error(format, ": expected pump to read false", err)
+		}
+		a, err := NewAnalogs(Config{Address: filepath.Join(dir, "outputs."+format), Format: format, Channels: []ChannelConfig{{Field: "3"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := a.pins[0].Read(); err != nil || v != 42.5 {
+			t.Error(format, ": expected light at 42.5, found:", v, err)
+		}
+	}
+}
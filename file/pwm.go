@@ -0,0 +1,247 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/schema"
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+)
+
+var pwmMeta = hal.Metadata{
+	Name:         "pwm-file",
+	Description:  "Linux sysfs pwm driver",
+	Capabilities: []hal.Capability{hal.PWM, hal.DigitalOutput},
+}
+
+func init() {
+	drivers.Register(pwmMeta, pwmSchema, HalPWMAdapter)
+}
+
+const (
+	_sysfsPWM       = "/sys/class/pwm"
+	_defaultPWMFreq = 1000
+)
+
+var pwmSchema = schema.Object(schema.Properties{
+	"path":      schema.String().Describe("sysfs pwm root, defaults to " + _sysfsPWM),
+	"chip":      schema.Integer().Min(0),
+	"channels":  schema.Array(schema.Integer().Min(0)),
+	"frequency": schema.Integer().Min(1).WithDefault(_defaultPWMFreq).Describe("PWM frequency in Hz"),
+	"polarity":  schema.String().OneOf("normal", "inversed").WithDefault("normal"),
+})
+
+type PWMConfig struct {
+	// Path is the sysfs pwm root, defaults to /sys/class/pwm
+	Path      string `json:"path"`
+	Chip      int    `json:"chip"`
+	Channels  []int  `json:"channels"`
+	Frequency int    `json:"frequency"` // Hz
+	Polarity  string `json:"polarity"`  // normal or inversed
+}
+
+type pwm struct {
+	root     string
+	meta     hal.Metadata
+	channels []*pwmChannel
+}
+
+type pwmChannel struct {
+	chip   string
+	dir    string
+	number int
+	period int64 // nanoseconds
+	mu     sync.Mutex
+	v      float64
+	errs   drivers.ErrorLog
+}
+
+func HalPWMAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
+	c, err := pwmSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
+	var config PWMConfig
+	if err := json.Unmarshal(c, &config); err != nil {
+		return nil, err
+	}
+	return NewPWM(config)
+}
+
+func NewPWM(config PWMConfig) (*pwm, error) {
+	root := config.Path
+	if root == "" {
+		root = _sysfsPWM
+	}
+	if config.Frequency <= 0 {
+		log.Println("WARNING: file pwm driver frequency not set. Falling back to", _defaultPWMFreq)
+		config.Frequency = _defaultPWMFreq
+	}
+	switch config.Polarity {
+	case "":
+		config.Polarity = "normal"
+	case "normal", "inversed":
+	default:
+		return nil, fmt.Errorf("invalid pwm polarity: %s", config.Polarity)
+	}
+	chip := filepath.Join(root, fmt.Sprintf("pwmchip%d", config.Chip))
+	p := &pwm{
+		root: root,
+		meta: pwmMeta,
+	}
+	for _, n := range config.Channels {
+		ch := &pwmChannel{
+			chip:   chip,
+			dir:    filepath.Join(chip, fmt.Sprintf("pwm%d", n)),
+			number: n,
+			period: int64(1e9 / config.Frequency),
+		}
+		if err := ch.setup(config.Polarity); err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.channels = append(p.channels, ch)
+	}
+	return p, nil
+}
+
+func (c *pwmChannel) setup(polarity string) error {
+	if err := export(c.chip, c.dir, c.number, "period"); err != nil {
+		return err
+	}
+	// polarity can not be changed while enabled, and period must never
+	// drop below the current duty cycle
+	attrs := [][2]string{
+		{"enable", "0"},
+		{"duty_cycle", "0"},
+		{"period", strconv.FormatInt(c.period, 10)},
+		{"polarity", polarity},
+		{"enable", "1"},
+	}
+	for _, a := range attrs {
+		if err := c.write(a[0], a[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *pwmChannel) write(attr, value string) error {
+	return c.errs.Record(ioutil.WriteFile(filepath.Join(c.dir, attr), []byte(value), 0644))
+}
+
+func (c *pwmChannel) Name() string {
+	return fmt.Sprintf("%s/pwm%d", filepath.Base(c.chip), c.number)
+}
+
+func (c *pwmChannel) Number() int {
+	return c.number
+}
+
+// value should be within 0-100
+func (c *pwmChannel) Set(value float64) error {
+	switch {
+	case value > 100:
+		return fmt.Errorf("invalid value: %f above 100", value)
+	case value < 0:
+		return fmt.Errorf("invalid value: %f below 0", value)
+	}
+	duty := int64(float64(c.period) * value / 100)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.write("duty_cycle", strconv.FormatInt(duty, 10)); err != nil {
+		return err
+	}
+	c.v = value
+	return nil
+}
+
+func (c *pwmChannel) Write(b bool) error {
+	var v float64
+	if b {
+		v = 100
+	}
+	return c.Set(v)
+}
+
+func (c *pwmChannel) LastState() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v == 100
+}
+
+func (c *pwmChannel) Close() error {
+	if err := c.write("enable", "0"); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(c.chip, "unexport"), []byte(strconv.Itoa(c.number)), 0644)
+}
+
+func (p *pwm) Metadata() hal.Metadata {
+	return p.meta
+}
+
+func (p *pwm) Close() error {
+	for _, ch := range p.channels {
+		if err := ch.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Diagnose checks the exported directory of every channel exists
+func (p *pwm) Diagnose(_ context.Context) drivers.Diagnosis {
+	diag := drivers.Diagnosis{Reachable: true}
+	for _, ch := range p.channels {
+		_, err := os.Stat(ch.dir)
+		check(&diag, &ch.errs, err)
+	}
+	if len(p.channels) > 0 {
+		diag.Set("chip", p.channels[0].chip)
+		diag.Set("period_ns", p.channels[0].period)
+	}
+	return diag
+}
+
+func (p *pwm) PWMChannels() []hal.PWMChannel {
+	var chs []hal.PWMChannel
+	for _, ch := range p.channels {
+		chs = append(chs, ch)
+	}
+	return chs
+}
+
+func (p *pwm) PWMChannel(n int) (hal.PWMChannel, error) {
+	for _, ch := range p.channels {
+		if ch.number == n {
+			return ch, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid channel %d", n)
+}
+
+func (p *pwm) DigitalOutputPins() []hal.DigitalOutputPin {
+	var pins []hal.DigitalOutputPin
+	for _, ch := range p.channels {
+		pins = append(pins, ch)
+	}
+	return pins
+}
+
+func (p *pwm) DigitalOutputPin(n int) (hal.DigitalOutputPin, error) {
+	return p.PWMChannel(n)
+}
+
+func (p *pwm) Pins(cap hal.Capability) ([]hal.Pin, error) {
+	return drivers.Pins(p, cap)
+}
@@ -0,0 +1,75 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reef-pi/hal"
+)
+
+func TestPWM(t *testing.T) {
+	root := fakeSysfsGPIO(t)
+	defer os.RemoveAll(root)
+	chip := filepath.Join(root, "pwmchip0")
+	for _, d := range []string{chip, filepath.Join(chip, "pwm1")} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"export", "unexport", "pwm1/period"} {
+		if err := ioutil.WriteFile(filepath.Join(chip, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := HalPWMAdapter([]byte(`{"path":"`+root+`", "chip":0, "channels":[1], "frequency":500, "polarity":"inversed"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := readFile(t, chip, "pwm1", "period"); v != "2000000" {
+		t.Error("Expected period 2000000, found:", v)
+	}
+	if v := readFile(t, chip, "pwm1", "polarity"); v != "inversed" {
+		t.Error("Expected inversed polarity, found:", v)
+	}
+	if v := readFile(t, chip, "pwm1", "enable"); v != "1" {
+		t.Error("Expected channel to be enabled, found:", v)
+	}
+	pwmDriver := d.(hal.PWMDriver)
+	if len(pwmDriver.PWMChannels()) != 1 {
+		t.Error("Expected a single pwm channel, found:", len(pwmDriver.PWMChannels()))
+	}
+	ch, err := pwmDriver.PWMChannel(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Set(25); err != nil {
+		t.Error(err)
+	}
+	if v := readFile(t, chip, "pwm1", "duty_cycle"); v != "500000" {
+		t.Error("Expected duty cycle 500000, found:", v)
+	}
+	if err := ch.Set(101); err == nil {
+		t.Error("Values above 100 should fail")
+	}
+	if err := ch.Write(true); err != nil || !ch.LastState() {
+		t.Error("Expected channel to be on", err)
+	}
+	if diag := d.(*pwm).Diagnose(context.Background()); !diag.Reachable || diag.Fields["period_ns"] != "2000000" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+	if _, err := pwmDriver.PWMChannel(0); err == nil {
+		t.Error("Unconfigured channels should fail")
+	}
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+	if v := readFile(t, chip, "unexport"); v != "1" {
+		t.Error("Expected channel 1 to be unexported, found:", v)
+	}
+	if _, err := NewPWM(PWMConfig{Path: root, Polarity: "upside-down"}); err == nil {
+		t.Error("Invalid polarity should fail")
+	}
+}
@@ -0,0 +1,305 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/schema"
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+)
+
+var sensorMeta = hal.Metadata{
+	Name:         "sensor-file",
+	Description:  "Linux IIO and hwmon sensor driver",
+	Capabilities: []hal.Capability{hal.AnalogInput},
+}
+
+func init() {
+	drivers.Register(sensorMeta, sensorSchema, HalSensorAdapter)
+}
+
+var (
+	_iioRaw     = regexp.MustCompile(`^in_([a-z]+)([^_]*)_raw$`)
+	_hwmonInput = regexp.MustCompile(`^([a-z]+)([0-9]+)_input$`)
+	// hwmon reports values in milli (or micro) units, see
+	// https://www.kernel.org/doc/Documentation/hwmon/sysfs-interface
+	_hwmonScale = map[string]float64{
+		"temp":     0.001,
+		"in":       0.001,
+		"curr":     0.001,
+		"humidity": 0.001,
+		"power":    0.000001,
+		"energy":   0.000001,
+		"fan":      1,
+	}
+)
+
+var sensorSchema = schema.Object(schema.Properties{
+	"path": schema.String().NonEmpty().Describe("IIO device or hwmon chip directory"),
+}, "path")
+
+type SensorConfig struct {
+	// Path is an IIO device (e.g. /sys/bus/iio/devices/iio:device0) or a
+	// hwmon chip (e.g. /sys/class/hwmon/hwmon0) directory
+	Path string `json:"path"`
+}
+
+type sensor struct {
+	path     string
+	meta     hal.Metadata
+	channels []*sensorChannel
+}
+
+type sensorChannel struct {
+	name       string
+	number     int
+	raw        string
+	scale      []string   // candidate scale files, first existing one wins
+	offset     []string   // candidate offset files, first existing one wins
+	factor     float64    // fixed scale applied when no scale file exists
+	mu         sync.Mutex // guards calibrator
+	calibrator hal.Calibrator
+	errs       drivers.ErrorLog
+}
+
+func HalSensorAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
+	c, err := sensorSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
+	var config SensorConfig
+	if err := json.Unmarshal(c, &config); err != nil {
+		return nil, err
+	}
+	return NewSensor(config.Path)
+}
+
+// NewSensor enumerates every channel of an IIO device or hwmon chip
+func NewSensor(p string) (*sensor, error) {
+	files, err := ioutil.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	// kernel indices are not zero padded, sort by type then index so in10
+	// follows in9 rather than in1
+	type entry struct {
+		kind  string
+		index int
+		ch    *sensorChannel
+	}
+	var entries []entry
+	for _, f := range files {
+		var e entry
+		var index string
+		if m := _iioRaw.FindStringSubmatch(f.Name()); m != nil {
+			e.kind, index = m[1], m[2]
+			e.ch = iioChannel(p, m[1], m[2])
+		} else if m := _hwmonInput.FindStringSubmatch(f.Name()); m != nil {
+			e.kind, index = m[1], m[2]
+			e.ch = hwmonChannel(p, m[1], m[2])
+		}
+		if e.ch == nil {
+			continue
+		}
+		e.index = kernelIndex(index)
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.index != b.index {
+			return a.index < b.index
+		}
+		return a.ch.name < b.ch.name
+	})
+	s := &sensor{
+		path: p,
+		meta: sensorMeta,
+	}
+	for _, e := range entries {
+		cal, err := hal.CalibratorFactory([]hal.Measurement{})
+		if err != nil {
+			return nil, err
+		}
+		e.ch.calibrator = cal
+		e.ch.number = len(s.channels)
+		s.channels = append(s.channels, e.ch)
+	}
+	if len(s.channels) == 0 {
+		return nil, fmt.Errorf("no iio or hwmon channels found under %s", p)
+	}
+	return s, nil
+}
+
+// kernelIndex returns the leading channel number of an IIO or hwmon index,
+// e.g. 1 for "1-voltage2", or -1 for unindexed channels like in_temp_raw
+func kernelIndex(index string) int {
+	end := 0
+	for end < len(index) && index[end] >= '0' && index[end] <= '9' {
+		end++
+	}
+	n, err := strconv.Atoi(index[:end])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func iioChannel(dir, kind, index string) *sensorChannel {
+	prefix := filepath.Join(dir, "in_"+kind+index)
+	shared := filepath.Join(dir, "in_"+kind)
+	return &sensorChannel{
+		name:   kind + index,
+		raw:    prefix + "_raw",
+		scale:  []string{prefix + "_scale", shared + "_scale"},
+		offset: []string{prefix + "_offset", shared + "_offset"},
+		factor: 1,
+	}
+}
+
+func hwmonChannel(dir, kind, index string) *sensorChannel {
+	factor, ok := _hwmonScale[kind]
+	if !ok {
+		factor = 1
+	}
+	name := kind + index
+	if label, err := ioutil.ReadFile(filepath.Join(dir, name+"_label")); err == nil {
+		name = strings.TrimSpace(string(label))
+	}
+	return &sensorChannel{
+		name:   name,
+		raw:    filepath.Join(dir, kind+index+"_input"),
+		factor: factor,
+	}
+}
+
+func readFloat(p string) (float64, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+}
+
+// readOptional returns the value of the first existing file, or def
+func readOptional(files []string, def float64) (float64, error) {
+	for _, f := range files {
+		v, err := readFloat(f)
+		if os.IsNotExist(err) {
+			continue
+		}
+		return v, err
+	}
+	return def, nil
+}
+
+func (c *sensorChannel) Name() string {
+	return c.name
+}
+
+func (c *sensorChannel) Number() int {
+	return c.number
+}
+
+func (c *sensorChannel) Close() error {
+	return nil
+}
+
+// Read returns the channel value with the kernel provided scale and offset applied
+func (c *sensorChannel) Read() (float64, error) {
+	v, err := c.read()
+	return v, c.errs.Record(err)
+}
+
+func (c *sensorChannel) read() (float64, error) {
+	raw, err := readFloat(c.raw)
+	if err != nil {
+		return 0, err
+	}
+	scale, err := readOptional(c.scale, c.factor)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := readOptional(c.offset, 0)
+	if err != nil {
+		return 0, err
+	}
+	return (raw + offset) * scale, nil
+}
+
+func (c *sensorChannel) Measure() (float64, error) {
+	v, err := c.Read()
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	cal := c.calibrator
+	c.mu.Unlock()
+	if cal == nil {
+		return 0, fmt.Errorf("Not calibrated")
+	}
+	return cal.Calibrate(v), nil
+}
+
+func (c *sensorChannel) Calibrate(points []hal.Measurement) error {
+	cal, err := hal.CalibratorFactory(points)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.calibrator = cal
+	c.mu.Unlock()
+	return nil
+}
+
+func (s *sensor) Metadata() hal.Metadata {
+	return s.meta
+}
+
+func (s *sensor) Close() error {
+	return nil
+}
+
+// Diagnose checks the raw value file of every channel exists
+func (s *sensor) Diagnose(_ context.Context) drivers.Diagnosis {
+	diag := drivers.Diagnosis{Reachable: true}
+	for _, ch := range s.channels {
+		_, err := os.Stat(ch.raw)
+		check(&diag, &ch.errs, err)
+	}
+	diag.Set("path", s.path)
+	diag.Set("channels", len(s.channels))
+	return diag
+}
+
+func (s *sensor) AnalogInputPins() []hal.AnalogInputPin {
+	var pins []hal.AnalogInputPin
+	for _, ch := range s.channels {
+		pins = append(pins, ch)
+	}
+	return pins
+}
+
+func (s *sensor) AnalogInputPin(n int) (hal.AnalogInputPin, error) {
+	if n < 0 || n >= len(s.channels) {
+		return nil, fmt.Errorf("invalid channel %d", n)
+	}
+	return s.channels[n], nil
+}
+
+func (s *sensor) Pins(cap hal.Capability) ([]hal.Pin, error) {
+	return drivers.Pins(s, cap)
+}
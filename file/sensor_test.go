@@ -0,0 +1,131 @@
+package file
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/reef-pi/hal"
+)
+
+func fakeSensorDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "hal-file-sensor-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for f, v := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestIIOSensor(t *testing.T) {
+	dir := fakeSensorDir(t, map[string]string{
+		"name":                     "ads1015\n",
+		"in_voltage0_raw":          "1000\n",
+		"in_voltage0_scale":        "3.0\n",
+		"in_voltage1_raw":          "200\n",
+		"in_voltage_scale":         "2.0\n",
+		"in_voltage0-voltage1_raw": "-50\n",
+		"in_temp_raw":              "100\n",
+		"in_temp_offset":           "-10\n",
+		"in_temp_scale":            "0.5\n",
+	})
+	defer os.RemoveAll(dir)
+	d, err := HalSensorAdapter([]byte(`{"path":"`+dir+`"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := d.(hal.AnalogInputDriver)
+	pins := input.AnalogInputPins()
+	if len(pins) != 4 {
+		t.Fatal("Expected 4 channels, found:", len(pins))
+	}
+	expected := map[string]float64{
+		"temp":              45,
+		"voltage0":          3000,
+		"voltage0-voltage1": -100,
+		"voltage1":          400,
+	}
+	for _, p := range pins {
+		v, err := p.Read()
+		if err != nil {
+			t.Error(err)
+		}
+		if e, ok := expected[p.Name()]; !ok || e != v {
+			t.Errorf("Channel %s: expected %f, found: %f", p.Name(), e, v)
+		}
+	}
+	if err := pins[0].Calibrate([]hal.Measurement{{Expected: 50, Observed: 45}}); err != nil {
+		t.Error(err)
+	}
+	if v, err := pins[0].Measure(); err != nil || v != 50 {
+		t.Error("Expected calibrated value 50, found:", v, err)
+	}
+	if _, err := input.AnalogInputPin(4); err == nil {
+		t.Error("Expected error for invalid channel")
+	}
+}
+
+func TestHwmonSensor(t *testing.T) {
+	dir := fakeSensorDir(t, map[string]string{
+		"name":        "cpu_thermal\n",
+		"temp1_input": "23125\n",
+		"temp1_label": "water\n",
+		"in0_input":   "3300\n",
+	})
+	defer os.RemoveAll(dir)
+	d, err := NewSensor(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins := d.AnalogInputPins()
+	if len(pins) != 2 {
+		t.Fatal("Expected 2 channels, found:", len(pins))
+	}
+	if pins[1].Name() != "water" {
+		t.Error("Expected label to be used as name, found:", pins[1].Name())
+	}
+	v, err := pins[1].Read()
+	if err != nil {
+		t.Error(err)
+	}
+	if math.Abs(v-23.125) > 1e-9 {
+		t.Error("Expected 23.125, found:", v)
+	}
+	if _, err := NewSensor(os.TempDir() + "/does-not-exist"); err == nil {
+		t.Error("Expected error for missing directory")
+	}
+}
+
+func TestSensorOrder(t *testing.T) {
+	dir := fakeSensorDir(t, map[string]string{
+		"in_voltage10_raw": "1\n",
+		"in_voltage2_raw":  "1\n",
+		"in_voltage1_raw":  "1\n",
+		"in_temp_raw":      "1\n",
+		"temp10_input":     "1000\n",
+		"temp2_input":      "1000\n",
+	})
+	defer os.RemoveAll(dir)
+	d, err := NewSensor(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i, p := range d.AnalogInputPins() {
+		if p.Number() != i {
+			t.Error("Expected channel number", i, "found:", p.Number())
+		}
+		names = append(names, p.Name())
+	}
+	expected := []string{"temp", "temp2", "temp10", "voltage1", "voltage2", "voltage10"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Error("Expected channels in kernel order", expected, "found:", names)
+	}
+}
@@ -0,0 +1,107 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// watcher notifies subscribers after a file changed. Subscribers are
+// called once a burst of changes settled for the debounce duration, hooks
+// are called on every change
+type watcher struct {
+	debounce time.Duration
+	mu       sync.Mutex
+	hooks    []func()
+	subs     []func()
+	timer    *time.Timer
+	stop     func() error
+}
+
+func newWatcher(p string, debounce time.Duration) (*watcher, error) {
+	w := &watcher{debounce: debounce}
+	stop, err := watchFile(p, w.changed)
+	if err != nil {
+		return nil, err
+	}
+	w.stop = stop
+	return w, nil
+}
+
+func (w *watcher) hook(fn func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hooks = append(w.hooks, fn)
+}
+
+func (w *watcher) Subscribe(fn func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subs = append(w.subs, fn)
+}
+
+func (w *watcher) changed() {
+	w.mu.Lock()
+	hooks := append([]func(){}, w.hooks...)
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if w.debounce > 0 {
+		w.timer = time.AfterFunc(w.debounce, w.notify)
+	}
+	w.mu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+	if w.debounce == 0 {
+		w.notify()
+	}
+}
+
+func (w *watcher) notify() {
+	w.mu.Lock()
+	subs := append([]func(){}, w.subs...)
+	w.mu.Unlock()
+	for _, fn := range subs {
+		fn()
+	}
+}
+
+func (w *watcher) Close() error {
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	return w.stop()
+}
+
+// writeAtomic writes data to a temporary file next to p and renames it
+// over p, so that concurrent readers never observe a partial write
+func writeAtomic(p string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// watchFile calls fn whenever p is written, created, replaced or removed.
+// The parent directory is watched, as atomic writes replace the inode
+func watchFile(p string, fn func()) (func() error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE)
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(p), mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "inotify")
+	name := filepath.Base(p)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				start := off + syscall.SizeofInotifyEvent
+				off = start + int(ev.Len)
+				if off > n {
+					break
+				}
+				if strings.TrimRight(string(buf[start:off]), "\000") == name {
+					fn()
+				}
+			}
+		}
+	}()
+	return f.Close, nil
+}
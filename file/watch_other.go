@@ -0,0 +1,44 @@
+//go:build !linux
+// +build !linux
+
+package file
+
+import (
+	"os"
+	"time"
+)
+
+const _pollInterval = 500 * time.Millisecond
+
+// watchFile polls p for modification time and size changes
+func watchFile(p string, fn func()) (func() error, error) {
+	last, _ := os.Stat(p)
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(_pollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				info, _ := os.Stat(p)
+				if changed(last, info) {
+					fn()
+				}
+				last = info
+			}
+		}
+	}()
+	return func() error {
+		close(done)
+		return nil
+	}, nil
+}
+
+func changed(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a != b
+	}
+	return !a.ModTime().Equal(b.ModTime()) || a.Size() != b.Size()
+}
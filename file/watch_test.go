@@ -0,0 +1,133 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/reef-pi/hal"
+)
+
+func TestAtomicWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hal-file-watch-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "out")
+	d, err := HalDigitalAdapter([]byte(`{"address":"`+p+`", "atomic":true}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, err := d.(hal.DigitalOutputDriver).DigitalOutputPin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pin.Write(true); err != nil {
+		t.Fatal(err)
+	}
+	if v := readFile(t, p); v != "1" {
+		t.Error("Expected 1, found:", v)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Error("Expected temporary files to be renamed, found:", len(files))
+	}
+	if err := pin.(*digital).Subscribe(func() {}); err == nil {
+		t.Error("Subscribe should fail without watch mode")
+	}
+}
+
+func TestPlainWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hal-file-watch-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "value")
+	if err := ioutil.WriteFile(target, []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// like /sys/class/gpio/gpioN, writes go through the link
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDigital(link).Write(true); err != nil {
+		t.Fatal(err)
+	}
+	if v := readFile(t, target); v != "1" {
+		t.Error("Expected the link target to be written, found:", v)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Error("Expected the link to be kept:", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hal-file-watch-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "level")
+	if err := ioutil.WriteFile(p, []byte("1.5"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := HalAnalogAdapter([]byte(`{"address":"`+p+`", "watch":true, "debounce":50}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	pin, err := d.(hal.AnalogInputDriver).AnalogInputPin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := pin.Read(); err != nil || v != 1.5 {
+		t.Error("Expected 1.5, found:", v, err)
+	}
+	changes := make(chan struct{}, 10)
+	if err := pin.(*analog).Subscribe(func() { changes <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"2", "2.5", "3.5"} {
+		if err := writeAtomic(p, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected change notification")
+	}
+	select {
+	case <-changes:
+		t.Error("Expected burst of changes to be debounced")
+	case <-time.After(150 * time.Millisecond):
+	}
+	if v, err := pin.Read(); err != nil || v != 3.5 {
+		t.Error("Expected 3.5 after change, found:", v, err)
+	}
+
+	// writes are read back at once, without waiting for the notification
+	out, err := NewDigitals(Config{Address: p, Watch: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	dp := out.pins[0]
+	if _, err := dp.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dp.Write(true); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := dp.Read(); err != nil || !v {
+		t.Error("Expected the written value, found:", v, err)
+	}
+}
@@ -1,7 +1,9 @@
 package drivers
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/reef-pi/rpi/i2c"
 )
@@ -57,13 +59,16 @@ var digits = map[rune]uint16{
 }
 
 type HT16K33 struct {
+	mu     *sync.Mutex // guards buffer and orders writes to the chip
 	buffer []byte
 	bus    i2c.Bus
 	addr   byte
+	errs   ErrorLog
 }
 
 func NewHT16K33(bus i2c.Bus) *HT16K33 {
 	return &HT16K33{
+		mu:     DeviceLock(bus, 0x70),
 		bus:    bus,
 		buffer: make([]byte, 16),
 		addr:   byte(0x70),
@@ -71,6 +76,12 @@ func NewHT16K33(bus i2c.Bus) *HT16K33 {
 }
 
 func (h *HT16K33) Setup() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.errs.Record(h.setup())
+}
+
+func (h *HT16K33) setup() error {
 	if err := h.bus.WriteToReg(h.addr, REGISTER_SYSTEM_SETUP|0x01, []byte{0x00}); err != nil {
 		return err
 	}
@@ -90,7 +101,9 @@ func (h *HT16K33) Setup() error {
 }
 
 func (h *HT16K33) Blink() error {
-	return h.bus.WriteToReg(h.addr, REGISTER_DISPLAY_SETUP|0x01|(BLINKRATE_HALFHZ<<1), []byte{0x00})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.errs.Record(h.bus.WriteToReg(h.addr, REGISTER_DISPLAY_SETUP|0x01|(BLINKRATE_HALFHZ<<1), []byte{0x00}))
 }
 
 func (h *HT16K33) Display(word string) error {
@@ -98,9 +111,22 @@ func (h *HT16K33) Display(word string) error {
 		return fmt.Errorf("word length has to be exactly four character")
 	}
 
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	for i := 0; i <= 3; i++ {
 		item := digits[rune(word[i])]
 		h.buffer[i*2], h.buffer[i*2+1] = byte(item), byte(item>>8)
 	}
-	return h.bus.WriteToReg(h.addr, 0x00, h.buffer)
+	return h.errs.Record(h.bus.WriteToReg(h.addr, 0x00, h.buffer))
+}
+
+// Diagnose reads back the display RAM
+func (h *HT16K33) Diagnose(_ context.Context) Diagnosis {
+	h.mu.Lock()
+	_, err := h.bus.ReadBytes(h.addr, 16)
+	h.mu.Unlock()
+	diag := h.errs.Diagnosis(err)
+	diag.Hardware = "HT16K33"
+	diag.Set("address", fmt.Sprintf("0x%02x", h.addr))
+	return diag
 }
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers/i2ctest"
 )
 
 func TestHT16K33(t *testing.T) {
@@ -17,3 +19,31 @@ func TestHT16K33(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestHT16K33Protocol(t *testing.T) {
+	blank := make([]byte, 16)
+	bus := i2ctest.NewScript(
+		i2ctest.WriteReg(0x70, 0x21, 0x00),
+		i2ctest.WriteReg(0x70, 0xE0, 0x00),
+		i2ctest.WriteReg(0x70, 0x81, 0x00),
+		i2ctest.Read(0x70, blank...),
+		i2ctest.WriteReg(0x70, 0x00, blank...),
+		i2ctest.WriteReg(0x70, 0x00,
+			0xF3, 0x20, 0xF9, 0x00, 0xF9, 0x00, 0x71, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00),
+		i2ctest.WriteReg(0x70, 0x87, 0x00),
+	)
+	h := NewHT16K33(bus)
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Display("REEF"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Blink(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Done(); err != nil {
+		t.Error(err)
+	}
+}
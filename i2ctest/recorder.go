@@ -0,0 +1,79 @@
+package i2ctest
+
+import (
+	"sync"
+
+	"github.com/reef-pi/rpi/i2c"
+)
+
+// Recorder wraps a bus and records every transaction passing through it, to
+// capture a session with real hardware for later replay
+type Recorder struct {
+	bus i2c.Bus
+	mu  sync.Mutex
+	txs []Tx
+}
+
+func NewRecorder(bus i2c.Bus) *Recorder {
+	return &Recorder{bus: bus}
+}
+
+func (r *Recorder) record(t Tx, err error) {
+	if err != nil {
+		t = t.Fails(err.Error())
+	}
+	r.mu.Lock()
+	r.txs = append(r.txs, t)
+	r.mu.Unlock()
+}
+
+// Transactions returns the recorded session
+func (r *Recorder) Transactions() []Tx {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Tx(nil), r.txs...)
+}
+
+// Save writes the recorded session to a file that Replay can load
+func (r *Recorder) Save(path string) error {
+	return Save(path, r.Transactions())
+}
+
+func (r *Recorder) SetAddress(addr byte) error {
+	err := r.bus.SetAddress(addr)
+	r.record(SetAddress(addr), err)
+	return err
+}
+
+func (r *Recorder) ReadBytes(addr byte, num int) ([]byte, error) {
+	data, err := r.bus.ReadBytes(addr, num)
+	if err != nil {
+		// keep the length so replay matches the request
+		r.record(Read(addr, make([]byte, num)...), err)
+		return data, err
+	}
+	r.record(Read(addr, append([]byte(nil), data...)...), nil)
+	return data, nil
+}
+
+func (r *Recorder) WriteBytes(addr byte, value []byte) error {
+	err := r.bus.WriteBytes(addr, value)
+	r.record(Write(addr, append([]byte(nil), value...)...), err)
+	return err
+}
+
+func (r *Recorder) ReadFromReg(addr, reg byte, value []byte) error {
+	err := r.bus.ReadFromReg(addr, reg, value)
+	r.record(ReadReg(addr, reg, append([]byte(nil), value...)...), err)
+	return err
+}
+
+func (r *Recorder) WriteToReg(addr, reg byte, value []byte) error {
+	err := r.bus.WriteToReg(addr, reg, value)
+	r.record(WriteReg(addr, reg, append([]byte(nil), value...)...), err)
+	return err
+}
+
+func (r *Recorder) Close() error {
+	return r.bus.Close()
+}
@@ -0,0 +1,115 @@
+package i2ctest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Script is an i2c.Bus that expects an ordered list of transactions. Writes
+// must match byte for byte, reads must match address (and register) and
+// length, and are answered with the scripted data. The first mismatch is
+// returned to the caller and kept, see Done
+type Script struct {
+	mu  sync.Mutex
+	txs []Tx
+	pos int
+	err error
+}
+
+func NewScript(txs ...Tx) *Script {
+	return &Script{txs: txs}
+}
+
+// Replay loads a recorded session as a Script
+func Replay(path string) (*Script, error) {
+	txs, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewScript(txs...), nil
+}
+
+// Expect appends transactions to the script
+func (s *Script) Expect(txs ...Tx) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.txs = append(s.txs, txs...)
+}
+
+// Done returns the first mismatch, or an error when scripted transactions
+// were not issued
+func (s *Script) Done() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.pos < len(s.txs) {
+		return fmt.Errorf("%d transactions not issued, next: %s", len(s.txs)-s.pos, s.txs[s.pos])
+	}
+	return nil
+}
+
+func (s *Script) next(got Tx, n int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.pos >= len(s.txs) {
+		s.err = fmt.Errorf("unexpected transaction %s, script is complete", got)
+		return nil, s.err
+	}
+	want := s.txs[s.pos]
+	match := want.Op == got.Op && want.Addr == got.Addr && want.Reg == got.Reg
+	switch got.Op {
+	case OpRead, OpReadReg:
+		match = match && len(want.Data) == n
+	case OpWrite, OpWriteReg:
+		match = match && bytes.Equal(want.Data, got.Data)
+	}
+	if !match {
+		if got.Op == OpRead || got.Op == OpReadReg {
+			got.Data = make([]byte, n)
+		}
+		s.err = fmt.Errorf("transaction %d: expected %s, got %s", s.pos, want, got)
+		return nil, s.err
+	}
+	s.pos++
+	if want.Err != "" {
+		return nil, errors.New(want.Err)
+	}
+	return append([]byte(nil), want.Data...), nil
+}
+
+func (s *Script) SetAddress(addr byte) error {
+	_, err := s.next(SetAddress(addr), 0)
+	return err
+}
+
+func (s *Script) ReadBytes(addr byte, num int) ([]byte, error) {
+	return s.next(Read(addr), num)
+}
+
+func (s *Script) WriteBytes(addr byte, value []byte) error {
+	_, err := s.next(Write(addr, value...), len(value))
+	return err
+}
+
+func (s *Script) ReadFromReg(addr, reg byte, value []byte) error {
+	data, err := s.next(ReadReg(addr, reg), len(value))
+	if err != nil {
+		return err
+	}
+	copy(value, data)
+	return nil
+}
+
+func (s *Script) WriteToReg(addr, reg byte, value []byte) error {
+	_, err := s.next(WriteReg(addr, reg, value...), len(value))
+	return err
+}
+
+func (s *Script) Close() error { return nil }
@@ -0,0 +1,113 @@
+package i2ctest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScript(t *testing.T) {
+	s := NewScript(
+		Write(0x40, 0x01),
+		Read(0x40, 0x9A),
+		WriteReg(0x70, 0x21, 0x00),
+		ReadReg(0x70, 0x00, 0x01, 0x02),
+	)
+	if err := s.WriteBytes(0x40, []byte{0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := s.ReadBytes(0x40, 1); err != nil || !reflect.DeepEqual(b, []byte{0x9A}) {
+		t.Error("Unexpected read:", b, err)
+	}
+	if err := s.Done(); err == nil {
+		t.Error("Done should fail while transactions are pending")
+	}
+	if err := s.WriteToReg(0x70, 0x21, []byte{0x00}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if err := s.ReadFromReg(0x70, 0x00, buf); err != nil || !reflect.DeepEqual(buf, []byte{0x01, 0x02}) {
+		t.Error("Unexpected register read:", buf, err)
+	}
+	if err := s.Done(); err != nil {
+		t.Error(err)
+	}
+	if err := s.WriteBytes(0x40, []byte{0x01}); err == nil {
+		t.Error("Traffic after the script completes should fail")
+	}
+	if err := s.Done(); err == nil {
+		t.Error("Done should report unexpected traffic")
+	}
+}
+
+func TestScriptMismatch(t *testing.T) {
+	cases := []struct {
+		name string
+		call func(*Script) error
+	}{
+		{"data", func(s *Script) error { return s.WriteBytes(0x40, []byte{0x02}) }},
+		{"address", func(s *Script) error { return s.WriteBytes(0x41, []byte{0x01}) }},
+		{"op", func(s *Script) error { return s.WriteToReg(0x40, 0x01, nil) }},
+		{"length", func(s *Script) error { _, err := s.ReadBytes(0x40, 2); return err }},
+	}
+	for _, c := range cases {
+		s := NewScript(Write(0x40, 0x01), Read(0x40, 0x00))
+		if c.name == "length" {
+			s.WriteBytes(0x40, []byte{0x01})
+		}
+		if err := c.call(s); err == nil {
+			t.Error("Mismatched", c.name, "should fail")
+		}
+		if err := s.Done(); err == nil {
+			t.Error("Done should keep the", c.name, "mismatch")
+		}
+	}
+	s := NewScript(Read(0x40, 0x00).Fails("nack"))
+	if _, err := s.ReadBytes(0x40, 1); err == nil || err.Error() != "nack" {
+		t.Error("Expected scripted failure, found:", err)
+	}
+	if err := s.Done(); err != nil {
+		t.Error("Scripted failures are expected traffic:", err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "i2ctest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	session := []Tx{
+		Write(0x63, 'R', 0),
+		Read(0x63, 1, '7', '.', '0', 0),
+		WriteReg(0x40, 0xFE, 0x03),
+		ReadReg(0x40, 0x00, 0x11),
+		Read(0x63, 0, 0).Fails("nack"),
+	}
+	device := NewScript(session...)
+	r := NewRecorder(device)
+	r.WriteBytes(0x63, []byte{'R', 0})
+	r.ReadBytes(0x63, 5)
+	r.WriteToReg(0x40, 0xFE, []byte{0x03})
+	r.ReadFromReg(0x40, 0x00, make([]byte, 1))
+	r.ReadBytes(0x63, 2)
+	if err := device.Done(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Transactions(), session) {
+		t.Error("Unexpected recording:", r.Transactions())
+	}
+	path := filepath.Join(dir, "session.jsonl")
+	if err := r.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	replay, err := Replay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replay.txs, session) {
+		t.Error("Unexpected replay:", replay.txs)
+	}
+}
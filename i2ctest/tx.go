@@ -0,0 +1,162 @@
+// Package i2ctest provides an i2c.Bus for byte-exact driver tests. A Script
+// holds the ordered transactions a driver is expected to issue, answers reads
+// with canned responses and fails on any unexpected traffic. A Recorder wraps
+// a real bus and captures a session to a file that can later be replayed as
+// a Script.
+package i2ctest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// Transaction kinds, one for each i2c.Bus method
+const (
+	OpSetAddress = "set_address"
+	OpWrite      = "write"
+	OpRead       = "read"
+	OpWriteReg   = "write_reg"
+	OpReadReg    = "read_reg"
+)
+
+// Tx is a single bus transaction. For reads Data is the response, and Err
+// makes the transaction fail with the given message
+type Tx struct {
+	Op   string
+	Addr byte
+	Reg  byte
+	Data []byte
+	Err  string
+}
+
+func SetAddress(addr byte) Tx {
+	return Tx{Op: OpSetAddress, Addr: addr}
+}
+
+func Write(addr byte, data ...byte) Tx {
+	return Tx{Op: OpWrite, Addr: addr, Data: data}
+}
+
+func Read(addr byte, data ...byte) Tx {
+	return Tx{Op: OpRead, Addr: addr, Data: data}
+}
+
+func WriteReg(addr, reg byte, data ...byte) Tx {
+	return Tx{Op: OpWriteReg, Addr: addr, Reg: reg, Data: data}
+}
+
+func ReadReg(addr, reg byte, data ...byte) Tx {
+	return Tx{Op: OpReadReg, Addr: addr, Reg: reg, Data: data}
+}
+
+// Fails returns a copy of the transaction that fails with msg
+func (t Tx) Fails(msg string) Tx {
+	t.Err = msg
+	return t
+}
+
+func (t Tx) hasReg() bool {
+	return t.Op == OpWriteReg || t.Op == OpReadReg
+}
+
+func (t Tx) String() string {
+	s := fmt.Sprintf("%s 0x%02x", t.Op, t.Addr)
+	if t.hasReg() {
+		s += fmt.Sprintf(" reg 0x%02x", t.Reg)
+	}
+	if t.Op != OpSetAddress {
+		s += fmt.Sprintf(" [% x]", t.Data)
+	}
+	return s
+}
+
+// txJSON is the file format, with hex encoded bytes
+type txJSON struct {
+	Op   string `json:"op"`
+	Addr string `json:"addr"`
+	Reg  string `json:"reg,omitempty"`
+	Data string `json:"data,omitempty"`
+	Err  string `json:"err,omitempty"`
+}
+
+func (t Tx) MarshalJSON() ([]byte, error) {
+	j := txJSON{
+		Op:   t.Op,
+		Addr: fmt.Sprintf("0x%02x", t.Addr),
+		Data: hex.EncodeToString(t.Data),
+		Err:  t.Err,
+	}
+	if t.hasReg() {
+		j.Reg = fmt.Sprintf("0x%02x", t.Reg)
+	}
+	return json.Marshal(j)
+}
+
+func (t *Tx) UnmarshalJSON(b []byte) error {
+	var j txJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	addr, err := strconv.ParseUint(j.Addr, 0, 8)
+	if err != nil {
+		return fmt.Errorf("invalid addr %q: %v", j.Addr, err)
+	}
+	var reg uint64
+	if j.Reg != "" {
+		if reg, err = strconv.ParseUint(j.Reg, 0, 8); err != nil {
+			return fmt.Errorf("invalid reg %q: %v", j.Reg, err)
+		}
+	}
+	data, err := hex.DecodeString(j.Data)
+	if err != nil {
+		return fmt.Errorf("invalid data %q: %v", j.Data, err)
+	}
+	if len(data) == 0 {
+		data = nil
+	}
+	*t = Tx{Op: j.Op, Addr: byte(addr), Reg: byte(reg), Data: data, Err: j.Err}
+	return nil
+}
+
+// Save writes transactions to a file, one JSON object per line
+func Save(path string, txs []Tx) error {
+	var buf bytes.Buffer
+	for _, t := range txs {
+		line, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Load reads transactions saved by Save or a Recorder
+func Load(path string) ([]Tx, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var txs []Tx
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var t Tx
+		if err := json.Unmarshal(line, &t); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		txs = append(txs, t)
+	}
+	return txs, s.Err()
+}
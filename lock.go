@@ -0,0 +1,80 @@
+package drivers
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/reef-pi/rpi/i2c"
+)
+
+// busKey identifies a bus by its dynamic type and, for pointer like types,
+// its address. Unlike the interface value itself it is always comparable, and
+// the lock tables do not keep closed buses from being collected. Buses passed
+// by value have no identity, those of the same type share their locks
+type busKey struct {
+	typ reflect.Type
+	ptr uintptr
+}
+
+type deviceKey struct {
+	bus  busKey
+	addr byte
+}
+
+var (
+	locksMu     sync.Mutex
+	busLocks    = make(map[busKey]*sync.Mutex)
+	deviceLocks = make(map[deviceKey]*sync.Mutex)
+)
+
+func keyOf(bus i2c.Bus) busKey {
+	v := reflect.ValueOf(bus)
+	if !v.IsValid() {
+		return busKey{}
+	}
+	k := busKey{typ: v.Type()}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.UnsafePointer:
+		k.ptr = v.Pointer()
+	}
+	return k
+}
+
+// BusLock returns the transaction lock of bus, shared by every driver using
+// it. Hold it across short multi-step exchanges, like a command followed by
+// reading its response, so no other transfer lands in between. It must not
+// be held while waiting on a device, take the DeviceLock for that
+func BusLock(bus i2c.Bus) *sync.Mutex {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	k := keyOf(bus)
+	l, ok := busLocks[k]
+	if !ok {
+		l = new(sync.Mutex)
+		busLocks[k] = l
+	}
+	return l
+}
+
+// DeviceLock returns the lock of the device at addr on bus, shared by every
+// driver instance talking to it. Hold it across exchanges that wait on the
+// device, e.g. for a conversion. Device locks are taken before the bus lock
+func DeviceLock(bus i2c.Bus, addr byte) *sync.Mutex {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	k := deviceKey{bus: keyOf(bus), addr: addr}
+	l, ok := deviceLocks[k]
+	if !ok {
+		l = new(sync.Mutex)
+		deviceLocks[k] = l
+	}
+	return l
+}
+
+// Transaction runs f holding the transaction lock of bus
+func Transaction(bus i2c.Bus, f func() error) error {
+	l := BusLock(bus)
+	l.Lock()
+	defer l.Unlock()
+	return f()
+}
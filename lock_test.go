@@ -0,0 +1,112 @@
+package drivers_test
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/ads1x15"
+	"github.com/dmolavi/drivers/pca9685"
+	"github.com/dmolavi/drivers/sim"
+)
+
+func TestLocks(t *testing.T) {
+	a, b := sim.NewBus(), sim.NewBus()
+	if drivers.BusLock(a) != drivers.BusLock(a) {
+		t.Error("Expected a single transaction lock per bus")
+	}
+	if drivers.BusLock(a) == drivers.BusLock(b) {
+		t.Error("Expected separate transaction locks per bus")
+	}
+	if drivers.DeviceLock(a, 0x48) != drivers.DeviceLock(a, 0x48) {
+		t.Error("Expected a single lock per device")
+	}
+	if drivers.DeviceLock(a, 0x48) == drivers.DeviceLock(a, 0x49) || drivers.DeviceLock(a, 0x48) == drivers.DeviceLock(b, 0x48) {
+		t.Error("Expected separate locks per device")
+	}
+	failed := errors.New("failed")
+	if err := drivers.Transaction(a, func() error { return failed }); err != failed {
+		t.Error("Expected the transaction error, found:", err)
+	}
+	if err := drivers.Transaction(a, func() error { return nil }); err != nil {
+		t.Error(err)
+	}
+
+	// buses passed by value may not be comparable, e.g. when holding a slice
+	c := sliceBus{Bus: a, log: []string{}}
+	if drivers.BusLock(c) != drivers.BusLock(c) || drivers.DeviceLock(c, 0x48) != drivers.DeviceLock(c, 0x48) {
+		t.Error("Expected stable locks for a non comparable bus")
+	}
+	if drivers.BusLock(c) == drivers.BusLock(a) {
+		t.Error("Expected separate transaction locks per bus type")
+	}
+}
+
+type sliceBus struct {
+	i2c.Bus
+	log []string
+}
+
+// Two driver instances share the single shot ADS1115, their conversions
+// must not pick up each other's multiplexer setting
+func TestConcurrentDrivers(t *testing.T) {
+	tank := sim.NewTank()
+	tank.ADS1115.SetInput(0, sim.Constant(1))
+	tank.ADS1115.SetInput(1, sim.Constant(2))
+	pin := func(n int) hal.AnalogInputPin {
+		d, err := ads1x15.HalAdapter([]byte(`{"address":"0x48"}`), tank)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := d.(hal.AnalogInputDriver).AnalogInputPin(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	pwm, err := pca9685.HALAdapter([]byte(`{"address":64}`), tank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, _ := pwm.(hal.PWMDriver).PWMChannel(0)
+	display := drivers.NewHT16K33(i2c.Bus(tank))
+	if err := display.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	run := func(f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if err := f(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for n, expected := range []float64{1, 2} {
+		p, expected := pin(n), expected
+		run(func() error {
+			v, err := p.Read()
+			if err == nil && math.Abs(v-expected) > 0.001 {
+				t.Error("Expected", expected, "found:", v)
+			}
+			return err
+		})
+	}
+	run(func() error { return ch.Set(50) })
+	run(func() error { return display.Display("REEF") })
+	run(func() error {
+		ch.LastState()
+		return nil
+	})
+	wg.Wait()
+}
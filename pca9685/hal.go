@@ -1,24 +1,148 @@
 package pca9685
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"math"
 	"sort"
+	"strings"
 	"sync"
 
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/schema"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
 
+var driverMeta = hal.Metadata{
+	Name:        "pca9685",
+	Description: "Supports one or more chained PCA9685 chips",
+	Capabilities: []hal.Capability{
+		hal.PWM, hal.DigitalOutput,
+	},
+}
+
+var configSchema = schema.Object(schema.Properties{
+	"address": schema.Address().WithDefault(0x40),
+	"frequency": schema.Integer().Range(1, 3052).WithDefault(1500).
+		Describe("PWM frequency in Hz, 24-1526 with the internal oscillator, scaled by external_clock/25MHz otherwise"),
+	"offsets": schema.Array(schema.Integer().Range(0, pwmControlPoints-1)).
+		Describe("Per channel phase offsets in ticks"),
+	"stagger": schema.Boolean().Describe("Spread channel on-times across the PWM period"),
+	"curve": schema.Array(schema.Number().Range(0, 100)).
+		Describe("Perceptual lookup table, evenly spaced over the 0-100 input range"),
+	"servos": schema.Array(schema.Object(schema.Properties{
+		"channel":   schema.Integer().Min(0),
+		"min_pulse": schema.Number().Min(0).Describe("Pulse width in microseconds at min_angle"),
+		"max_pulse": schema.Number().Min(0).Describe("Pulse width in microseconds at max_angle"),
+		"min_angle": schema.Number(),
+		"max_angle": schema.Number(),
+	}, "channel")),
+	"addresses":      schema.Array(schema.Address()).Describe("Chained chips, 16 channels each"),
+	"external_clock": schema.Integer().Range(0, 50000000).Describe("EXTCLK frequency in Hz, 0 uses the internal oscillator"),
+	"open_drain":     schema.Boolean(),
+	"invert":         schema.Boolean(),
+	"output_ne":      schema.Integer().Range(0, 2).Describe("Output state while OE is high"),
+	"close":          schema.String().OneOf(CloseOff, CloseHold, CloseProfile).WithDefault(CloseOff),
+	"profile":        schema.Array(schema.Number().Range(0, 100)).Describe("Channel values applied on close"),
+})
+
+func init() {
+	drivers.Register(driverMeta, configSchema, HALAdapter)
+}
+
 type PCA9685Config struct {
 	Address   int `json:"address"` // 0x40
 	Frequency int `json:"frequency"`
+	// Offsets holds per channel phase offsets (0-4095 ticks). Channels
+	// without an entry switch on at tick 0, unless Stagger is set
+	Offsets []int `json:"offsets"`
+	// Stagger spreads channel on-times evenly across the PWM period to
+	// avoid all outputs switching on at the same instant
+	Stagger bool `json:"stagger"`
+	// Curve is an optional perceptual lookup table. Its points are evenly
+	// spaced over the 0-100 input range and hold the output duty (0-100),
+	// values in between are linearly interpolated
+	Curve []float64 `json:"curve"`
+	// Servos configures channels that drive hobby servos instead of plain PWM
+	Servos []ServoConfig `json:"servos"`
+	// Addresses lists chained chips driven as one flat channel list, 16
+	// channels per chip in the given order. Address is used when empty
+	Addresses []int `json:"addresses"`
+	// ExternalClock is the EXTCLK frequency in Hz, 0 uses the internal oscillator
+	ExternalClock int `json:"external_clock"`
+	// OpenDrain configures outputs as open-drain instead of totem-pole
+	OpenDrain bool `json:"open_drain"`
+	// Invert inverts the output logic state (INVRT)
+	Invert bool `json:"invert"`
+	// OutputNE sets the OUTNE bits, the output state while OE is high (0-2)
+	OutputNE int `json:"output_ne"`
+	// Close selects what happens to the outputs when the driver is closed,
+	// one of CloseOff (default), CloseHold or CloseProfile
+	Close string `json:"close"`
+	// Profile holds per channel values (0-100) applied on close by CloseProfile
+	Profile []float64 `json:"profile"`
+}
+
+const (
+	// CloseOff turns every channel off and puts the chips to sleep
+	CloseOff = "off"
+	// CloseHold leaves the outputs as they are
+	CloseHold = "hold"
+	// CloseProfile applies the configured profile and keeps the chips awake
+	CloseProfile = "profile"
+)
+
+func (c PCA9685Config) validateClose(channels int) error {
+	switch c.Close {
+	case "", CloseOff, CloseHold:
+	case CloseProfile:
+		if len(c.Profile) > channels {
+			return fmt.Errorf("profile has %d values, driver has only %d channels", len(c.Profile), channels)
+		}
+		for i, v := range c.Profile {
+			if v < 0 || v > 100 {
+				return fmt.Errorf("invalid profile value %f for channel %d", v, i)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid close behavior: %s", c.Close)
+	}
+	return nil
+}
+
+func (c PCA9685Config) validateFrequency() error {
+	clock := clockFreq
+	if c.ExternalClock > 0 {
+		clock = c.ExternalClock
+	}
+	min, max := frequencyRange(clock)
+	if c.Frequency < min || c.Frequency > max {
+		return fmt.Errorf("invalid frequency: %d Hz not within %d-%d with a %d Hz clock", c.Frequency, min, max, clock)
+	}
+	return nil
+}
+
+func (c PCA9685Config) mode2() (byte, error) {
+	if c.OutputNE < 0 || c.OutputNE > 2 {
+		return 0, fmt.Errorf("invalid output_ne: %d not within 0-2", c.OutputNE)
+	}
+	mode2 := byte(c.OutputNE)
+	if !c.OpenDrain {
+		mode2 |= mode2TotemPol
+	}
+	if c.Invert {
+		mode2 |= mode2Invert
+	}
+	return mode2, nil
 }
 
 type pca9685Channel struct {
 	driver  *pca9685Driver
 	channel int
+	offset  uint16
+	servo   *ServoConfig
 	v       float64
 }
 
@@ -26,30 +150,36 @@ func (c *pca9685Channel) Name() string { return fmt.Sprintf("%d", c.channel) }
 func (c *pca9685Channel) Number() int  { return c.channel }
 func (c *pca9685Channel) Close() error { return nil }
 func (c *pca9685Channel) Set(value float64) error {
-	if err := c.driver.set(c.channel, value); err != nil {
-		return err
+	if c.servo != nil {
+		return c.setServo(value)
 	}
-	c.v = value
-	return nil
+	return c.driver.set(c, value)
 }
 func (c *pca9685Channel) Write(b bool) error {
 	var v float64
 	if b {
 		v = 100
 	}
-	if err := c.driver.set(c.channel, v); err != nil {
-		return err
+	if c.servo != nil {
+		return c.setServo(v)
 	}
-	c.v = v
-	return nil
+	return c.driver.set(c, v)
 }
 
-func (c *pca9685Channel) LastState() bool { return c.v == 100 }
+func (c *pca9685Channel) LastState() bool { return c.Value() == 100 }
+
+// Value returns the last value (0-100) set or read back from the hardware
+func (c *pca9685Channel) Value() float64 {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+	return c.v
+}
 
 type pca9685Driver struct {
 	config   PCA9685Config
-	hwDriver *PCA9685
-	mu       *sync.Mutex
+	chips    []*PCA9685
+	mu       *sync.Mutex // guards the chips and channel values
+	errs     drivers.ErrorLog
 	channels []*pca9685Channel
 }
 
@@ -59,53 +189,156 @@ var DefaultPCA9685Config = PCA9685Config{
 }
 
 func HALAdapter(c []byte, bus i2c.Bus) (hal.Driver, error) {
+	c, err := configSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
 	config := DefaultPCA9685Config
 	if err := json.Unmarshal(c, &config); err != nil {
 		return nil, err
 	}
 
-	hwDriver := New(byte(config.Address), bus)
 	pwm := pca9685Driver{
-		config:   config,
-		mu:       &sync.Mutex{},
-		hwDriver: hwDriver,
+		config: config,
+		mu:     &sync.Mutex{},
+	}
+	if err := config.validateFrequency(); err != nil {
+		return nil, err
+	}
+	if err := validateCurve(config.Curve); err != nil {
+		return nil, err
+	}
+	mode2, err := config.mode2()
+	if err != nil {
+		return nil, err
+	}
+	addresses := config.Addresses
+	if len(addresses) == 0 {
+		addresses = []int{config.Address}
 	}
-	if config.Frequency == 0 {
-		log.Println("WARNING: pca9685 driver pwm frequency set to 0. Falling back to 1500")
-		config.Frequency = 1500
+	for _, addr := range addresses {
+		hwDriver := New(byte(addr), bus)
+		hwDriver.Freq = config.Frequency // overriding default
+		hwDriver.ExtClock = config.ExternalClock
+		hwDriver.Mode2 = mode2
+		pwm.chips = append(pwm.chips, hwDriver)
 	}
-	hwDriver.Freq = config.Frequency // overriding default
 
-	// Create the 16 channels the hardware has
-	for i := 0; i < 16; i++ {
+	// Create the 16 channels each chip has
+	for i := 0; i < 16*len(pwm.chips); i++ {
+		offset, err := channelOffset(config, i)
+		if err != nil {
+			return nil, err
+		}
 		ch := &pca9685Channel{
 			channel: i,
+			offset:  offset,
 			driver:  &pwm,
 		}
 		pwm.channels = append(pwm.channels, ch)
 	}
+	for i := range config.Servos {
+		s := config.Servos[i]
+		if s.Channel < 0 || s.Channel >= len(pwm.channels) {
+			return nil, fmt.Errorf("invalid servo channel %d", s.Channel)
+		}
+		if err := s.setDefaults(); err != nil {
+			return nil, err
+		}
+		pwm.channels[s.Channel].servo = &s
+	}
+
+	if err := config.validateClose(len(pwm.channels)); err != nil {
+		return nil, err
+	}
 
 	// Wake the hardware
-	return &pwm, hwDriver.Wake()
+	for _, hwDriver := range pwm.chips {
+		if err := hwDriver.Wake(); err != nil {
+			return &pwm, err
+		}
+	}
+	return &pwm, pwm.readback()
+}
+
+// readback loads channel values from the hardware, so that outputs left
+// running by a previous process are reflected by the driver state
+func (p *pca9685Driver) readback() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ch := range p.channels {
+		chip := p.chips[ch.channel/16]
+		on, off, err := chip.GetPwm(ch.channel % 16)
+		if err != nil {
+			return p.errs.Record(err)
+		}
+		ticks := dutyTicks(on, off)
+		if ch.servo != nil {
+			us := chip.PulseWidth(ticks)
+			v := (us - ch.servo.MinPulse) / (ch.servo.MaxPulse - ch.servo.MinPulse) * 100
+			ch.v = math.Max(0, math.Min(100, v))
+			continue
+		}
+		ch.v = invertCurve(p.config.Curve, float64(ticks)*100/(pwmControlPoints-1))
+	}
+	return nil
 }
 
 func (p *pca9685Driver) Close() error {
-	// Close the driver (will clear all registers)
-	if err := p.hwDriver.Close(); err != nil {
-		return err
+	switch p.config.Close {
+	case CloseHold:
+		return nil
+	case CloseProfile:
+		for i, v := range p.config.Profile {
+			if err := p.channels[i].Set(v); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	// Send the hardware to sleep
-	return p.hwDriver.Sleep()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, hwDriver := range p.chips {
+		// Close the driver (will clear all registers)
+		if err := hwDriver.Close(); err != nil {
+			return p.errs.Record(err)
+		}
+		// Send the hardware to sleep
+		if err := hwDriver.Sleep(); err != nil {
+			return p.errs.Record(err)
+		}
+	}
+	return nil
 }
 
-func (p *pca9685Driver) Metadata() hal.Metadata {
-	return hal.Metadata{
-		Name:        "pca9685",
-		Description: "Supports one PCA9685 chip",
-		Capabilities: []hal.Capability{
-			hal.PWM, hal.DigitalOutput,
-		},
+// Diagnose reads MODE1 of every chip, reporting whether it is asleep
+func (p *pca9685Driver) Diagnose(ctx context.Context) drivers.Diagnosis {
+	err := ctx.Err()
+	var chips []string
+	p.mu.Lock()
+	for _, chip := range p.chips {
+		if err != nil {
+			break
+		}
+		var mode1 byte
+		if mode1, err = chip.mode1Reg(); err == nil {
+			state := "awake"
+			if mode1&mode1Sleep != 0 {
+				state = "asleep"
+			}
+			chips = append(chips, fmt.Sprintf("0x%02x %s", chip.addr, state))
+		}
 	}
+	p.mu.Unlock()
+	d := p.errs.Diagnosis(err)
+	d.Hardware = "PCA9685"
+	d.Set("chips", strings.Join(chips, ", "))
+	d.Set("frequency", p.config.Frequency)
+	return d
+}
+
+func (p *pca9685Driver) Metadata() hal.Metadata {
+	return driverMeta
 }
 
 func (p *pca9685Driver) PWMChannels() []hal.PWMChannel {
@@ -138,7 +371,7 @@ func (p *pca9685Driver) DigitalOutputPin(n int) (hal.DigitalOutputPin, error) {
 }
 
 // value should be within 0-100
-func (p *pca9685Driver) set(pin int, value float64) error {
+func (p *pca9685Driver) set(c *pca9685Channel, value float64) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -147,24 +380,121 @@ func (p *pca9685Driver) set(pin int, value float64) error {
 		return fmt.Errorf("invalid value: %f above 100", value)
 	case value < 0:
 		return fmt.Errorf("invalid value: %f below 0", value)
-	case value == 0:
-		return p.hwDriver.SetPwm(pin, 0, 4096)
-	case value == 100:
-		return p.hwDriver.SetPwm(pin, 4096, 0)
-	default:
-		return p.hwDriver.SetPwm(pin, 0, uint16(value*40.95))
 	}
+	on, off := dutyCycle(applyCurve(p.config.Curve, value), c.offset)
+	if err := p.chips[c.channel/16].SetPwm(c.channel%16, on, off); err != nil {
+		return p.errs.Record(err)
+	}
+	c.v = value
+	return nil
 }
 
-func (p *pca9685Driver) Pins(cap hal.Capability) ([]hal.Pin, error) {
-	switch cap {
-	case hal.DigitalOutput, hal.PWM:
-		var pins []hal.Pin
-		for _, pin := range p.channels {
-			pins = append(pins, pin)
+// setPulse drives the channel with a pulse of the given width in
+// microseconds, recording value as its position within the servo range
+func (p *pca9685Driver) setPulse(c *pca9685Channel, us, value float64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	chip := p.chips[c.channel/16]
+	ticks := chip.PulseTicks(us)
+	if err := chip.SetPwm(c.channel%16, c.offset, (c.offset+ticks)%pwmControlPoints); err != nil {
+		return p.errs.Record(err)
+	}
+	c.v = value
+	return nil
+}
+
+// dutyCycle converts a 0-100 value to on and off tick counts, using the full
+// 12 bit resolution and shifting the on time by offset ticks
+func dutyCycle(value float64, offset uint16) (uint16, uint16) {
+	ticks := uint16(math.Round(value * (pwmControlPoints - 1) / 100))
+	switch ticks {
+	case 0:
+		return 0, pwmControlPoints
+	case pwmControlPoints - 1:
+		return pwmControlPoints, 0
+	}
+	return offset, (offset + ticks) % pwmControlPoints
+}
+
+// dutyTicks is the inverse of dutyCycle, it returns the number of ticks a
+// channel is on per period
+func dutyTicks(on, off uint16) uint16 {
+	switch {
+	case on&pwmControlPoints != 0:
+		return pwmControlPoints - 1
+	case off&pwmControlPoints != 0:
+		return 0
+	}
+	return (off - on) % pwmControlPoints
+}
+
+func applyCurve(curve []float64, value float64) float64 {
+	if len(curve) < 2 {
+		return value
+	}
+	pos := value / 100 * float64(len(curve)-1)
+	i := int(pos)
+	if i >= len(curve)-1 {
+		return curve[len(curve)-1]
+	}
+	frac := pos - float64(i)
+	return curve[i] + (curve[i+1]-curve[i])*frac
+}
+
+// invertCurve maps an output duty back to its input value. Curves that are
+// not monotonically increasing cannot be inverted and are ignored
+func invertCurve(curve []float64, value float64) float64 {
+	if len(curve) < 2 {
+		return value
+	}
+	for i := 1; i < len(curve); i++ {
+		if curve[i] < curve[i-1] {
+			return value
 		}
-		return pins, nil
-	default:
-		return nil, fmt.Errorf("unsupported capability: %s", cap.String())
 	}
+	if value <= curve[0] {
+		return 0
+	}
+	step := 100 / float64(len(curve)-1)
+	for i := 0; i < len(curve)-1; i++ {
+		if value > curve[i+1] {
+			continue
+		}
+		if curve[i+1] == curve[i] {
+			return float64(i) * step
+		}
+		return (float64(i) + (value-curve[i])/(curve[i+1]-curve[i])) * step
+	}
+	return 100
+}
+
+func validateCurve(curve []float64) error {
+	if len(curve) == 1 {
+		return fmt.Errorf("curve needs at least two points")
+	}
+	for i, v := range curve {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("invalid curve point %d: %f not within 0-100", i, v)
+		}
+	}
+	return nil
+}
+
+func channelOffset(config PCA9685Config, ch int) (uint16, error) {
+	if ch < len(config.Offsets) {
+		o := config.Offsets[ch]
+		if o < 0 || o >= pwmControlPoints {
+			return 0, fmt.Errorf("invalid offset %d for channel %d", o, ch)
+		}
+		return uint16(o), nil
+	}
+	if config.Stagger {
+		return uint16(ch % 16 * pwmControlPoints / 16), nil
+	}
+	return 0, nil
+}
+
+func (p *pca9685Driver) Pins(cap hal.Capability) ([]hal.Pin, error) {
+	return drivers.Pins(p, cap)
 }
@@ -1,10 +1,14 @@
 package pca9685
 
 import (
+	"context"
+	"math"
 	"testing"
 
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers/sim"
 )
 
 var conf = []byte(`{"address":66, "frequency":200}`)
@@ -47,6 +51,25 @@ func TestHALAdapter(t *testing.T) {
 	}
 }
 
+func TestConfigSchema(t *testing.T) {
+	d, err := HALAdapter([]byte(`{"address":"0x41"}`), i2c.MockBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := d.(*pca9685Driver)
+	if p.chips[0].addr != 0x41 || p.config.Frequency != 1500 {
+		t.Error("Unexpected address or frequency:", p.chips[0].addr, p.config.Frequency)
+	}
+	if _, err := HALAdapter([]byte(`{"frequency":3000, "external_clock":50000000}`), i2c.MockBus()); err != nil {
+		t.Error("External clocks should allow higher frequencies:", err)
+	}
+	for _, c := range []string{`{"frequency":0}`, `{"frequency":23}`, `{"frequency":1527}`, `{"address":"0x80"}`, `{"close":"open"}`, `{"servos":[{"min_pulse":500}]}`} {
+		if _, err := HALAdapter([]byte(c), i2c.MockBus()); err == nil {
+			t.Error("Invalid config should be rejected:", c)
+		}
+	}
+}
+
 func TestPca9685Channel_Set(t *testing.T) {
 	driver, err := HALAdapter(conf, i2c.MockBus())
 	if err != nil {
@@ -76,13 +99,198 @@ func TestPca9685Channel_Set(t *testing.T) {
 }
 
 func TestPca9685Driver_Close(t *testing.T) {
-	driver, err := HALAdapter(conf, i2c.MockBus())
+	tank := sim.NewTank()
+	driver, err := HALAdapter([]byte(`{"address":64, "frequency":200}`), tank)
 	if err != nil {
 		t.Errorf("unexpected error making driver %v", err)
 	}
+	ch, _ := driver.(hal.PWMDriver).PWMChannel(3)
+	if err := ch.Set(50); err != nil {
+		t.Error(err)
+	}
 
 	err = driver.Close()
 	if err != nil {
 		t.Errorf("unexpected error closing driver %v", err)
 	}
+	if on, off := tank.PCA9685.Channel(3); on != 0 || off != 0x1000 {
+		t.Errorf("Expected channel 3 full off after close, found on: 0x%04x off: 0x%04x", on, off)
+	}
+	if tank.PCA9685.Awake() {
+		t.Error("Expected the chip to sleep after close")
+	}
+}
+
+func TestDutyCycle(t *testing.T) {
+	cases := []struct {
+		value   float64
+		offset  uint16
+		on, off uint16
+	}{
+		{0, 0, 0, 4096},
+		{100, 0, 4096, 0},
+		{50, 0, 0, 2048},
+		{0.01, 0, 0, 4096},
+		{0.05, 0, 0, 2},
+		{50, 3072, 3072, 1024},
+		{0, 256, 0, 4096},
+	}
+	for _, c := range cases {
+		on, off := dutyCycle(c.value, c.offset)
+		if on != c.on || off != c.off {
+			t.Errorf("value %f offset %d: expected %d/%d, got %d/%d", c.value, c.offset, c.on, c.off, on, off)
+		}
+	}
+}
+
+func TestChannelOffsets(t *testing.T) {
+	driver, err := HALAdapter([]byte(`{"address":64, "frequency":200, "stagger":true, "offsets":[100]}`), i2c.MockBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := driver.(*pca9685Driver)
+	if o := p.channels[0].offset; o != 100 {
+		t.Error("Expected configured offset 100, found:", o)
+	}
+	if o := p.channels[2].offset; o != 512 {
+		t.Error("Expected staggered offset 512, found:", o)
+	}
+	if _, err := HALAdapter([]byte(`{"address":64, "offsets":[4096]}`), i2c.MockBus()); err == nil {
+		t.Error("Offsets beyond 4095 should fail")
+	}
+}
+
+func TestCurve(t *testing.T) {
+	curve := []float64{0, 10, 100}
+	if v := applyCurve(curve, 25); v != 5 {
+		t.Error("Expected 5, found:", v)
+	}
+	if v := applyCurve(curve, 100); v != 100 {
+		t.Error("Expected 100, found:", v)
+	}
+	if v := applyCurve(nil, 42); v != 42 {
+		t.Error("Expected 42, found:", v)
+	}
+	if _, err := HALAdapter([]byte(`{"address":64, "curve":[0, 120]}`), i2c.MockBus()); err == nil {
+		t.Error("Curve points above 100 should fail")
+	}
+}
+
+func TestMultiChip(t *testing.T) {
+	conf := []byte(`{"addresses":[64, 65], "frequency":200, "external_clock":50000000, "open_drain":true, "invert":true, "output_ne":1}`)
+	driver, err := HALAdapter(conf, i2c.MockBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwmDriver := driver.(hal.PWMDriver)
+	if l := len(pwmDriver.PWMChannels()); l != 32 {
+		t.Errorf("expected 32 channels, got %d", l)
+	}
+	ch, err := pwmDriver.PWMChannel(31)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Set(20); err != nil {
+		t.Error(err)
+	}
+	p := driver.(*pca9685Driver)
+	if p.chips[1].addr != 65 {
+		t.Error("Expected second chip at address 65, found:", p.chips[1].addr)
+	}
+	if m := p.chips[0].Mode2; m != 0x11 {
+		t.Errorf("Expected mode2 0x11, found: 0x%x", m)
+	}
+	if v := p.chips[0].PreScale(); v != 60 {
+		t.Error("Expected prescale 60 with 50MHz external clock, found:", v)
+	}
+	if err := driver.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := HALAdapter([]byte(`{"address":64, "output_ne":3}`), i2c.MockBus()); err == nil {
+		t.Error("output_ne 3 should fail")
+	}
+}
+
+// simChip attaches a PCA9685 simulator at 0x40 to a bus
+func simChip() (*sim.PCA9685, *sim.Bus) {
+	chip := sim.NewPCA9685()
+	bus := sim.NewBus()
+	bus.Attach(0x40, chip)
+	return chip, bus
+}
+
+func TestReadback(t *testing.T) {
+	chip, bus := simChip()
+	// channel 0 at 50%, channel 1 full on, channel 2 full off
+	chip.Write([]byte{0x09, 0x08})
+	chip.Write([]byte{0x0B, 0x10})
+	chip.Write([]byte{0x0D, 0x00})
+	driver, err := HALAdapter([]byte(`{"address":64, "frequency":200, "close":"hold"}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := driver.(*pca9685Driver)
+	if v := p.channels[0].Value(); v < 49.9 || v > 50.1 {
+		t.Error("Expected channel 0 at 50, found:", v)
+	}
+	if !p.channels[1].LastState() {
+		t.Error("Expected channel 1 to be on")
+	}
+	if v := p.channels[2].Value(); v != 0 {
+		t.Error("Expected channel 2 at 0, found:", v)
+	}
+	if err := driver.Close(); err != nil {
+		t.Error(err)
+	}
+	if chip.Register(0x09) != 0x08 || !chip.Awake() {
+		t.Error("hold close behavior should leave outputs untouched")
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	_, bus := simChip()
+	driver, err := HALAdapter([]byte(`{"address":64, "frequency":200}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := driver.(*pca9685Driver)
+	d := p.Diagnose(context.Background())
+	if !d.Reachable || d.Hardware != "PCA9685" || d.Fields["chips"] != "0x40 awake" || d.Fields["frequency"] != "200" {
+		t.Errorf("Unexpected diagnosis: %+v", d)
+	}
+	if err := driver.Close(); err != nil {
+		t.Error(err)
+	}
+	if d := p.Diagnose(context.Background()); d.Fields["chips"] != "0x40 asleep" {
+		t.Error("Expected the chip to sleep after close:", d.Fields)
+	}
+}
+
+func TestCloseProfile(t *testing.T) {
+	chip, bus := simChip()
+	driver, err := HALAdapter([]byte(`{"address":64, "close":"profile", "profile":[100, 0]}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Close(); err != nil {
+		t.Error(err)
+	}
+	if d, _ := chip.Duty(0); d != 100 {
+		t.Error("Expected channel 0 to be full on after close, found:", d)
+	}
+	if d, _ := chip.Duty(1); d != 0 {
+		t.Error("Expected channel 1 to be full off after close, found:", d)
+	}
+	if _, err := HALAdapter([]byte(`{"address":64, "close":"unknown"}`), bus); err == nil {
+		t.Error("Unknown close behavior should fail")
+	}
+}
+
+func TestInvertCurve(t *testing.T) {
+	curve := []float64{0, 10, 100}
+	for _, v := range []float64{0, 25, 50, 80, 100} {
+		if r := invertCurve(curve, applyCurve(curve, v)); math.Abs(r-v) > 1e-9 {
+			t.Errorf("Expected %f, found: %f", v, r)
+		}
+	}
 }
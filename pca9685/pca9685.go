@@ -12,28 +12,49 @@ const (
 	clockFreq        = 25000000
 	pwmControlPoints = 4096
 	mode1RegAddr     = 0x00
+	mode2RegAddr     = 0x01
 	preScaleRegAddr  = 0xFE
 	pwm0OnLowReg     = 0x6
+	allLEDOnLowReg   = 0xFA
 	defaultFreq      = 490
+
+	mode1Sleep    = 0x10
+	mode1ExtClk   = 0x40
+	mode2Invert   = 0x10
+	mode2TotemPol = 0x04
 )
 
 type PCA9685 struct {
 	addr byte
 	bus  i2c.Bus
 	Freq int
+	// ExtClock is the frequency (in Hz) of a clock fed to the EXTCLK pin.
+	// When zero the internal 25MHz oscillator is used
+	ExtClock int
+	// Mode2 is written to the MODE2 register on wake (INVRT, OUTDRV, OUTNE)
+	Mode2 byte
 }
 
 func New(addr byte, bus i2c.Bus) *PCA9685 {
 	return &PCA9685{
-		addr: addr,
-		bus:  bus,
-		Freq: defaultFreq,
+		addr:  addr,
+		bus:   bus,
+		Freq:  defaultFreq,
+		Mode2: mode2TotemPol,
+	}
+}
+
+func (p *PCA9685) clock() int {
+	if p.ExtClock > 0 {
+		return p.ExtClock
 	}
+	return clockFreq
 }
 
 func (p *PCA9685) mode1Reg() (byte, error) {
 	mode1Reg := make([]byte, 1)
-	return mode1Reg[0], p.bus.WriteToReg(p.addr, mode1RegAddr, mode1Reg)
+	err := p.bus.ReadFromReg(p.addr, mode1RegAddr, mode1Reg)
+	return mode1Reg[0], err
 }
 
 // Set the sleep flag on the PCA. This will shut down the oscillators.
@@ -58,8 +79,18 @@ func (p *PCA9685) Wake() error {
 	if p.Freq == 0 {
 		p.Freq = defaultFreq
 	}
-	preScaleValue := byte(math.Floor(float64(clockFreq/(pwmControlPoints*p.Freq))+float64(0.5)) - 1)
-	if err := p.bus.WriteToReg(p.addr, preScaleRegAddr, []byte{preScaleValue}); err != nil {
+	if err := p.bus.WriteToReg(p.addr, preScaleRegAddr, []byte{p.PreScale()}); err != nil {
+		return err
+	}
+	if p.ExtClock > 0 {
+		// EXTCLK can only be set while the oscillator is asleep and is
+		// sticky until the next power cycle
+		mode1Reg |= mode1ExtClk
+		if err := p.bus.WriteToReg(p.addr, mode1RegAddr, []byte{(mode1Reg & 0x7F) | 0x10}); err != nil {
+			return err
+		}
+	}
+	if err := p.bus.WriteToReg(p.addr, mode2RegAddr, []byte{p.Mode2}); err != nil {
 		return err
 	}
 	wakeMode := mode1Reg & 0xEF
@@ -75,10 +106,46 @@ func (p *PCA9685) Wake() error {
 		return err
 	}
 
-	newmode := ((mode1Reg | 0x01) & 0xDF)
+	// keep the oscillator running, a chip woken from its power-on state
+	// (MODE1 0x11) would otherwise be put back to sleep
+	newmode := ((wakeMode | 0x01) & 0xDF)
 	return p.bus.WriteToReg(p.addr, mode1RegAddr, []byte{newmode})
 }
 
+// frequencyRange returns the PWM frequencies (in Hz) a clock can produce,
+// the prescaler is limited to 3-255
+func frequencyRange(clock int) (int, int) {
+	c := float64(clock) / pwmControlPoints
+	return int(math.Ceil(c / 256)), int(math.Round(c / 4))
+}
+
+// PreScale returns the prescaler register value for the configured frequency
+func (p *PCA9685) PreScale() byte {
+	freq := p.Freq
+	if freq == 0 {
+		freq = defaultFreq
+	}
+	return byte(math.Floor(float64(p.clock()/(pwmControlPoints*freq))+float64(0.5)) - 1)
+}
+
+// PulseTicks returns the number of counter ticks spanned by a pulse of the
+// given width (in microseconds), based on the actual prescaler value
+func (p *PCA9685) PulseTicks(us float64) uint16 {
+	tick := float64(int(p.PreScale())+1) / float64(p.clock()) * 1e6
+	ticks := math.Round(us / tick)
+	if ticks >= pwmControlPoints {
+		return pwmControlPoints - 1
+	}
+	return uint16(ticks)
+}
+
+// PulseWidth is the inverse of PulseTicks, it returns the width (in
+// microseconds) of a pulse spanning the given number of ticks
+func (p *PCA9685) PulseWidth(ticks uint16) float64 {
+	tick := float64(int(p.PreScale())+1) / float64(p.clock()) * 1e6
+	return float64(ticks) * tick
+}
+
 func (p *PCA9685) SetPwm(channel int, onTime, offTime uint16) error {
 	log.Println("onTime ", onTime, " offTime ", offTime)
 	// Split the ints into 4 bytes
@@ -101,10 +168,26 @@ func (p *PCA9685) SetPwm(channel int, onTime, offTime uint16) error {
 	return p.bus.WriteToReg(p.addr, timeReg+3, []byte{offTimeHigh})
 }
 
+// GetPwm reads back the on and off times of a channel from the LEDn registers
+func (p *PCA9685) GetPwm(channel int) (uint16, uint16, error) {
+	timeReg := byte(pwm0OnLowReg + (4 * channel))
+	regs := make([]byte, 4)
+	// auto increment is disabled on wake, read one register at a time
+	for i := range regs {
+		if err := p.bus.ReadFromReg(p.addr, timeReg+byte(i), regs[i:i+1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	onTime := uint16(regs[0]) | uint16(regs[1])<<8
+	offTime := uint16(regs[2]) | uint16(regs[3])<<8
+	return onTime, offTime, nil
+}
+
 func (p *PCA9685) Close() error {
-	// Clear all channels to full off
-	for regAddr := 0x06; regAddr < 0x50; regAddr += 4 {
-		if err := p.bus.WriteToReg(p.addr, byte(regAddr), []byte{0x00, 0x00, 0x00, 0x10}); err != nil {
+	// Clear all channels to full off through the ALL_LED registers, one
+	// register at a time as auto increment is disabled on wake
+	for i, v := range []byte{0x00, 0x00, 0x00, 0x10} {
+		if err := p.bus.WriteToReg(p.addr, allLEDOnLowReg+byte(i), []byte{v}); err != nil {
 			return err
 		}
 	}
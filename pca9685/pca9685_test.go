@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers/i2ctest"
 )
 
 func TestNew(t *testing.T) {
@@ -14,3 +16,91 @@ func TestNew(t *testing.T) {
 	}
 	p.SetPwm(10, 0, 10)
 }
+
+func TestProtocol(t *testing.T) {
+	bus := i2ctest.NewScript(
+		// wake at 1500Hz from an awake chip
+		i2ctest.ReadReg(0x40, 0x00, 0x01),
+		i2ctest.ReadReg(0x40, 0x00, 0x01),
+		i2ctest.WriteReg(0x40, 0x00, 0x11),
+		i2ctest.WriteReg(0x40, 0xFE, 0x03),
+		i2ctest.WriteReg(0x40, 0x01, 0x04),
+		i2ctest.WriteReg(0x40, 0x00, 0x81),
+		i2ctest.WriteReg(0x40, 0x00, 0x01),
+		// channel 1 on at 256, off at 2304
+		i2ctest.WriteReg(0x40, 0x0A, 0x00),
+		i2ctest.WriteReg(0x40, 0x0B, 0x01),
+		i2ctest.WriteReg(0x40, 0x0C, 0x00),
+		i2ctest.WriteReg(0x40, 0x0D, 0x09),
+		i2ctest.ReadReg(0x40, 0x0A, 0x00),
+		i2ctest.ReadReg(0x40, 0x0B, 0x01),
+		i2ctest.ReadReg(0x40, 0x0C, 0x00),
+		i2ctest.ReadReg(0x40, 0x0D, 0x09),
+	)
+	p := New(0x40, bus)
+	p.Freq = 1500
+	if err := p.Wake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetPwm(1, 256, 2304); err != nil {
+		t.Fatal(err)
+	}
+	if on, off, err := p.GetPwm(1); err != nil || on != 256 || off != 2304 {
+		t.Error("Unexpected readback:", on, off, err)
+	}
+	if err := bus.Done(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWakeFromPowerOn(t *testing.T) {
+	bus := i2ctest.NewScript(
+		// MODE1 comes up as 0x11, asleep with ALLCALL
+		i2ctest.ReadReg(0x40, 0x00, 0x11),
+		i2ctest.ReadReg(0x40, 0x00, 0x11),
+		i2ctest.WriteReg(0x40, 0x00, 0x11),
+		i2ctest.WriteReg(0x40, 0xFE, 0x03),
+		i2ctest.WriteReg(0x40, 0x01, 0x04),
+		i2ctest.WriteReg(0x40, 0x00, 0x81),
+		// the oscillator stays on
+		i2ctest.WriteReg(0x40, 0x00, 0x01),
+	)
+	p := New(0x40, bus)
+	p.Freq = 1500
+	if err := p.Wake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Done(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	chip, bus := simChip()
+	p := New(0x40, bus)
+	p.Freq = 1500
+	if err := p.Wake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetPwm(3, 0, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Sleep(); err != nil {
+		t.Fatal(err)
+	}
+	for ch := 0; ch < 16; ch++ {
+		if on, off := chip.Channel(ch); on != 0 || off != 0x1000 {
+			t.Errorf("Expected channel %d full off, found on: 0x%04x off: 0x%04x", ch, on, off)
+		}
+	}
+	// the next wake restarts with the channels off
+	if err := p.Wake(); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := chip.Duty(3); err != nil || d != 0 {
+		t.Error("Expected channel 3 off after waking, found:", d, err)
+	}
+}
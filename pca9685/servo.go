@@ -0,0 +1,81 @@
+package pca9685
+
+import (
+	"fmt"
+
+	"github.com/reef-pi/hal"
+)
+
+const (
+	defaultMinPulse = 1000
+	defaultMaxPulse = 2000
+	defaultMaxAngle = 180
+)
+
+// ServoConfig describes a channel driving a hobby servo. Pulse widths are
+// in microseconds, angles in degrees
+type ServoConfig struct {
+	Channel  int     `json:"channel"`
+	MinPulse float64 `json:"min_pulse"`
+	MaxPulse float64 `json:"max_pulse"`
+	MinAngle float64 `json:"min_angle"`
+	MaxAngle float64 `json:"max_angle"`
+}
+
+// Servo is implemented by channels configured in servo mode. Set maps 0-100
+// across the configured angle range
+type Servo interface {
+	hal.PWMChannel
+	SetAngle(float64) error
+	SetPulse(float64) error
+}
+
+func (s *ServoConfig) setDefaults() error {
+	if s.MinPulse == 0 && s.MaxPulse == 0 {
+		s.MinPulse = defaultMinPulse
+		s.MaxPulse = defaultMaxPulse
+	}
+	if s.MinAngle == 0 && s.MaxAngle == 0 {
+		s.MaxAngle = defaultMaxAngle
+	}
+	if s.MinPulse < 0 || s.MaxPulse <= s.MinPulse {
+		return fmt.Errorf("invalid pulse range %f-%f for servo channel %d", s.MinPulse, s.MaxPulse, s.Channel)
+	}
+	if s.MaxAngle <= s.MinAngle {
+		return fmt.Errorf("invalid angle range %f-%f for servo channel %d", s.MinAngle, s.MaxAngle, s.Channel)
+	}
+	return nil
+}
+
+// SetAngle moves the servo to the given angle within its configured range
+func (c *pca9685Channel) SetAngle(a float64) error {
+	if c.servo == nil {
+		return fmt.Errorf("channel %d is not configured as servo", c.channel)
+	}
+	if a < c.servo.MinAngle || a > c.servo.MaxAngle {
+		return fmt.Errorf("invalid angle: %f not within %f-%f", a, c.servo.MinAngle, c.servo.MaxAngle)
+	}
+	return c.setServo((a - c.servo.MinAngle) / (c.servo.MaxAngle - c.servo.MinAngle) * 100)
+}
+
+// SetPulse sets the servo pulse width in microseconds
+func (c *pca9685Channel) SetPulse(us float64) error {
+	if c.servo == nil {
+		return fmt.Errorf("channel %d is not configured as servo", c.channel)
+	}
+	if us < c.servo.MinPulse || us > c.servo.MaxPulse {
+		return fmt.Errorf("invalid pulse: %f not within %f-%f", us, c.servo.MinPulse, c.servo.MaxPulse)
+	}
+	return c.setServo((us - c.servo.MinPulse) / (c.servo.MaxPulse - c.servo.MinPulse) * 100)
+}
+
+func (c *pca9685Channel) setServo(value float64) error {
+	switch {
+	case value > 100:
+		return fmt.Errorf("invalid value: %f above 100", value)
+	case value < 0:
+		return fmt.Errorf("invalid value: %f below 0", value)
+	}
+	us := c.servo.MinPulse + (c.servo.MaxPulse-c.servo.MinPulse)*value/100
+	return c.driver.setPulse(c, us, value)
+}
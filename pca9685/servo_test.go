@@ -0,0 +1,66 @@
+package pca9685
+
+import (
+	"testing"
+
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+)
+
+func TestPulseTicks(t *testing.T) {
+	p := New(0x40, i2c.MockBus())
+	p.Freq = 50
+	if v := p.PreScale(); v != 121 {
+		t.Error("Expected prescale 121 at 50Hz, found:", v)
+	}
+	// each tick is 4.88us at prescale 121
+	if v := p.PulseTicks(1500); v != 307 {
+		t.Error("Expected 307 ticks for 1.5ms pulse, found:", v)
+	}
+	if v := p.PulseTicks(30000); v != 4095 {
+		t.Error("Expected pulses longer than a period to saturate, found:", v)
+	}
+}
+
+func TestServo(t *testing.T) {
+	conf := []byte(`{"address":64, "frequency":50, "servos":[{"channel":3, "min_pulse":500, "max_pulse":2500}]}`)
+	driver, err := HALAdapter(conf, i2c.MockBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwmDriver := driver.(hal.PWMDriver)
+	ch, err := pwmDriver.PWMChannel(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := ch.(Servo)
+	if !ok {
+		t.Fatal("Servo channel does not implement Servo")
+	}
+	if err := s.SetAngle(90); err != nil {
+		t.Error(err)
+	}
+	if err := s.SetAngle(200); err == nil {
+		t.Error("Angle beyond 180 should fail")
+	}
+	if err := s.SetPulse(2500); err != nil {
+		t.Error(err)
+	}
+	if !s.LastState() {
+		t.Error("Expected max pulse to report full on")
+	}
+	if err := s.SetPulse(3000); err == nil {
+		t.Error("Pulse beyond max pulse should fail")
+	}
+	if err := s.Set(50); err != nil {
+		t.Error(err)
+	}
+
+	plain, _ := pwmDriver.PWMChannel(4)
+	if err := plain.(Servo).SetAngle(10); err == nil {
+		t.Error("Non servo channels should reject angles")
+	}
+	if _, err := HALAdapter([]byte(`{"address":64, "servos":[{"channel":16}]}`), i2c.MockBus()); err == nil {
+		t.Error("Servo on channel 16 should fail")
+	}
+}
@@ -0,0 +1,200 @@
+// Package ph converts pH electrode potentials to pH, with separate acid and
+// base slopes and Nernst temperature compensation. It is shared by the raw
+// ADC based pH boards.
+package ph
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/reef-pi/hal"
+
+	"github.com/dmolavi/drivers/schema"
+)
+
+const (
+	neutral        = 7
+	defaultTemp    = 25
+	kelvin         = 273.15
+	nernstConstant = 0.198416 // mV/pH per kelvin, ln(10)*R/F
+)
+
+// ConfigSchema describes Config for drivers embedding pH mode
+var ConfigSchema = schema.Object(schema.Properties{
+	"temperature": schema.Number().Range(0, 100).WithDefault(defaultTemp).
+		Describe("Temperature (°C) used for compensation when no temperature pin is set"),
+	"temperature_pin": schema.Object(schema.Properties{
+		"driver": schema.String().NonEmpty().Describe("Registered driver name, e.g. w1"),
+		"config": schema.Object(nil).Describe("Config of the temperature driver"),
+		"pin":    schema.Integer().Min(0).Describe("Analog input pin measuring °C"),
+	}, "driver").Describe("Analog input of another driver used for compensation"),
+})
+
+type Config struct {
+	// Temperature (°C) used for compensation when no temperature pin is set,
+	// defaults to 25 °C when omitted
+	Temperature *float64 `json:"temperature"`
+	// TemperaturePin measures the temperature for compensation
+	TemperaturePin *TemperaturePin `json:"temperature_pin"`
+}
+
+// TemperaturePin names an analog input pin, measuring °C, of a driver built
+// through the driver registry. The driver package must be imported to be
+// registered
+type TemperaturePin struct {
+	Driver string          `json:"driver"`
+	Config json.RawMessage `json:"config"`
+	Pin    int             `json:"pin"`
+}
+
+// Diagnostics reports the state of the electrode, based on its last calibration
+type Diagnostics struct {
+	Offset         float64 `json:"offset"`          // mV at pH 7
+	AcidEfficiency float64 `json:"acid_efficiency"` // % of the ideal Nernst slope below pH 7
+	BaseEfficiency float64 `json:"base_efficiency"` // % of the ideal Nernst slope above pH 7
+	Temperature    float64 `json:"temperature"`     // °C used for the last conversion
+}
+
+type Converter struct {
+	mu          sync.Mutex
+	offset      float64
+	acid        float64 // slope efficiency, 1 is an ideal electrode
+	base        float64
+	temperature float64
+	lastTemp    float64
+	tempPin     hal.AnalogInputPin
+}
+
+func NewConverter(c Config) *Converter {
+	t := float64(defaultTemp)
+	if c.Temperature != nil {
+		t = *c.Temperature
+	}
+	return &Converter{
+		acid:        1,
+		base:        1,
+		temperature: t,
+		lastTemp:    t,
+	}
+}
+
+// Slope returns the ideal electrode slope (mV/pH) at the given temperature (°C)
+func Slope(t float64) float64 {
+	return nernstConstant * (t + kelvin)
+}
+
+// SetTemperaturePin sets the pin whose measurement (°C) is used for compensation
+func (c *Converter) SetTemperaturePin(p hal.AnalogInputPin) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tempPin = p
+}
+
+func (c *Converter) currentTemp() (float64, error) {
+	if c.tempPin == nil {
+		return c.temperature, nil
+	}
+	t, err := c.tempPin.Measure()
+	if err != nil {
+		return 0, err
+	}
+	c.lastTemp = t
+	return t, nil
+}
+
+// PH converts an electrode potential (mV) to pH
+func (c *Converter) PH(mv float64) (float64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, err := c.currentTemp()
+	if err != nil {
+		return 0, err
+	}
+	eff := c.base
+	if mv > c.offset {
+		eff = c.acid
+	}
+	return neutral + (c.offset-mv)/(eff*Slope(t)), nil
+}
+
+// Calibrate takes one to three points, with Expected pH and Observed mV.
+// A single point adjusts the offset, two points set a common slope (or
+// the slope on one side when one point is pH 7), three points set separate
+// acid and base slopes around the middle point
+func (c *Converter) Calibrate(points []hal.Measurement) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, err := c.currentTemp()
+	if err != nil {
+		return err
+	}
+	s := Slope(t)
+	ps := append([]hal.Measurement{}, points...)
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Expected < ps[j].Expected })
+	for i := 1; i < len(ps); i++ {
+		if ps[i].Expected == ps[i-1].Expected {
+			return fmt.Errorf("duplicate calibration point at pH %f", ps[i].Expected)
+		}
+	}
+	efficiency := func(a, b hal.Measurement) (float64, error) {
+		eff := (a.Observed - b.Observed) / (s * (b.Expected - a.Expected))
+		if eff <= 0 || math.IsInf(eff, 0) || math.IsNaN(eff) {
+			return 0, fmt.Errorf("invalid electrode slope between pH %f and %f", a.Expected, b.Expected)
+		}
+		return eff, nil
+	}
+	acid, base := c.acid, c.base
+	switch len(ps) {
+	case 1:
+	case 2:
+		eff, err := efficiency(ps[0], ps[1])
+		if err != nil {
+			return err
+		}
+		switch {
+		case ps[0].Expected == neutral:
+			base = eff
+		case ps[1].Expected == neutral:
+			acid = eff
+		default:
+			acid, base = eff, eff
+		}
+	case 3:
+		if acid, err = efficiency(ps[0], ps[1]); err != nil {
+			return err
+		}
+		if base, err = efficiency(ps[1], ps[2]); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Expected 1, 2 or 3 calibration points. Found: %d", len(ps))
+	}
+	// derive the pH 7 offset from the point closest to neutral
+	ref := ps[0]
+	for _, p := range ps {
+		if math.Abs(p.Expected-neutral) < math.Abs(ref.Expected-neutral) {
+			ref = p
+		}
+	}
+	eff := base
+	if ref.Expected < neutral {
+		eff = acid
+	}
+	c.offset = ref.Observed + eff*s*(ref.Expected-neutral)
+	c.acid, c.base = acid, base
+	return nil
+}
+
+func (c *Converter) Diagnostics() Diagnostics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Diagnostics{
+		Offset:         c.offset,
+		AcidEfficiency: c.acid * 100,
+		BaseEfficiency: c.base * 100,
+		Temperature:    c.lastTemp,
+	}
+}
@@ -0,0 +1,103 @@
+package ph
+
+import (
+	"math"
+	"testing"
+
+	"github.com/reef-pi/hal"
+)
+
+type tempPin struct {
+	hal.AnalogInputPin
+	t float64
+}
+
+func (p *tempPin) Measure() (float64, error) { return p.t, nil }
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+func TestSlope(t *testing.T) {
+	if s := Slope(25); math.Abs(s-59.16) > 0.01 {
+		t.Error("Expected 59.16 mV/pH at 25°C, found:", s)
+	}
+}
+
+func TestConverter(t *testing.T) {
+	c := NewConverter(Config{})
+	s := Slope(25)
+	if v, _ := c.PH(0); v != 7 {
+		t.Error("Expected pH 7 at 0mV for an uncalibrated ideal probe, found:", v)
+	}
+	if v, _ := c.PH(-s); !near(v, 8) {
+		t.Error("Expected pH 8, found:", v)
+	}
+
+	// probe with 10mV offset, 95% acid and 90% base efficiency
+	mv := func(p float64) float64 {
+		if p < 7 {
+			return 10 - 0.95*s*(p-7)
+		}
+		return 10 - 0.90*s*(p-7)
+	}
+	points := []hal.Measurement{
+		{Expected: 10, Observed: mv(10)},
+		{Expected: 4, Observed: mv(4)},
+		{Expected: 7, Observed: mv(7)},
+	}
+	if err := c.Calibrate(points); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []float64{4, 5.5, 7, 8.2, 10} {
+		if v, err := c.PH(mv(p)); err != nil || !near(v, p) {
+			t.Error("Expected pH", p, "found:", v, err)
+		}
+	}
+	d := c.Diagnostics()
+	if !near(d.Offset, 10) || !near(d.AcidEfficiency, 95) || !near(d.BaseEfficiency, 90) {
+		t.Errorf("Unexpected diagnostics: %+v", d)
+	}
+
+	// single point recalibration only moves the offset
+	if err := c.Calibrate([]hal.Measurement{{Expected: 7, Observed: 15}}); err != nil {
+		t.Fatal(err)
+	}
+	if d := c.Diagnostics(); !near(d.Offset, 15) || !near(d.BaseEfficiency, 90) {
+		t.Errorf("Unexpected diagnostics: %+v", d)
+	}
+
+	// two points not including pH 7 set a common slope
+	if err := c.Calibrate(points[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if d := c.Diagnostics(); !near(d.AcidEfficiency, d.BaseEfficiency) {
+		t.Errorf("Expected a common slope: %+v", d)
+	}
+
+	if err := c.Calibrate([]hal.Measurement{{Expected: 7, Observed: 0}, {Expected: 10, Observed: 100}}); err == nil {
+		t.Error("Inverted slopes should fail")
+	}
+	if err := c.Calibrate(append(points, points[0])); err == nil {
+		t.Error("Four points should fail")
+	}
+}
+
+func TestTemperatureCompensation(t *testing.T) {
+	zero := 0.0
+	c := NewConverter(Config{Temperature: &zero})
+	if v, _ := c.PH(-3 * Slope(0)); !near(v, 10) {
+		t.Error("Expected pH 10 at a configured 0°C, found:", v)
+	}
+	temp := &tempPin{t: 25}
+	c.SetTemperaturePin(temp)
+	mv := -3 * Slope(25)
+	if v, _ := c.PH(mv); !near(v, 10) {
+		t.Error("Expected pH 10 at 25°C, found:", v)
+	}
+	temp.t = 50
+	if v, _ := c.PH(mv); !near(v, 7+3*Slope(25)/Slope(50)) {
+		t.Error("Expected compensated reading at 50°C, found:", v)
+	}
+	if d := c.Diagnostics(); d.Temperature != 50 {
+		t.Error("Expected diagnostics temperature 50, found:", d.Temperature)
+	}
+}
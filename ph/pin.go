@@ -0,0 +1,105 @@
+package ph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers"
+)
+
+// Pin measures pH from a raw pin whose Read returns the electrode
+// potential in millivolts. Calibration points are pH (expected) against
+// millivolts (observed)
+type Pin struct {
+	hal.AnalogInputPin
+	conv *Converter
+	temp hal.Driver // built from Config.TemperaturePin
+}
+
+// NewPin wraps raw in pH mode. When c has a temperature pin, its driver is
+// built on bus and closed with the pin
+func NewPin(raw hal.AnalogInputPin, c Config, bus i2c.Bus) (*Pin, error) {
+	p := &Pin{
+		AnalogInputPin: raw,
+		conv:           NewConverter(c),
+	}
+	if t := c.TemperaturePin; t != nil {
+		d, err := drivers.Build(t.Driver, t.Config, bus)
+		if err != nil {
+			return nil, fmt.Errorf("temperature pin: %w", err)
+		}
+		in, ok := d.(hal.AnalogInputDriver)
+		if !ok {
+			d.Close()
+			return nil, fmt.Errorf("temperature pin: driver %s has no analog inputs", t.Driver)
+		}
+		tp, err := in.AnalogInputPin(t.Pin)
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("temperature pin: %w", err)
+		}
+		p.conv.SetTemperaturePin(tp)
+		p.temp = d
+	}
+	return p, nil
+}
+
+func (p *Pin) Measure() (float64, error) {
+	mv, err := p.Read()
+	if err != nil {
+		return 0, err
+	}
+	return p.conv.PH(mv)
+}
+
+// ReadContext reads the raw pin, honouring ctx when it supports cancellation
+func (p *Pin) ReadContext(ctx context.Context) (float64, error) {
+	return drivers.ReadContext(ctx, p.AnalogInputPin)
+}
+
+func (p *Pin) MeasureContext(ctx context.Context) (float64, error) {
+	mv, err := p.ReadContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return p.conv.PH(mv)
+}
+
+func (p *Pin) Calibrate(points []hal.Measurement) error {
+	return p.conv.Calibrate(points)
+}
+
+// SetTemperaturePin sets the pin whose measurement (°C) is used for
+// compensation, replacing the configured one. For callers managing the
+// temperature sensor themselves
+func (p *Pin) SetTemperaturePin(t hal.AnalogInputPin) {
+	p.conv.SetTemperaturePin(t)
+}
+
+// Diagnostics reports electrode offset and slope efficiency
+func (p *Pin) Diagnostics() Diagnostics {
+	return p.conv.Diagnostics()
+}
+
+// Report adds the electrode offset, slope efficiency and temperature to a
+// driver diagnosis
+func (p *Pin) Report(d *drivers.Diagnosis) {
+	e := p.conv.Diagnostics()
+	d.Set("ph_offset_mv", e.Offset)
+	d.Set("ph_acid_efficiency", e.AcidEfficiency)
+	d.Set("ph_base_efficiency", e.BaseEfficiency)
+	d.Set("ph_temperature", e.Temperature)
+}
+
+// Close closes the temperature pin driver and the raw pin
+func (p *Pin) Close() error {
+	if p.temp != nil {
+		if err := p.temp.Close(); err != nil {
+			return err
+		}
+	}
+	return p.AnalogInputPin.Close()
+}
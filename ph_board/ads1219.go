@@ -0,0 +1,265 @@
+package ph_board
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers"
+)
+
+/*
+http://www.ti.com/lit/ds/symlink/ads1219.pdf
+*/
+
+const (
+	cmdReset     = 0x06
+	cmdStart     = 0x08
+	cmdPowerDown = 0x02
+	cmdRData     = 0x10
+	cmdRRegCfg   = 0x20
+	cmdRRegSts   = 0x24
+	cmdWReg      = 0x40
+
+	statusDRDY   = 0x80
+	cfgGain4     = 0x10
+	cfgContinous = 0x02
+	cfgVRefExt   = 0x01
+
+	internalVRef = 2.048
+	drdyRetries  = 10
+
+	// deadline of the plain Read and Measure
+	_timeout = time.Second
+)
+
+var muxes = map[string]byte{
+	"AIN0-AIN1": 0x00,
+	"AIN2-AIN3": 0x20,
+	"AIN1-AIN2": 0x40,
+	"AIN0":      0x60,
+	"AIN1":      0x80,
+	"AIN2":      0xA0,
+	"AIN3":      0xC0,
+	"SHORT":     0xE0, // both inputs shorted to AVDD/2, for offset calibration
+}
+
+var dataRates = map[int]byte{
+	20:   0x00,
+	90:   0x04,
+	330:  0x08,
+	1000: 0x0C,
+}
+
+// ADS1219 is a 24 bit, 4 channel delta-sigma analog to digital converter
+type ADS1219 struct {
+	mu     *sync.Mutex // held across a conversion
+	errs   drivers.ErrorLog
+	addr   byte
+	bus    i2c.Bus
+	config byte
+	gain   float64
+	vref   float64
+	delay  time.Duration
+}
+
+func NewADS1219(bus i2c.Bus, config Config) (*ADS1219, error) {
+	mux := config.Mux
+	if mux == "" {
+		mux = "AIN0-AIN1"
+	}
+	m, ok := muxes[mux]
+	if !ok {
+		return nil, fmt.Errorf("unsupported mux: %s", mux)
+	}
+	rate := config.DataRate
+	if rate == 0 {
+		rate = 90
+	}
+	dr, ok := dataRates[rate]
+	if !ok {
+		return nil, fmt.Errorf("unsupported data rate: %d", rate)
+	}
+	a := &ADS1219{
+		mu:     drivers.DeviceLock(bus, config.Address),
+		addr:   config.Address,
+		bus:    bus,
+		config: m | dr,
+		gain:   1,
+		vref:   internalVRef,
+		delay:  time.Second / time.Duration(rate),
+	}
+	switch config.Gain {
+	case 0, 1:
+	case 4:
+		a.config |= cfgGain4
+		a.gain = 4
+	default:
+		return nil, fmt.Errorf("unsupported gain: %d", config.Gain)
+	}
+	if !config.SingleShot {
+		a.config |= cfgContinous
+	}
+	if config.VRef < 0 {
+		return nil, fmt.Errorf("invalid reference voltage: %f", config.VRef)
+	}
+	if config.VRef > 0 {
+		a.config |= cfgVRefExt
+		a.vref = config.VRef
+	}
+	return a, nil
+}
+
+// Setup resets the chip, writes the configuration register and, in
+// continuous mode, starts conversions
+func (a *ADS1219) Setup() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.bus.WriteBytes(a.addr, []byte{cmdReset}); err != nil {
+		return a.errs.Record(err)
+	}
+	if err := a.bus.WriteBytes(a.addr, []byte{cmdWReg, a.config}); err != nil {
+		return a.errs.Record(err)
+	}
+	if a.config&cfgContinous == 0 {
+		return nil
+	}
+	return a.errs.Record(a.bus.WriteBytes(a.addr, []byte{cmdStart}))
+}
+
+func (a *ADS1219) PowerDown() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.errs.Record(a.bus.WriteBytes(a.addr, []byte{cmdPowerDown}))
+}
+
+func (a *ADS1219) readReg(cmd byte) (byte, error) {
+	buf, err := a.query(cmd, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) != 1 {
+		return 0, a.errs.Record(drivers.Transient(fmt.Errorf("unexpected register length: %d", len(buf))))
+	}
+	return buf[0], nil
+}
+
+// query sends cmd and reads n bytes of its response, without another
+// transfer on the bus in between
+func (a *ADS1219) query(cmd byte, n int) ([]byte, error) {
+	var buf []byte
+	err := drivers.Transaction(a.bus, func() error {
+		if err := a.bus.WriteBytes(a.addr, []byte{cmd}); err != nil {
+			return err
+		}
+		var err error
+		buf, err = a.bus.ReadBytes(a.addr, n)
+		return err
+	})
+	return buf, a.errs.Record(err)
+}
+
+// Ready reports whether a new conversion result is available
+func (a *ADS1219) Ready() (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.ready()
+}
+
+func (a *ADS1219) ready() (bool, error) {
+	s, err := a.readReg(cmdRRegSts)
+	if err != nil {
+		return false, err
+	}
+	return s&statusDRDY != 0, nil
+}
+
+// waitReady polls DRDY for one conversion period plus 10% for oscillator
+// tolerance, so a read right after the previous one in continuous mode
+// waits for a whole conversion
+func (a *ADS1219) waitReady(ctx context.Context) error {
+	interval := a.delay * 11 / 10 / drdyRetries
+	for i := 0; ; i++ {
+		ok, err := a.ready()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if i == drdyRetries {
+			break
+		}
+		if err := drivers.Sleep(ctx, interval); err != nil {
+			return err
+		}
+	}
+	return a.errs.Record(drivers.Transient(fmt.Errorf("conversion not ready")))
+}
+
+// Read returns the signed 24 bit conversion result
+func (a *ADS1219) Read() (int32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+	defer cancel()
+	return a.ReadContext(ctx)
+}
+
+// ReadContext is Read, waiting for the conversion end until ctx is done
+func (a *ADS1219) ReadContext(ctx context.Context) (int32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.config&cfgContinous == 0 {
+		if err := a.bus.WriteBytes(a.addr, []byte{cmdStart}); err != nil {
+			return 0, a.errs.Record(err)
+		}
+		if err := drivers.Sleep(ctx, a.delay); err != nil {
+			return 0, err
+		}
+	}
+	if err := a.waitReady(ctx); err != nil {
+		return 0, err
+	}
+	buf, err := a.query(cmdRData, 3)
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) != 3 {
+		return 0, a.errs.Record(drivers.Transient(fmt.Errorf("unexpected conversion length: %d", len(buf))))
+	}
+	// sign extend the 24 bit two's complement value
+	return int32(uint32(buf[0])<<24|uint32(buf[1])<<16|uint32(buf[2])<<8) >> 8, nil
+}
+
+// Volts converts a conversion result to volts, using the configured
+// reference and gain
+func (a *ADS1219) Volts(code int32) float64 {
+	return float64(code) / math.Exp2(23) * a.vref / a.gain
+}
+
+// Diagnose reads back the configuration register. A chip that lost its
+// configuration, e.g. after a brown out, reports the register it holds
+func (a *ADS1219) Diagnose(ctx context.Context) drivers.Diagnosis {
+	err := ctx.Err()
+	var cfg byte
+	if err == nil {
+		a.mu.Lock()
+		cfg, err = a.readReg(cmdRRegCfg)
+		a.mu.Unlock()
+	}
+	d := a.errs.Diagnosis(err)
+	d.Hardware = "ADS1219"
+	d.Set("address", fmt.Sprintf("0x%02x", a.addr))
+	d.Set("gain", a.gain)
+	d.Set("vref", a.vref)
+	if err == nil && cfg != a.config {
+		d.Set("config", fmt.Sprintf("0x%02x, expected 0x%02x", cfg, a.config))
+	}
+	return d
+}
@@ -1,29 +1,30 @@
 package ph_board
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"sync"
 
 	"github.com/reef-pi/hal"
-	"github.com/reef-pi/rpi/i2c"
 )
 
 const chName = "0"
 
 type channel struct {
-	bus        i2c.Bus
-	addr       byte
+	adc        *ADS1219
+	millivolts bool
+	mu         sync.Mutex // guards calibrator
 	calibrator hal.Calibrator
 }
 
-func NewChannel(b i2c.Bus, addr byte) (*channel, error) {
+func NewChannel(adc *ADS1219) (*channel, error) {
 	c, err := hal.CalibratorFactory([]hal.Measurement{})
 	if err != nil {
 		return nil, err
 	}
 	return &channel{
-		bus:        b,
-		addr:       addr,
+		adc:        adc,
 		calibrator: c,
 	}, nil
 }
@@ -40,31 +41,57 @@ func (c *channel) Calibrate(points []hal.Measurement) error {
 	if err != nil {
 		return err
 	}
+	c.mu.Lock()
 	c.calibrator = cal
+	c.mu.Unlock()
 	return nil
 }
 
+// Read returns the raw 24 bit conversion result, or millivolts in pH mode
 func (c *channel) Read() (float64, error) {
-	if err := c.bus.WriteBytes(c.addr, []byte{0x10}); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+	defer cancel()
+	return c.ReadContext(ctx)
+}
+
+func (c *channel) ReadContext(ctx context.Context) (float64, error) {
+	v, err := c.adc.ReadContext(ctx)
+	if err != nil {
 		return math.NaN(), err
 	}
-	buf, err := c.bus.ReadBytes(c.addr, 2)
+	if c.millivolts {
+		return c.adc.Volts(v) * 1000, nil
+	}
+	return float64(v), nil
+}
+
+// Volts returns the input voltage, based on the configured reference and gain
+func (c *channel) Volts() (float64, error) {
+	v, err := c.adc.Read()
 	if err != nil {
 		return math.NaN(), err
 	}
-	v := int16(buf[0])<<8 | int16(buf[1])
-	return float64(v), nil
+	return c.adc.Volts(v), nil
 }
 
 func (c *channel) Measure() (float64, error) {
-	v, err := c.Read()
+	ctx, cancel := context.WithTimeout(context.Background(), _timeout)
+	defer cancel()
+	return c.MeasureContext(ctx)
+}
+
+func (c *channel) MeasureContext(ctx context.Context) (float64, error) {
+	v, err := c.ReadContext(ctx)
 	if err != nil {
 		return 0, err
 	}
-	if c.calibrator == nil {
+	c.mu.Lock()
+	cal := c.calibrator
+	c.mu.Unlock()
+	if cal == nil {
 		return 0, fmt.Errorf("Not calibrated")
 	}
-	return c.calibrator.Calibrate(v), nil
+	return cal.Calibrate(v), nil
 }
 
 func (c *channel) Close() error {
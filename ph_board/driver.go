@@ -1,21 +1,59 @@
+// Package ph_board drives pH boards built around the TI ADS1219, a 24 bit
+// I2C analog to digital converter. The ADS1220 shares its command set but
+// only has an SPI interface, it is out of scope for this I2C driver.
 package ph_board
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/ph"
+	"github.com/dmolavi/drivers/schema"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
 
+var driverMeta = hal.Metadata{
+	Name:         "ph-board",
+	Description:  "An ADS1219 based analog to digital converter with onboard female BNC connector",
+	Capabilities: []hal.Capability{hal.AnalogInput},
+}
+
+var configSchema = schema.Object(schema.Properties{
+	"address":   schema.Address(),
+	"gain":      schema.Integer().OneOf(1, 4).WithDefault(1),
+	"data_rate": schema.Integer().OneOf(20, 90, 330, 1000).WithDefault(90).Describe("Samples per second"),
+	"vref": schema.Number().Min(0).
+		Describe("External reference in volts, 0 selects the internal 2.048V reference"),
+	"mux": schema.String().OneOf("AIN0-AIN1", "AIN2-AIN3", "AIN1-AIN2", "AIN0", "AIN1", "AIN2", "AIN3", "SHORT").
+		WithDefault("AIN0-AIN1"),
+	"single_shot": schema.Boolean().Describe("Single-shot instead of continuous conversion"),
+	"ph":          ph.ConfigSchema,
+}, "address")
+
+func init() {
+	drivers.Register(driverMeta, configSchema, HalAdapter)
+}
+
 const driverName = "ph-board"
 
 type Config struct {
-	Address byte `json:"address"`
+	Address    byte    `json:"address"`
+	Gain       int     `json:"gain"`        // 1 (default) or 4
+	DataRate   int     `json:"data_rate"`   // 20, 90 (default), 330 or 1000 samples per second
+	VRef       float64 `json:"vref"`        // external reference in volts, 0 selects the internal 2.048V reference
+	Mux        string  `json:"mux"`         // input, defaults to AIN0-AIN1
+	SingleShot bool    `json:"single_shot"` // single-shot instead of continuous conversion
+	// PH enables pH mode, where pins read millivolts and measure pH
+	PH *ph.Config `json:"ph"`
 }
 
 type driver struct {
+	adc      *ADS1219
 	channels []hal.AnalogInputPin
+	ph       *ph.Pin // nil unless in pH mode
 	meta     hal.Metadata
 }
 
@@ -23,41 +61,45 @@ func HalAdapter(c []byte, bus i2c.Bus) (hal.Driver, error) {
 	return NewDriver(c, bus)
 }
 func NewDriver(c []byte, bus i2c.Bus) (hal.AnalogInputDriver, error) {
-	var config Config
-	if err := json.Unmarshal(c, &config); err != nil {
+	c, err := configSchema.Apply(c)
+	if err != nil {
 		return nil, err
 	}
-	if err := bus.WriteBytes(config.Address, []byte{0x06}); err != nil {
+	var config Config
+	if err := json.Unmarshal(c, &config); err != nil {
 		return nil, err
 	}
-	if err := bus.WriteBytes(config.Address, []byte{0x40, 0x06}); err != nil {
+	adc, err := NewADS1219(bus, config)
+	if err != nil {
 		return nil, err
 	}
-	if err := bus.WriteBytes(config.Address, []byte{0x08}); err != nil {
+	if err := adc.Setup(); err != nil {
 		return nil, err
 	}
 
-	ch, err := NewChannel(bus, config.Address)
+	ch, err := NewChannel(adc)
 	if err != nil {
 		return nil, err
 	}
-	return &driver{
+	d := &driver{
+		adc:      adc,
 		channels: []hal.AnalogInputPin{ch},
-		meta: hal.Metadata{
-			Name:         "ph-board",
-			Description:  "An ADS115 based analog to digital converted with onboard female BNC connector",
-			Capabilities: []hal.Capability{hal.AnalogInput},
-		},
-	}, nil
+		meta:     driverMeta,
+	}
+	if config.PH != nil {
+		ch.millivolts = true
+		if d.ph, err = ph.NewPin(ch, *config.PH, bus); err != nil {
+			return nil, err
+		}
+		d.channels[0] = d.ph
+	}
+	return d, nil
 }
 func (d *driver) Metadata() hal.Metadata {
 	return d.meta
 }
 func (d *driver) Pins(cap hal.Capability) ([]hal.Pin, error) {
-	if cap == hal.AnalogInput {
-		return []hal.Pin{d.channels[0]}, nil
-	}
-	return nil, fmt.Errorf("unsupported capability: %s", cap.String())
+	return drivers.Pins(d, cap)
 }
 
 func (d *driver) AnalogInputPins() []hal.AnalogInputPin {
@@ -72,5 +114,15 @@ func (d *driver) AnalogInputPin(n int) (hal.AnalogInputPin, error) {
 }
 
 func (d *driver) Close() error {
-	return nil
+	return d.channels[0].Close()
+}
+
+// Diagnose reports the ADC state and, in pH mode, the electrode offset and
+// slope efficiency
+func (d *driver) Diagnose(ctx context.Context) drivers.Diagnosis {
+	diag := d.adc.Diagnose(ctx)
+	if d.ph != nil {
+		d.ph.Report(&diag)
+	}
+	return diag
 }
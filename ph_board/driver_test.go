@@ -1,9 +1,17 @@
 package ph_board
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/dmolavi/drivers/i2ctest"
+	"github.com/dmolavi/drivers/ph"
+	"github.com/dmolavi/drivers/sim"
+	_ "github.com/dmolavi/drivers/w1"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
@@ -15,9 +23,26 @@ func demo() {
 	fmt.Println(v)
 }
 
+// simBus attaches an ADS1219 simulator at addr to a bus
+func simBus(addr byte) (*sim.ADS1219, *sim.Bus) {
+	chip := sim.NewADS1219()
+	bus := sim.NewBus()
+	bus.Attach(addr, chip)
+	return chip, bus
+}
+
+// conversion scripts a DRDY poll and the read of a conversion result
+func conversion(addr byte, data ...byte) []i2ctest.Tx {
+	return []i2ctest.Tx{
+		i2ctest.Write(addr, cmdRRegSts),
+		i2ctest.Read(addr, statusDRDY),
+		i2ctest.Write(addr, cmdRData),
+		i2ctest.Read(addr, data...),
+	}
+}
+
 func TestPhBoardDriver(t *testing.T) {
-	bus := i2c.MockBus()
-	bus.Bytes = make([]byte, 2)
+	_, bus := simBus(16)
 	_, err := HalAdapter([]byte(""), bus)
 	if err == nil {
 		t.Error("Adapter creation should fail when json config is invalid")
@@ -66,3 +91,187 @@ func TestPhBoardDriver(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestADS1219(t *testing.T) {
+	bus := i2ctest.NewScript(
+		i2ctest.Write(0x40, cmdReset),
+		i2ctest.Write(0x40, cmdWReg, 0x06),
+		i2ctest.Write(0x40, cmdStart),
+	)
+	d, err := NewDriver([]byte(`{"address":64}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, _ := d.AnalogInputPin(0)
+	cases := []struct {
+		data []byte
+		code float64
+	}{
+		{[]byte{0x7F, 0xFF, 0xFF}, 8388607},
+		{[]byte{0x80, 0x00, 0x00}, -8388608},
+		{[]byte{0xFF, 0xFF, 0xFE}, -2},
+		{[]byte{0x00, 0x01, 0x00}, 256},
+	}
+	for _, c := range cases {
+		bus.Expect(conversion(0x40, c.data...)...)
+		v, err := ch.Read()
+		if err != nil {
+			t.Error(err)
+		}
+		if v != c.code {
+			t.Errorf("Expected %f, found: %f", c.code, v)
+		}
+	}
+	// a chip that lost its configuration
+	bus.Expect(i2ctest.Write(0x40, cmdRRegCfg), i2ctest.Read(0x40, 0x00))
+	diag := d.(*driver).Diagnose(context.Background())
+	if !diag.Reachable || diag.Hardware != "ADS1219" || diag.Fields["config"] != "0x00, expected 0x06" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+	if err := bus.Done(); err != nil {
+		t.Error(err)
+	}
+
+	bus = i2ctest.NewScript(
+		i2ctest.Write(0x40, cmdReset),
+		i2ctest.Write(0x40, cmdWReg, 0xA0|0x10|0x0C|0x01),
+	)
+	d, err = NewDriver([]byte(`{"address":64, "gain":4, "data_rate":1000, "vref":3.3, "mux":"AIN2", "single_shot":true}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Done(); err != nil {
+		t.Error("Single shot mode should not start conversions on setup:", err)
+	}
+	bus.Expect(i2ctest.Write(0x40, cmdStart))
+	bus.Expect(conversion(0x40, 0x40, 0x00, 0x00)...)
+	ch, _ = d.AnalogInputPin(0)
+	v, err := ch.(*channel).Volts()
+	if err != nil {
+		t.Error(err)
+	}
+	if v != 3.3/2/4 {
+		t.Error("Expected 0.4125V, found:", v)
+	}
+	if err := bus.Done(); err != nil {
+		t.Error(err)
+	}
+	for _, conf := range []string{`{"gain":2}`, `{"data_rate":100}`, `{"mux":"AIN4"}`, `{"vref":-1}`} {
+		if _, err := NewDriver([]byte(conf), bus); err == nil {
+			t.Error("Expected error for config:", conf)
+		}
+	}
+}
+
+func TestPHMode(t *testing.T) {
+	// 51.2mV differential input
+	chip, bus := simBus(0x40)
+	chip.SetInput(0, sim.Constant(0.0512))
+	d, err := NewDriver([]byte(`{"address":64, "ph":{"temperature":25}}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, _ := d.AnalogInputPin(0)
+	mv, err := pin.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mv < 51.19 || mv > 51.21 {
+		t.Error("Expected 51.2mV, found:", mv)
+	}
+	if err := pin.Calibrate([]hal.Measurement{{Expected: 7, Observed: mv}}); err != nil {
+		t.Error(err)
+	}
+	if v, err := pin.Measure(); err != nil || v != 7 {
+		t.Error("Expected pH 7, found:", v, err)
+	}
+	if _, ok := pin.(*ph.Pin); !ok {
+		t.Error("Expected pH pin in pH mode")
+	}
+	diag := d.(*driver).Diagnose(context.Background())
+	if diag.Fields["ph_offset_mv"] != fmt.Sprint(mv) || diag.Fields["ph_acid_efficiency"] != "100" {
+		t.Error("Expected electrode offset and efficiency in diagnosis, found:", diag.Fields)
+	}
+}
+
+func TestTemperaturePin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "28-0000075a1a2b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=50000\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "28-0000075a1a2b", "w1_slave"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, bus := simBus(0x40)
+	d, err := NewDriver([]byte(`{"address":64, "ph":{"temperature_pin":{"driver":"w1", "config":{"path":"`+dir+`"}}}}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	pin, _ := d.AnalogInputPin(0)
+	if _, err := pin.Measure(); err != nil {
+		t.Fatal(err)
+	}
+	if v := d.(*driver).Diagnose(context.Background()).Fields["ph_temperature"]; v != "50" {
+		t.Error("Expected compensation at 50°C from the temperature pin, found:", v)
+	}
+	for _, conf := range []string{
+		`{"address":64, "ph":{"temperature_pin":{"driver":"w1", "config":{"path":"` + dir + `"}, "pin":1}}}`,
+		`{"address":64, "ph":{"temperature_pin":{"driver":"no-such-driver"}}}`,
+		`{"address":64, "ph":{"temperature_pin":{}}}`,
+	} {
+		if _, err := NewDriver([]byte(conf), bus); err == nil {
+			t.Error("Expected error for config:", conf)
+		}
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	bus := i2ctest.NewScript(
+		i2ctest.Write(0x40, cmdReset),
+		i2ctest.Write(0x40, cmdWReg, 0x06),
+		i2ctest.Write(0x40, cmdStart),
+		i2ctest.Write(0x40, cmdRRegSts),
+		i2ctest.Read(0x40, 0x00),
+		i2ctest.Write(0x40, cmdRRegSts),
+		i2ctest.Read(0x40, statusDRDY),
+		i2ctest.Write(0x40, cmdRData),
+		i2ctest.Read(0x40, 0xFF, 0xFF, 0x9C),
+	)
+	d, err := NewDriver([]byte(`{"address":"0x40"}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, err := d.AnalogInputPin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := pin.Read(); err != nil || v != -100 {
+		t.Error("Unexpected reading:", v, err)
+	}
+	if err := bus.Done(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackToBackReads(t *testing.T) {
+	chip, bus := simBus(0x40)
+	chip.Timed = true
+	chip.SetInput(0, sim.Constant(0.5))
+	d, err := NewDriver([]byte(`{"address":64, "data_rate":20, "mux":"AIN0"}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, _ := d.AnalogInputPin(0)
+	// DRDY rises exactly one conversion period after the previous read
+	for i := 0; i < 4; i++ {
+		if v, err := pin.Read(); err != nil || v != 2048000 {
+			t.Error("Expected a conversion for each read, found:", v, err)
+		}
+	}
+}
@@ -1,19 +1,30 @@
 package pico_board
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"sync"
 
+	"github.com/dmolavi/drivers"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
 
 const chName = "0"
 
+// cmdRead asks the ATSAMD10 firmware for the latest conversion, answered by
+// the next 2 byte read as a big endian signed count. It is the only command
+// of the firmware, which has no version, sampling or calibration registers
+const cmdRead = 0x00
+
 type channel struct {
 	bus        i2c.Bus
 	addr       byte
+	scale      float64
+	mu         sync.Mutex // guards calibrator
 	calibrator hal.Calibrator
+	errs       drivers.ErrorLog
 }
 
 func NewChannel(b i2c.Bus, addr byte) (*channel, error) {
@@ -24,6 +35,7 @@ func NewChannel(b i2c.Bus, addr byte) (*channel, error) {
 	return &channel{
 		bus:        b,
 		addr:       addr,
+		scale:      1,
 		calibrator: c,
 	}, nil
 }
@@ -41,22 +53,30 @@ func (c *channel) Calibrate(points []hal.Measurement) error {
 	if err != nil {
 		return err
 	}
+	c.mu.Lock()
 	c.calibrator = cal
+	c.mu.Unlock()
 	return nil
 }
 
 func (c *channel) Close() error { return nil }
 
 func (c *channel) Read() (float64, error) {
-	if err := c.bus.WriteBytes(c.addr, []byte{0x0}); err != nil {
+	return c.ReadContext(context.Background())
+}
+
+// ReadContext reads the latest conversion, unless ctx is already done. The
+// firmware answers right away, so there is no wait for ctx to cut short
+func (c *channel) ReadContext(ctx context.Context) (float64, error) {
+	if err := ctx.Err(); err != nil {
 		return math.NaN(), err
 	}
-	buf, err := c.bus.ReadBytes(c.addr, 2)
+	buf, err := query(c.bus, c.addr, cmdRead, 2)
 	if err != nil {
-		return math.NaN(), err
+		return math.NaN(), c.errs.Record(err)
 	}
 	v := int16(buf[0])<<8 | int16(buf[1])
-	return float64(v), nil
+	return float64(v) * c.scale, nil
 }
 
 func (c *channel) Measure() (float64, error) {
@@ -64,8 +84,32 @@ func (c *channel) Measure() (float64, error) {
 	if err != nil {
 		return 0, err
 	}
-	if c.calibrator == nil {
+	c.mu.Lock()
+	cal := c.calibrator
+	c.mu.Unlock()
+	if cal == nil {
 		return 0, fmt.Errorf("Not calibrated")
 	}
-	return c.calibrator.Calibrate(v), nil
+	return cal.Calibrate(v), nil
+}
+
+// query sends cmd and reads the n byte answer, holding the bus so no other
+// transfer lands in between
+func query(bus i2c.Bus, addr, cmd byte, n int) ([]byte, error) {
+	var buf []byte
+	err := drivers.Transaction(bus, func() error {
+		if err := bus.WriteBytes(addr, []byte{cmd}); err != nil {
+			return err
+		}
+		var err error
+		buf, err = bus.ReadBytes(addr, n)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != n {
+		return nil, drivers.Transient(fmt.Errorf("unexpected response length %d for command 0x%02x", len(buf), cmd))
+	}
+	return buf, nil
 }
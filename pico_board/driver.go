@@ -1,9 +1,13 @@
 package pico_board
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/ph"
+	"github.com/dmolavi/drivers/schema"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
@@ -14,13 +18,29 @@ var driverMeta = hal.Metadata{
 	Capabilities: []hal.Capability{hal.AnalogInput},
 }
 
+var configSchema = schema.Object(schema.Properties{
+	"address":      schema.Address(),
+	"mv_per_count": schema.Number().Min(0).Describe("Millivolts per ADC count, Read returns raw counts when unset"),
+	"ph":           ph.ConfigSchema,
+}, "address")
+
+func init() {
+	drivers.Register(driverMeta, configSchema, HalAdapter)
+}
+
 type Config struct {
 	Address byte `json:"address"`
+	// MillivoltsPerCount scales ADC counts, Read returns raw counts when unset
+	MillivoltsPerCount float64 `json:"mv_per_count"`
+	// PH enables pH mode, where pins measure pH. It requires MillivoltsPerCount
+	PH *ph.Config `json:"ph"`
 }
 
 type driver struct {
 	channels []hal.AnalogInputPin
 	meta     hal.Metadata
+	ch       *channel
+	ph       *ph.Pin // nil unless in pH mode
 }
 
 func HalAdapter(c []byte, bus i2c.Bus) (hal.Driver, error) {
@@ -28,6 +48,10 @@ func HalAdapter(c []byte, bus i2c.Bus) (hal.Driver, error) {
 }
 
 func NewDriver(c []byte, bus i2c.Bus) (hal.AnalogInputDriver, error) {
+	c, err := configSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
 	var config Config
 	if err := json.Unmarshal(c, &config); err != nil {
 		return nil, err
@@ -37,12 +61,44 @@ func NewDriver(c []byte, bus i2c.Bus) (hal.AnalogInputDriver, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config.MillivoltsPerCount != 0 {
+		ch.scale = config.MillivoltsPerCount
+	}
+	var pin hal.AnalogInputPin = ch
+	var phPin *ph.Pin
+	if config.PH != nil {
+		if config.MillivoltsPerCount == 0 {
+			return nil, fmt.Errorf("pH mode requires mv_per_count")
+		}
+		if phPin, err = ph.NewPin(ch, *config.PH, bus); err != nil {
+			return nil, err
+		}
+		pin = phPin
+	}
 	return &driver{
-		channels: []hal.AnalogInputPin{ch},
+		channels: []hal.AnalogInputPin{pin},
 		meta:     driverMeta,
+		ch:       ch,
+		ph:       phPin,
 	}, nil
 }
 
+// Diagnose reads a conversion to check the board answers. The firmware has
+// no identity or version to report
+func (d *driver) Diagnose(ctx context.Context) drivers.Diagnosis {
+	_, err := d.ch.ReadContext(ctx)
+	diag := d.ch.errs.Diagnosis(err)
+	if err != nil {
+		return diag
+	}
+	diag.Hardware = "pico board"
+	diag.Set("address", fmt.Sprintf("0x%02x", d.ch.addr))
+	if d.ph != nil {
+		d.ph.Report(&diag)
+	}
+	return diag
+}
+
 func (d *driver) Metadata() hal.Metadata {
 	return d.meta
 }
@@ -52,16 +108,7 @@ func (d *driver) AnalogInputPins() []hal.AnalogInputPin {
 }
 
 func (d *driver) Pins(cap hal.Capability) ([]hal.Pin, error) {
-	switch cap {
-	case hal.PWM, hal.DigitalOutput:
-		var pins []hal.Pin
-		for _, pin := range d.channels {
-			pins = append(pins, pin)
-		}
-		return pins, nil
-	default:
-		return nil, fmt.Errorf("unsupported capability:%s", cap.String())
-	}
+	return drivers.Pins(d, cap)
 }
 
 func (d *driver) AnalogInputPin(n int) (hal.AnalogInputPin, error) {
@@ -72,5 +119,5 @@ func (d *driver) AnalogInputPin(n int) (hal.AnalogInputPin, error) {
 }
 
 func (d *driver) Close() error {
-	return nil
+	return d.channels[0].Close()
 }
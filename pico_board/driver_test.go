@@ -1,15 +1,27 @@
 package pico_board
 
 import (
+	"context"
 	"testing"
 
+	"github.com/dmolavi/drivers/i2ctest"
 	"github.com/reef-pi/hal"
-	"github.com/reef-pi/rpi/i2c"
 )
 
+// reads scripts n conversion reads at addr, each answered with count
+func reads(addr byte, count int16, n int) []i2ctest.Tx {
+	var txs []i2ctest.Tx
+	for i := 0; i < n; i++ {
+		txs = append(txs,
+			i2ctest.Write(addr, cmdRead),
+			i2ctest.Read(addr, byte(count>>8), byte(count)),
+		)
+	}
+	return txs
+}
+
 func TestPhBoardDriver(t *testing.T) {
-	bus := i2c.MockBus()
-	bus.Bytes = make([]byte, 2)
+	bus := i2ctest.NewScript(reads(0x48, 0, 1)...)
 	_, err := HalAdapter([]byte(""), bus)
 	if err == nil {
 		t.Error("Adapter creation should fail when json config is invalid")
@@ -57,4 +69,70 @@ func TestPhBoardDriver(t *testing.T) {
 	if err := d.Close(); err != nil {
 		t.Error(err)
 	}
+	if err := bus.Done(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPHMode(t *testing.T) {
+	bus := i2ctest.NewScript(reads(0x48, 100, 3)...)
+	if _, err := NewDriver([]byte(`{"address":72, "ph":{}}`), bus); err == nil {
+		t.Error("pH mode without mv_per_count should fail")
+	}
+	d, err := NewDriver([]byte(`{"address":72, "mv_per_count":0.5, "ph":{}}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, _ := d.AnalogInputPin(0)
+	if v, err := pin.Read(); err != nil || v != 50 {
+		t.Error("Expected 50mV, found:", v, err)
+	}
+	if err := pin.Calibrate([]hal.Measurement{{Expected: 7, Observed: 50}}); err != nil {
+		t.Error(err)
+	}
+	if v, err := pin.Measure(); err != nil || v != 7 {
+		t.Error("Expected pH 7, found:", v, err)
+	}
+	diag := d.(*driver).Diagnose(context.Background())
+	if !diag.Reachable || diag.Fields["ph_offset_mv"] != "50" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+	if err := bus.Done(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	bus := i2ctest.NewScript(
+		i2ctest.Write(0x45, cmdRead),
+		i2ctest.Read(0x45, 0x00, 0x64),
+		i2ctest.Write(0x45, cmdRead),
+		i2ctest.Read(0x45, 0x00, 0x65),
+		i2ctest.Write(0x45, cmdRead).Fails("remote I/O error"),
+	)
+	d, err := NewDriver([]byte(`{"address":"0x45"}`), bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, _ := d.AnalogInputPin(0)
+	if v, err := pin.Read(); err != nil || v != 100 {
+		t.Error("Unexpected reading:", v, err)
+	}
+	diag := d.(*driver).Diagnose(context.Background())
+	if !diag.Reachable || diag.LastError != "" || diag.Fields["address"] != "0x45" {
+		t.Errorf("Expected a reachable board, found: %+v", diag)
+	}
+	diag = d.(*driver).Diagnose(context.Background())
+	if diag.Reachable || diag.LastError == "" {
+		t.Errorf("Expected an unreachable board, found: %+v", diag)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	diag = d.(*driver).Diagnose(ctx)
+	if diag.Reachable || diag.LastError != context.Canceled.Error() {
+		t.Errorf("Expected a cancelled diagnosis without bus traffic, found: %+v", diag)
+	}
+	if err := bus.Done(); err != nil {
+		t.Error(err)
+	}
 }
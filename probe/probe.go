@@ -0,0 +1,233 @@
+// Package probe scans an I2C bus and identifies the devices supported by this
+// repo, suggesting a driver config for each of them.
+//
+// Probes only read registers, except for the opt-in EZO information command,
+// and run in an order where no probe can disturb a chip sharing its address
+// range: the
+// ADS1219 status command is a plain register pointer to the ADS1x15 and
+// PCA9685, while the ADS1x15 register pointers would power an ADS1219 down.
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers/ezo"
+)
+
+const (
+	FirstAddress = 0x03
+	LastAddress  = 0x77
+
+	pca9685AllCall = 0x70
+
+	// factory default addresses of the EZO circuits, from DO (0x61) to
+	// humidity (0x6F)
+	ezoFirst = 0x61
+	ezoLast  = 0x6F
+)
+
+// Device is a device that acknowledged its address. Driver and Config are
+// empty when no registered driver is suggested for it
+type Device struct {
+	Address byte            `json:"address"`
+	Name    string          `json:"name"`
+	Driver  string          `json:"driver,omitempty"`
+	Config  json.RawMessage `json:"config,omitempty"`
+}
+
+func (d Device) String() string {
+	if d.Driver == "" {
+		return fmt.Sprintf("0x%02x %s", d.Address, d.Name)
+	}
+	return fmt.Sprintf("0x%02x %s: %s %s", d.Address, d.Name, d.Driver, d.Config)
+}
+
+// Prober identifies the device at addr. It returns nil without error when the
+// device is not recognized
+type Prober func(bus i2c.Bus, addr byte) (*Device, error)
+
+// DefaultProbers identify the I2C devices supported by this repo that can be
+// told apart by reading registers, in a safe order
+var DefaultProbers = []Prober{ADS1219, ADS1x15, PCA9685, HT16K33}
+
+// AllProbers also identify EZO circuits, which writes their information
+// command to the unclaimed addresses in the EZO range
+var AllProbers = []Prober{ADS1219, ADS1x15, PCA9685, EZO, HT16K33}
+
+// Scan probes every address from 0x03 to 0x77 and returns the devices that
+// acknowledged, identified by the first matching prober. DefaultProbers are
+// used when none are given
+func Scan(bus i2c.Bus, probers ...Prober) ([]Device, error) {
+	if len(probers) == 0 {
+		probers = DefaultProbers
+	}
+	var devices []Device
+	for addr := byte(FirstAddress); addr <= LastAddress; addr++ {
+		if _, err := bus.ReadBytes(addr, 1); err != nil {
+			continue
+		}
+		d, err := Identify(bus, addr, probers...)
+		if err != nil {
+			return devices, err
+		}
+		devices = append(devices, d)
+	}
+	return allCall(devices), nil
+}
+
+// Identify runs the probers against a single address
+func Identify(bus i2c.Bus, addr byte, probers ...Prober) (Device, error) {
+	if len(probers) == 0 {
+		probers = DefaultProbers
+	}
+	for _, p := range probers {
+		d, err := p(bus, addr)
+		if err != nil {
+			return Device{}, err
+		}
+		if d != nil {
+			return *d, nil
+		}
+	}
+	return Device{Address: addr, Name: "unknown"}, nil
+}
+
+// every PCA9685 answers its all call address as well, which is not a device
+// of its own once other PCA9685 are found
+func allCall(devices []Device) []Device {
+	chips := 0
+	for _, d := range devices {
+		if d.Driver == "pca9685" {
+			chips++
+		}
+	}
+	for i, d := range devices {
+		if d.Address == pca9685AllCall && d.Driver == "pca9685" && chips > 1 {
+			devices[i] = Device{Address: d.Address, Name: "PCA9685 all call"}
+		}
+	}
+	return devices
+}
+
+func suggest(addr byte, name, driver string, config map[string]interface{}) (*Device, error) {
+	if config == nil {
+		config = make(map[string]interface{})
+	}
+	config["address"] = addr
+	c, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Device{Address: addr, Name: name, Driver: driver, Config: c}, nil
+}
+
+func readReg(bus i2c.Bus, addr, reg byte, n int) ([]byte, bool) {
+	buf := make([]byte, n)
+	if err := bus.ReadFromReg(addr, reg, buf); err != nil {
+		return nil, false
+	}
+	return buf, true
+}
+
+// ADS1219 identifies the ADS1219 of the pH board by the device identifier in
+// its status register. An ADS1x15 answers with its conversion register, which
+// is rejected unless the config register reads differently
+func ADS1219(bus i2c.Bus, addr byte) (*Device, error) {
+	if addr < 0x40 || addr > 0x4F {
+		return nil, nil
+	}
+	status, ok := readReg(bus, addr, 0x24, 1)
+	if !ok || status[0]&0x7F != 0x60 {
+		return nil, nil
+	}
+	config, ok := readReg(bus, addr, 0x20, 1)
+	if !ok || config[0] == status[0] {
+		return nil, nil
+	}
+	return suggest(addr, "ADS1219", "ph-board", nil)
+}
+
+// ADS1x15 identifies an ADS1115 or ADS1015 by its power-on config register
+// (0x8583) or comparator thresholds (0x8000, 0x7FFF). Both chips reset alike,
+// the ads1115 default is suggested
+func ADS1x15(bus i2c.Bus, addr byte) (*Device, error) {
+	if addr < 0x48 || addr > 0x4B {
+		return nil, nil
+	}
+	config, ok := readReg(bus, addr, 0x01, 2)
+	if !ok {
+		return nil, nil
+	}
+	lo, ok := readReg(bus, addr, 0x02, 2)
+	if !ok {
+		return nil, nil
+	}
+	hi, ok := readReg(bus, addr, 0x03, 2)
+	if !ok {
+		return nil, nil
+	}
+	word := func(b []byte) uint16 { return uint16(b[0])<<8 | uint16(b[1]) }
+	if word(config) != 0x8583 && (word(lo) != 0x8000 || word(hi) != 0x7FFF) {
+		return nil, nil
+	}
+	return suggest(addr, "ADS1115/ADS1015", "ads1x15", nil)
+}
+
+// PCA9685 identifies a PCA9685 by its MODE1, MODE2 and PRE_SCALE registers:
+// all call enabled, reserved MODE2 bits clear and a prescale of at least 3.
+// The configured frequency is suggested when the chip is running
+func PCA9685(bus i2c.Bus, addr byte) (*Device, error) {
+	if addr < 0x40 {
+		return nil, nil
+	}
+	mode1, ok := readReg(bus, addr, 0x00, 1)
+	if !ok || mode1[0]&0x01 == 0 {
+		return nil, nil
+	}
+	mode2, ok := readReg(bus, addr, 0x01, 1)
+	if !ok || mode2[0]&0xE0 != 0 {
+		return nil, nil
+	}
+	prescale, ok := readReg(bus, addr, 0xFE, 1)
+	if !ok || prescale[0] < 3 {
+		return nil, nil
+	}
+	config := make(map[string]interface{})
+	// unknown while asleep (power-on) or fed by an external clock
+	if mode1[0]&0x50 == 0 {
+		config["frequency"] = int(math.Round(25000000 / (4096 * (float64(prescale[0]) + 1))))
+	}
+	return suggest(addr, "PCA9685", "pca9685", config)
+}
+
+// EZO identifies Atlas Scientific EZO circuits at their factory default
+// addresses by their information (i) response. The command is a write, and
+// other chips may take it for data. Only pH circuits have a driver
+func EZO(bus i2c.Bus, addr byte) (*Device, error) {
+	if addr < ezoFirst || addr > ezoLast {
+		return nil, nil
+	}
+	device, version, err := ezo.NewAtlasEZO(addr, bus).Information()
+	if err != nil {
+		return nil, nil
+	}
+	name := fmt.Sprintf("EZO %s (firmware %s)", device, version)
+	if !strings.EqualFold(device, "pH") {
+		return &Device{Address: addr, Name: name}, nil
+	}
+	return suggest(addr, name, ezo.NewAtlasEZO(addr, bus).Metadata().Name, nil)
+}
+
+// HT16K33 recognizes the HT16K33 by its address alone, it has no readable
+// registers to tell it apart. It has no registered driver
+func HT16K33(_ i2c.Bus, addr byte) (*Device, error) {
+	if addr < 0x70 || addr > 0x77 {
+		return nil, nil
+	}
+	return &Device{Address: addr, Name: "HT16K33 (by address)"}, nil
+}
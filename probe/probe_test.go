@@ -0,0 +1,104 @@
+package probe
+
+import (
+	"testing"
+
+	"github.com/dmolavi/drivers"
+	_ "github.com/dmolavi/drivers/all"
+	"github.com/dmolavi/drivers/i2ctest"
+	"github.com/dmolavi/drivers/pca9685"
+	"github.com/dmolavi/drivers/sim"
+)
+
+func TestScan(t *testing.T) {
+	tank := sim.NewTank()
+	bus := i2ctest.NewRecorder(tank)
+	devices, err := Scan(bus, AllProbers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`0x40 PCA9685: pca9685 {"address":64}`,
+		`0x41 ADS1219: ph-board {"address":65}`,
+		`0x48 ADS1115/ADS1015: ads1x15 {"address":72}`,
+		`0x63 EZO pH (firmware 1.98): Atlas Scientific EZO(pH) {"address":99}`,
+		`0x70 HT16K33 (by address)`,
+	}
+	if len(devices) != len(expected) {
+		t.Fatal("Expected", len(expected), "devices, found:", devices)
+	}
+	for i, d := range devices {
+		if d.String() != expected[i] {
+			t.Error("Expected", expected[i], "found", d)
+		}
+		if d.Driver == "" {
+			continue
+		}
+		if err := drivers.Validate(d.Driver, d.Config); err != nil {
+			t.Error("Suggested config of", d, "is invalid:", err)
+		}
+	}
+	for _, tx := range bus.Transactions() {
+		switch tx.Op {
+		case i2ctest.OpWriteReg:
+			t.Error("Probes should not write registers:", tx)
+		case i2ctest.OpWrite:
+			if string(tx.Data) != "i\000" {
+				t.Error("Only the EZO information command should be written:", tx)
+			}
+			if tx.Addr < ezoFirst || tx.Addr > ezoLast {
+				t.Error("EZO information command outside the EZO range:", tx)
+			}
+		}
+	}
+}
+
+func TestDefaultScan(t *testing.T) {
+	tank := sim.NewTank()
+	bus := i2ctest.NewRecorder(tank)
+	devices, err := Scan(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 5 || devices[3].String() != "0x63 unknown" {
+		t.Error("Expected the EZO circuit to be left unidentified, found:", devices)
+	}
+	for _, tx := range bus.Transactions() {
+		if tx.Op == i2ctest.OpWrite || tx.Op == i2ctest.OpWriteReg {
+			t.Error("Default probes should not write:", tx)
+		}
+	}
+	if d, _ := EZO(tank, 0x50); d != nil {
+		t.Error("EZO probe should skip addresses outside the EZO range")
+	}
+}
+
+func TestPCA9685Frequency(t *testing.T) {
+	tank := sim.NewTank()
+	p := pca9685.New(0x40, tank)
+	p.Freq = 1500
+	if err := p.Wake(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := PCA9685(tank, 0x40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil || string(d.Config) != `{"address":64,"frequency":1526}` {
+		t.Error("Unexpected suggestion:", d)
+	}
+	if d, _ := PCA9685(tank, 0x48); d != nil {
+		t.Error("ADS1115 should not be taken for a PCA9685")
+	}
+}
+
+func TestAllCall(t *testing.T) {
+	devices := allCall([]Device{
+		{Address: 0x40, Driver: "pca9685"},
+		{Address: 0x41, Driver: "pca9685"},
+		{Address: 0x70, Driver: "pca9685"},
+	})
+	if devices[2].Driver != "" || devices[2].Name != "PCA9685 all call" {
+		t.Error("All call address should not be suggested as a driver:", devices[2])
+	}
+}
@@ -0,0 +1,165 @@
+package drivers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers/schema"
+)
+
+// Factory builds a driver from its JSON config. Every HAL adapter in this
+// repo has this signature
+type Factory func([]byte, i2c.Bus) (hal.Driver, error)
+
+type registration struct {
+	meta    hal.Metadata
+	schema  *schema.Schema
+	factory Factory
+}
+
+// Registry maps driver names to their metadata and factory
+type Registry struct {
+	mu      sync.RWMutex
+	entries map[string]registration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]registration)}
+}
+
+// Register adds a factory and its config schema under the metadata name.
+// Names must be unique
+func (r *Registry) Register(meta hal.Metadata, s *schema.Schema, f Factory) error {
+	if meta.Name == "" {
+		return fmt.Errorf("driver name can not be empty")
+	}
+	if f == nil {
+		return fmt.Errorf("nil factory for driver %s", meta.Name)
+	}
+	if s == nil {
+		return fmt.Errorf("nil config schema for driver %s", meta.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[meta.Name]; ok {
+		return fmt.Errorf("driver %s is already registered", meta.Name)
+	}
+	r.entries[meta.Name] = registration{meta: meta, schema: s, factory: f}
+	return nil
+}
+
+// Lookup returns the factory registered under name
+func (r *Registry) Lookup(name string) (Factory, error) {
+	e, err := r.entry(name)
+	if err != nil {
+		return nil, err
+	}
+	return e.factory, nil
+}
+
+// Metadata returns the metadata registered under name
+func (r *Registry) Metadata(name string) (hal.Metadata, error) {
+	e, err := r.entry(name)
+	if err != nil {
+		return hal.Metadata{}, err
+	}
+	return e.meta, nil
+}
+
+// Schema returns the config schema registered under name, with the optional
+// "retry" object every driver config accepts
+func (r *Registry) Schema(name string) (*schema.Schema, error) {
+	e, err := r.entry(name)
+	if err != nil {
+		return nil, err
+	}
+	return withRetry(e.schema), nil
+}
+
+// Validate checks a JSON config for the driver registered under name without
+// building it. Field failures are returned as schema.Errors
+func (r *Registry) Validate(name string, config []byte) error {
+	s, err := r.Schema(name)
+	if err != nil {
+		return err
+	}
+	return s.Validate(config)
+}
+
+// List returns the metadata of every registered driver, sorted by name
+func (r *Registry) List() []hal.Metadata {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var metas []hal.Metadata
+	for _, e := range r.entries {
+		metas = append(metas, e.meta)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Name < metas[j].Name })
+	return metas
+}
+
+// Build creates the driver registered under name from its JSON config. When
+// the config has a "retry" object, the driver pins are wrapped by a Retrier
+// with that policy
+func (r *Registry) Build(name string, config []byte, bus i2c.Bus) (hal.Driver, error) {
+	f, err := r.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := retryPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	d, err := f(config, bus)
+	if err != nil || policy == nil {
+		return d, err
+	}
+	return NewRetrier(*policy).WrapDriver(d), nil
+}
+
+func (r *Registry) entry(name string) (registration, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.entries[name]
+	if !ok {
+		return registration{}, fmt.Errorf("unknown driver: %s", name)
+	}
+	return e, nil
+}
+
+// DefaultRegistry holds the drivers of every imported package. Packages
+// register themselves on init, import github.com/dmolavi/drivers/all to
+// register all of them
+var DefaultRegistry = NewRegistry()
+
+// Register adds a factory to the default registry. It is meant to be called
+// from package init and panics on invalid or duplicate registrations
+func Register(meta hal.Metadata, s *schema.Schema, f Factory) {
+	if err := DefaultRegistry.Register(meta, s, f); err != nil {
+		panic(err)
+	}
+}
+
+func Lookup(name string) (Factory, error) {
+	return DefaultRegistry.Lookup(name)
+}
+
+func Schema(name string) (*schema.Schema, error) {
+	return DefaultRegistry.Schema(name)
+}
+
+func Validate(name string, config []byte) error {
+	return DefaultRegistry.Validate(name, config)
+}
+
+func List() []hal.Metadata {
+	return DefaultRegistry.List()
+}
+
+func Build(name string, config []byte, bus i2c.Bus) (hal.Driver, error) {
+	return DefaultRegistry.Build(name, config, bus)
+}
@@ -0,0 +1,92 @@
+package drivers_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+
+	"github.com/dmolavi/drivers"
+	_ "github.com/dmolavi/drivers/all"
+	"github.com/dmolavi/drivers/schema"
+)
+
+func TestRegistry(t *testing.T) {
+	r := drivers.NewRegistry()
+	meta := hal.Metadata{Name: "noop", Capabilities: []hal.Capability{hal.DigitalOutput}}
+	var config []byte
+	factory := func(c []byte, _ i2c.Bus) (hal.Driver, error) {
+		config = c
+		return hal.NewNoopDriver(), nil
+	}
+	s := schema.Object(schema.Properties{"a": schema.Integer().Max(1)})
+	if err := r.Register(meta, s, factory); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(meta, s, factory); err == nil {
+		t.Error("Duplicate registration should fail")
+	}
+	if err := r.Register(hal.Metadata{}, s, factory); err == nil {
+		t.Error("Registration without name should fail")
+	}
+	if err := r.Register(hal.Metadata{Name: "nil"}, s, nil); err == nil {
+		t.Error("Registration without factory should fail")
+	}
+	if err := r.Register(hal.Metadata{Name: "nil"}, nil, factory); err == nil {
+		t.Error("Registration without schema should fail")
+	}
+	if err := r.Validate("noop", []byte(`{"a":2}`)); err == nil {
+		t.Error("Invalid config should fail validation")
+	}
+	if _, err := r.Lookup("unknown"); err == nil {
+		t.Error("Lookup of unknown driver should fail")
+	}
+	if m, err := r.Metadata("noop"); err != nil || !reflect.DeepEqual(m, meta) {
+		t.Error("Unexpected metadata:", m, err)
+	}
+	if _, err := r.Build("noop", []byte(`{"a":1}`), i2c.MockBus()); err != nil {
+		t.Error(err)
+	}
+	if string(config) != `{"a":1}` {
+		t.Error("Config was not passed to factory:", string(config))
+	}
+	if _, err := r.Build("unknown", nil, i2c.MockBus()); err == nil {
+		t.Error("Build of unknown driver should fail")
+	}
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	var names []string
+	for _, m := range drivers.List() {
+		names = append(names, m.Name)
+	}
+	expected := []string{
+		"Atlas Scientific EZO(pH)", "ads1x15", "analog-file", "digital-file", "dli-pro", "gpio-file",
+		"pca9685", "ph-board", "pico-board", "pwm-file", "sensor-file",
+		"tplink-hs103", "tplink-hs110", "tplink-hs300", "w1",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Error("Unexpected registered drivers:", names)
+	}
+	for _, name := range names {
+		s, err := drivers.Schema(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := json.Marshal(s); err != nil {
+			t.Error("Schema of", name, "does not marshal:", err)
+		}
+	}
+	if err := drivers.Validate("pca9685", []byte(`{"address":"0x40", "frequency":0}`)); err == nil {
+		t.Error("Invalid pca9685 config should fail validation")
+	}
+	d, err := drivers.Build("Atlas Scientific EZO(pH)", []byte(`{"address":99}`), i2c.MockBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Metadata().Name != "Atlas Scientific EZO(pH)" {
+		t.Error("Unexpected driver:", d.Metadata().Name)
+	}
+}
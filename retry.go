@@ -0,0 +1,537 @@
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/reef-pi/hal"
+
+	"github.com/dmolavi/drivers/schema"
+)
+
+// ErrCircuitOpen is returned without touching the device while a Retrier
+// circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// RetryPolicy configures the retries and circuit breaker of a Retrier.
+// Delays are in milliseconds
+type RetryPolicy struct {
+	// Attempts is the number of tries per call, including the first
+	Attempts int `json:"attempts"`
+	// Backoff is the delay before the first retry, doubled for every further
+	// retry up to MaxBackoff
+	Backoff    int `json:"backoff"`
+	MaxBackoff int `json:"max_backoff"`
+	// Threshold is the number of consecutive failed calls opening the
+	// breaker, 0 disables it
+	Threshold int `json:"threshold"`
+	// Cooldown is how long an open breaker fails fast before letting a
+	// trial call through
+	Cooldown int `json:"cooldown"`
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   3,
+	Backoff:    50,
+	MaxBackoff: 1000,
+	Threshold:  5,
+	Cooldown:   30000,
+}
+
+// RetrySchema describes the optional "retry" object of every driver config
+var RetrySchema = schema.Object(schema.Properties{
+	"attempts":    schema.Integer().Range(1, 10).WithDefault(DefaultRetryPolicy.Attempts),
+	"backoff":     schema.Integer().Min(0).WithDefault(DefaultRetryPolicy.Backoff).Describe("Delay before the first retry in milliseconds"),
+	"max_backoff": schema.Integer().Min(0).WithDefault(DefaultRetryPolicy.MaxBackoff),
+	"threshold": schema.Integer().Min(0).WithDefault(DefaultRetryPolicy.Threshold).
+		Describe("Consecutive failed calls opening the circuit breaker, 0 disables it"),
+	"cooldown": schema.Integer().Min(0).WithDefault(DefaultRetryPolicy.Cooldown).
+		Describe("Milliseconds an open circuit breaker fails fast before a trial call"),
+}).Describe("Retry transient I/O errors and fail fast while the device is unreachable")
+
+var retryConfigSchema = schema.Object(schema.Properties{"retry": RetrySchema})
+
+// retryPolicy returns the policy of the "retry" object in a driver config,
+// or nil when there is none. Malformed configs are left to the driver
+func retryPolicy(config []byte) (*RetryPolicy, error) {
+	var raw struct {
+		Retry json.RawMessage `json:"retry"`
+	}
+	if err := json.Unmarshal(config, &raw); err != nil || len(raw.Retry) == 0 || string(raw.Retry) == "null" {
+		return nil, nil
+	}
+	config, err := retryConfigSchema.Apply(config)
+	if err != nil {
+		return nil, err
+	}
+	var c struct {
+		Retry *RetryPolicy `json:"retry"`
+	}
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	return c.Retry, nil
+}
+
+// withRetry returns a copy of an object schema s with the "retry" property
+func withRetry(s *schema.Schema) *schema.Schema {
+	if _, ok := s.Properties["retry"]; ok || len(s.Type) != 1 || s.Type[0] != "object" {
+		return s
+	}
+	c := *s
+	c.Properties = schema.Properties{"retry": RetrySchema}
+	for name, p := range s.Properties {
+		c.Properties[name] = p
+	}
+	return &c
+}
+
+type transientError struct{ error }
+
+func (e transientError) Unwrap() error { return e.error }
+
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// Transient marks err as worth retrying
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return transientError{err}
+}
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsTransient reports whether retrying the call that failed with err may
+// succeed. Errors marked by Transient or Permanent are classified by their
+// outermost mark. Otherwise I/O errors of the bus (NACKs, EIO), network
+// errors, timeouts and short reads are transient, everything else, like
+// invalid values or configs, is permanent
+func IsTransient(err error) bool {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch e.(type) {
+		case transientError:
+			return true
+		case permanentError:
+			return false
+		}
+	}
+	switch {
+	case err == nil, errors.Is(err, ErrCircuitOpen), errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	// connection failures carry the errno of the socket call
+	var op *net.OpError
+	if errors.As(err, &op) {
+		return true
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		for _, t := range transientErrnos {
+			if errno == t {
+				return true
+			}
+		}
+		return errno.Temporary() || errno.Timeout()
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// BreakerState is the state of a Retrier circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets calls through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails calls fast with ErrCircuitOpen
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial call through, its outcome closes
+	// or reopens the breaker
+	BreakerHalfOpen
+)
+
+var _breakerStates = []string{"closed", "open", "half-open"}
+
+func (s BreakerState) String() string {
+	return _breakerStates[s]
+}
+
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Health reports the state of a Retrier
+type Health struct {
+	State BreakerState `json:"state"`
+	// Failures counts consecutive calls failing with transient errors
+	Failures      int       `json:"failures"`
+	Retries       int       `json:"retries"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time"`
+	LastSuccess   time.Time `json:"last_success"`
+}
+
+// Healthy reports whether calls go through
+func (h Health) Healthy() bool {
+	return h.State != BreakerOpen
+}
+
+// HealthReporter is implemented by drivers and pins wrapped by a Retrier
+type HealthReporter interface {
+	Health() Health
+}
+
+// Retrier retries calls failing with transient errors and fails fast, with
+// a circuit breaker, while the device keeps failing. A Retrier is meant to be
+// shared by every pin of a device, and is safe for concurrent use
+type Retrier struct {
+	policy RetryPolicy
+	mu     sync.Mutex
+	health Health
+	opened time.Time
+}
+
+func NewRetrier(policy RetryPolicy) *Retrier {
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	return &Retrier{policy: policy}
+}
+
+func (r *Retrier) Health() Health {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.health
+}
+
+// Do calls f until it succeeds, fails with a permanent error, runs out of
+// attempts or ctx is done. Retries back off exponentially
+func (r *Retrier) Do(ctx context.Context, f func(context.Context) error) error {
+	if err := r.allow(); err != nil {
+		return err
+	}
+	backoff := time.Duration(r.policy.Backoff) * time.Millisecond
+	max := time.Duration(r.policy.MaxBackoff) * time.Millisecond
+	var err error
+	for i := 1; ; i++ {
+		err = f(ctx)
+		if err == nil || i >= r.policy.Attempts || !IsTransient(err) {
+			break
+		}
+		if Sleep(ctx, backoff) != nil {
+			break
+		}
+		r.mu.Lock()
+		r.health.Retries++
+		r.health.LastError = err.Error()
+		r.health.LastErrorTime = time.Now()
+		r.mu.Unlock()
+		if backoff *= 2; backoff > max {
+			backoff = max
+		}
+	}
+	r.record(ctx, err)
+	return err
+}
+
+func (r *Retrier) allow() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch r.health.State {
+	case BreakerOpen:
+		if time.Since(r.opened) < time.Duration(r.policy.Cooldown)*time.Millisecond {
+			return fmt.Errorf("%w, last error: %s", ErrCircuitOpen, r.health.LastError)
+		}
+		r.health.State = BreakerHalfOpen
+	case BreakerHalfOpen:
+		return fmt.Errorf("%w, trial call pending", ErrCircuitOpen)
+	}
+	return nil
+}
+
+func (r *Retrier) record(ctx context.Context, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if err == nil {
+		r.health.State = BreakerClosed
+		r.health.Failures = 0
+		r.health.LastSuccess = now
+		return
+	}
+	r.health.LastError = err.Error()
+	r.health.LastErrorTime = now
+	// permanent errors and callers giving up say nothing about the device,
+	// an undecided trial reopens the breaker for another cooldown
+	if ctx.Err() != nil || !IsTransient(err) {
+		if r.health.State == BreakerHalfOpen {
+			r.open(now)
+		}
+		return
+	}
+	r.health.Failures++
+	if r.health.State == BreakerHalfOpen || (r.policy.Threshold > 0 && r.health.Failures >= r.policy.Threshold) {
+		r.open(now)
+	}
+}
+
+// open fails calls fast for a cooldown from now. Callers hold mu
+func (r *Retrier) open(now time.Time) {
+	r.health.State = BreakerOpen
+	r.opened = now
+}
+
+// WrapAnalogInput returns p with reads, measurements and calibration retried
+func (r *Retrier) WrapAnalogInput(p hal.AnalogInputPin) hal.AnalogInputPin {
+	return &retryAnalogInput{AnalogInputPin: p, r: r}
+}
+
+// WrapDigitalOutput returns p with writes retried. PWM channels keep their
+// PWMChannel interface
+func (r *Retrier) WrapDigitalOutput(p hal.DigitalOutputPin) hal.DigitalOutputPin {
+	if ch, ok := p.(hal.PWMChannel); ok {
+		return r.WrapPWM(ch)
+	}
+	return &retryDigitalOutput{DigitalOutputPin: p, r: r}
+}
+
+// WrapPWM returns ch with writes and duty cycle changes retried
+func (r *Retrier) WrapPWM(ch hal.PWMChannel) hal.PWMChannel {
+	return &retryPWM{retryDigitalOutput: &retryDigitalOutput{DigitalOutputPin: ch, r: r}, ch: ch}
+}
+
+// WrapDriver returns d with every analog input, digital output and PWM pin
+// wrapped, all sharing r. Digital inputs are passed through
+func (r *Retrier) WrapDriver(d hal.Driver) hal.Driver {
+	return &retryDriver{Driver: d, r: r}
+}
+
+// UnwrapPin returns the pin wrapped by a Retrier, other pins are returned as
+// they are
+func UnwrapPin(p hal.Pin) hal.Pin {
+	if w, ok := p.(interface{ Unwrap() hal.Pin }); ok {
+		return w.Unwrap()
+	}
+	return p
+}
+
+type retryAnalogInput struct {
+	hal.AnalogInputPin
+	r *Retrier
+}
+
+func (p *retryAnalogInput) Read() (float64, error) {
+	var v float64
+	err := p.r.Do(context.Background(), func(context.Context) error {
+		var err error
+		v, err = p.AnalogInputPin.Read()
+		return err
+	})
+	return v, err
+}
+
+func (p *retryAnalogInput) ReadContext(ctx context.Context) (float64, error) {
+	var v float64
+	err := p.r.Do(ctx, func(ctx context.Context) error {
+		var err error
+		v, err = ReadContext(ctx, p.AnalogInputPin)
+		return err
+	})
+	return v, err
+}
+
+func (p *retryAnalogInput) Measure() (float64, error) {
+	var v float64
+	err := p.r.Do(context.Background(), func(context.Context) error {
+		var err error
+		v, err = p.AnalogInputPin.Measure()
+		return err
+	})
+	return v, err
+}
+
+func (p *retryAnalogInput) MeasureContext(ctx context.Context) (float64, error) {
+	var v float64
+	err := p.r.Do(ctx, func(ctx context.Context) error {
+		var err error
+		v, err = MeasureContext(ctx, p.AnalogInputPin)
+		return err
+	})
+	return v, err
+}
+
+func (p *retryAnalogInput) Calibrate(points []hal.Measurement) error {
+	return p.r.Do(context.Background(), func(context.Context) error {
+		return p.AnalogInputPin.Calibrate(points)
+	})
+}
+
+func (p *retryAnalogInput) Health() Health  { return p.r.Health() }
+func (p *retryAnalogInput) Unwrap() hal.Pin { return p.AnalogInputPin }
+
+type retryDigitalOutput struct {
+	hal.DigitalOutputPin
+	r *Retrier
+}
+
+func (p *retryDigitalOutput) Write(state bool) error {
+	return p.r.Do(context.Background(), func(context.Context) error {
+		return p.DigitalOutputPin.Write(state)
+	})
+}
+
+func (p *retryDigitalOutput) WriteContext(ctx context.Context, state bool) error {
+	return p.r.Do(ctx, func(ctx context.Context) error {
+		return WriteContext(ctx, p.DigitalOutputPin, state)
+	})
+}
+
+func (p *retryDigitalOutput) Health() Health  { return p.r.Health() }
+func (p *retryDigitalOutput) Unwrap() hal.Pin { return p.DigitalOutputPin }
+
+type retryPWM struct {
+	*retryDigitalOutput
+	ch hal.PWMChannel
+}
+
+func (p *retryPWM) Set(value float64) error {
+	return p.r.Do(context.Background(), func(context.Context) error {
+		return p.ch.Set(value)
+	})
+}
+
+type retryDriver struct {
+	hal.Driver
+	r *Retrier
+}
+
+func (d *retryDriver) Pins(cap hal.Capability) ([]hal.Pin, error) {
+	return Pins(d, cap)
+}
+
+func (d *retryDriver) AnalogInputPins() []hal.AnalogInputPin {
+	a, ok := d.Driver.(hal.AnalogInputDriver)
+	if !ok {
+		return nil
+	}
+	var pins []hal.AnalogInputPin
+	for _, p := range a.AnalogInputPins() {
+		pins = append(pins, d.r.WrapAnalogInput(p))
+	}
+	return pins
+}
+
+func (d *retryDriver) AnalogInputPin(n int) (hal.AnalogInputPin, error) {
+	a, ok := d.Driver.(hal.AnalogInputDriver)
+	if !ok {
+		return nil, fmt.Errorf("%s has no analog inputs", d.Metadata().Name)
+	}
+	p, err := a.AnalogInputPin(n)
+	if err != nil {
+		return nil, err
+	}
+	return d.r.WrapAnalogInput(p), nil
+}
+
+func (d *retryDriver) DigitalInputPins() []hal.DigitalInputPin {
+	if i, ok := d.Driver.(hal.DigitalInputDriver); ok {
+		return i.DigitalInputPins()
+	}
+	return nil
+}
+
+func (d *retryDriver) DigitalInputPin(n int) (hal.DigitalInputPin, error) {
+	i, ok := d.Driver.(hal.DigitalInputDriver)
+	if !ok {
+		return nil, fmt.Errorf("%s has no digital inputs", d.Metadata().Name)
+	}
+	return i.DigitalInputPin(n)
+}
+
+func (d *retryDriver) DigitalOutputPins() []hal.DigitalOutputPin {
+	o, ok := d.Driver.(hal.DigitalOutputDriver)
+	if !ok {
+		return nil
+	}
+	var pins []hal.DigitalOutputPin
+	for _, p := range o.DigitalOutputPins() {
+		pins = append(pins, d.r.WrapDigitalOutput(p))
+	}
+	return pins
+}
+
+func (d *retryDriver) DigitalOutputPin(n int) (hal.DigitalOutputPin, error) {
+	o, ok := d.Driver.(hal.DigitalOutputDriver)
+	if !ok {
+		return nil, fmt.Errorf("%s has no digital outputs", d.Metadata().Name)
+	}
+	p, err := o.DigitalOutputPin(n)
+	if err != nil {
+		return nil, err
+	}
+	return d.r.WrapDigitalOutput(p), nil
+}
+
+func (d *retryDriver) PWMChannels() []hal.PWMChannel {
+	w, ok := d.Driver.(hal.PWMDriver)
+	if !ok {
+		return nil
+	}
+	var chs []hal.PWMChannel
+	for _, ch := range w.PWMChannels() {
+		chs = append(chs, d.r.WrapPWM(ch))
+	}
+	return chs
+}
+
+func (d *retryDriver) PWMChannel(n int) (hal.PWMChannel, error) {
+	w, ok := d.Driver.(hal.PWMDriver)
+	if !ok {
+		return nil, fmt.Errorf("%s has no pwm channels", d.Metadata().Name)
+	}
+	ch, err := w.PWMChannel(n)
+	if err != nil {
+		return nil, err
+	}
+	return d.r.WrapPWM(ch), nil
+}
+
+func (d *retryDriver) Health() Health { return d.r.Health() }
+
+// Diagnose adds the breaker state to the diagnosis of the wrapped driver.
+// Without one, the device counts as reachable while the breaker is closed
+func (d *retryDriver) Diagnose(ctx context.Context) Diagnosis {
+	h := d.r.Health()
+	diag := Diagnosis{Reachable: h.Healthy()}
+	if dd, ok := d.Driver.(Diagnostics); ok {
+		diag = dd.Diagnose(ctx)
+	}
+	if h.LastError != "" && !h.LastErrorTime.Before(diag.LastErrorTime) {
+		diag.LastError = h.LastError
+		diag.LastErrorTime = h.LastErrorTime
+	}
+	diag.Set("breaker", h.State)
+	diag.Set("retries", h.Retries)
+	return diag
+}
+
+// Unwrap returns the wrapped driver, for driver specific methods
+func (d *retryDriver) Unwrap() hal.Driver { return d.Driver }
@@ -0,0 +1,6 @@
+package drivers
+
+import "syscall"
+
+// EREMOTEIO is how the i2c-dev driver reports a NACK
+var transientErrnos = []syscall.Errno{syscall.EIO, syscall.ENXIO, syscall.EREMOTEIO, syscall.EBUSY}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package drivers
+
+import "syscall"
+
+var transientErrnos = []syscall.Errno{syscall.EIO, syscall.ENXIO, syscall.EBUSY}
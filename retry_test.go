@@ -0,0 +1,213 @@
+package drivers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/reef-pi/hal"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/schema"
+	"github.com/dmolavi/drivers/sim"
+)
+
+// flaky is a pin failing its first fails calls with err
+type flaky struct {
+	calls int
+	fails int
+	err   error
+}
+
+func (f *flaky) call() error {
+	f.calls++
+	if f.calls <= f.fails {
+		return f.err
+	}
+	return nil
+}
+
+func (f *flaky) Name() string                        { return "flaky" }
+func (f *flaky) Number() int                         { return 0 }
+func (f *flaky) Close() error                        { return nil }
+func (f *flaky) Read() (float64, error)              { return 7, f.call() }
+func (f *flaky) Measure() (float64, error)           { return 7, f.call() }
+func (f *flaky) Calibrate(_ []hal.Measurement) error { return f.call() }
+func (f *flaky) Write(_ bool) error                  { return f.call() }
+func (f *flaky) LastState() bool                     { return false }
+func (f *flaky) Set(_ float64) error                 { return f.call() }
+
+func TestIsTransient(t *testing.T) {
+	nack := &os.PathError{Op: "write", Path: "/dev/i2c-1", Err: syscall.EIO}
+	invalid := errors.New("invalid value: 120.000000 above 100")
+	cases := []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{nack, true},
+		{fmt.Errorf("ph: %w", nack), true},
+		{syscall.EINVAL, false},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{io.ErrUnexpectedEOF, true},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{drivers.ErrCircuitOpen, false},
+		{invalid, false},
+		{drivers.Transient(invalid), true},
+		{fmt.Errorf("outlet: %w", drivers.Transient(invalid)), true},
+		{drivers.Permanent(nack), false},
+		{drivers.Transient(drivers.Permanent(nack)), true},
+	}
+	for _, c := range cases {
+		if drivers.IsTransient(c.err) != c.transient {
+			t.Errorf("Expected transient %v for %v", c.transient, c.err)
+		}
+	}
+	if drivers.Transient(nil) != nil || drivers.Permanent(nil) != nil {
+		t.Error("Marking nil should return nil")
+	}
+}
+
+func TestRetrier(t *testing.T) {
+	r := drivers.NewRetrier(drivers.RetryPolicy{Attempts: 3, Backoff: 1, MaxBackoff: 2})
+	pin := &flaky{fails: 2, err: syscall.EIO}
+	in := r.WrapAnalogInput(pin)
+	if v, err := in.Read(); err != nil || v != 7 {
+		t.Error("Expected the third attempt to succeed, found:", v, err)
+	}
+	if pin.calls != 3 {
+		t.Error("Expected 3 calls, found:", pin.calls)
+	}
+	h := in.(drivers.HealthReporter).Health()
+	if h.Retries != 2 || h.Failures != 0 || !h.Healthy() || h.LastError == "" || h.LastSuccess.IsZero() {
+		t.Errorf("Unexpected health: %+v", h)
+	}
+
+	pin = &flaky{fails: 5, err: syscall.EIO}
+	if _, err := r.WrapAnalogInput(pin).Measure(); !errors.Is(err, syscall.EIO) || pin.calls != 3 {
+		t.Error("Expected the error after 3 attempts, found:", err, pin.calls)
+	}
+	pin = &flaky{fails: 5, err: errors.New("invalid value")}
+	if err := r.WrapPWM(pin).Set(120); err == nil || pin.calls != 1 {
+		t.Error("Permanent errors should not be retried, found:", err, pin.calls)
+	}
+	if drivers.UnwrapPin(r.WrapDigitalOutput(pin)) != pin {
+		t.Error("Expected the wrapped pin")
+	}
+	if _, ok := r.WrapDigitalOutput(pin).(hal.PWMChannel); !ok {
+		t.Error("Wrapped PWM channels should remain PWM channels")
+	}
+
+	// retries stop once the caller gives up
+	r = drivers.NewRetrier(drivers.RetryPolicy{Attempts: 10, Backoff: 1000})
+	pin = &flaky{fails: 10, err: syscall.EIO}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := drivers.WriteContext(ctx, r.WrapDigitalOutput(pin), true); !errors.Is(err, syscall.EIO) || pin.calls != 1 {
+		t.Error("Expected a single attempt, found:", err, pin.calls)
+	}
+	if h := r.Health(); h.Failures != 0 {
+		t.Error("Cancelled calls should not count as failures:", h.Failures)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	r := drivers.NewRetrier(drivers.RetryPolicy{Attempts: 1, Threshold: 2, Cooldown: 20})
+	pin := &flaky{fails: 3, err: syscall.EIO}
+	out := r.WrapDigitalOutput(pin)
+	for i := 0; i < 2; i++ {
+		if err := out.Write(true); !errors.Is(err, syscall.EIO) {
+			t.Error("Expected the device error, found:", err)
+		}
+	}
+	if err := out.Write(true); !errors.Is(err, drivers.ErrCircuitOpen) || pin.calls != 2 {
+		t.Error("Expected the breaker to fail fast, found:", err, pin.calls)
+	}
+	if h := r.Health(); h.State != drivers.BreakerOpen || h.Healthy() {
+		t.Errorf("Unexpected health: %+v", h)
+	}
+
+	// a failed trial reopens the breaker, a successful one closes it
+	time.Sleep(30 * time.Millisecond)
+	if err := out.Write(true); !errors.Is(err, syscall.EIO) || r.Health().State != drivers.BreakerOpen {
+		t.Error("Expected the trial to reopen the breaker, found:", err, r.Health().State)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := out.Write(true); err != nil {
+		t.Error(err)
+	}
+	if h := r.Health(); h.State != drivers.BreakerClosed || h.Failures != 0 {
+		t.Errorf("Unexpected health: %+v", h)
+	}
+}
+
+func TestCircuitBreakerPermanentTrial(t *testing.T) {
+	r := drivers.NewRetrier(drivers.RetryPolicy{Attempts: 1, Threshold: 1, Cooldown: 50})
+	pin := &flaky{fails: 2, err: syscall.EIO}
+	out := r.WrapDigitalOutput(pin)
+	if err := out.Write(true); !errors.Is(err, syscall.EIO) {
+		t.Error("Expected the device error, found:", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	pin.err = errors.New("invalid value")
+	if err := out.Write(true); err == nil || errors.Is(err, drivers.ErrCircuitOpen) {
+		t.Error("Expected the trial to fail with the device error, found:", err)
+	}
+	// the failed trial starts a new cooldown
+	if err := out.Write(true); !errors.Is(err, drivers.ErrCircuitOpen) || pin.calls != 2 {
+		t.Error("Expected the breaker to fail fast after a failed trial, found:", err, pin.calls)
+	}
+}
+
+func TestRetryConfig(t *testing.T) {
+	tank := sim.NewTank()
+	d, err := drivers.Build("pca9685", []byte(`{"address":64,"retry":{"attempts":2,"backoff":1,"threshold":1}}`), tank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := drivers.CheckCapabilities(d); err != nil {
+		t.Error(err)
+	}
+	ch, err := d.(hal.PWMDriver).PWMChannel(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Set(50); err != nil {
+		t.Error(err)
+	}
+	tank.Detach(0x40)
+	if err := ch.Set(50); err == nil || !drivers.IsTransient(err) {
+		t.Error("Expected a transient error, found:", err)
+	}
+	tank.Attach(0x40, tank.PCA9685)
+	if err := ch.Set(50); !errors.Is(err, drivers.ErrCircuitOpen) {
+		t.Error("Expected an open breaker, found:", err)
+	}
+	h := d.(drivers.HealthReporter).Health()
+	if h.Retries != 1 || h.State != drivers.BreakerOpen {
+		t.Errorf("Unexpected health: %+v", h)
+	}
+
+	s, err := drivers.Schema("pca9685")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Properties["retry"]; !ok {
+		t.Error("Expected the retry property in the schema")
+	}
+	invalid := []byte(`{"retry":{"attempts":0}}`)
+	err = drivers.Validate("pca9685", invalid)
+	if errs, ok := err.(schema.Errors); !ok || errs[0].Field != "retry.attempts" {
+		t.Error("Expected a retry.attempts failure, found:", err)
+	}
+	if _, err := drivers.Build("pca9685", invalid, tank); err == nil {
+		t.Error("Invalid retry config should fail")
+	}
+}
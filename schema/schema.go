@@ -0,0 +1,345 @@
+// Package schema describes driver configs with a subset of JSON Schema, and
+// validates and normalizes them before drivers are built
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FormatI2CAddress marks 7-bit I2C addresses, given either as a number or as
+// a decimal or hex ("0x40") string
+const FormatI2CAddress = "i2c-address"
+
+var _addressPattern = `^(0[xX][0-9a-fA-F]{1,2}|[0-9]{1,3})$`
+
+// Types holds the JSON types a value may take. A single type marshals as a
+// plain string
+type Types []string
+
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *Types) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = Types{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*t = l
+	return nil
+}
+
+// Properties maps object fields to their schema
+type Properties map[string]*Schema
+
+// Schema is the subset of JSON Schema used to describe driver configs
+type Schema struct {
+	Type        Types         `json:"type,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Format      string        `json:"format,omitempty"`
+	Default     interface{}   `json:"default,omitempty"`
+	Minimum     *float64      `json:"minimum,omitempty"`
+	Maximum     *float64      `json:"maximum,omitempty"`
+	MinLength   int           `json:"minLength,omitempty"`
+	Pattern     string        `json:"pattern,omitempty"`
+	Enum        []interface{} `json:"enum,omitempty"`
+	Items       *Schema       `json:"items,omitempty"`
+	Properties  Properties    `json:"properties,omitempty"`
+	Required    []string      `json:"required,omitempty"`
+}
+
+func Object(props Properties, required ...string) *Schema {
+	return &Schema{Type: Types{"object"}, Properties: props, Required: required}
+}
+
+func Array(items *Schema) *Schema {
+	return &Schema{Type: Types{"array"}, Items: items}
+}
+
+func String() *Schema  { return &Schema{Type: Types{"string"}} }
+func Integer() *Schema { return &Schema{Type: Types{"integer"}} }
+func Number() *Schema  { return &Schema{Type: Types{"number"}} }
+func Boolean() *Schema { return &Schema{Type: Types{"boolean"}} }
+
+// Address describes a 7-bit I2C address (0x03-0x77)
+func Address() *Schema {
+	return (&Schema{
+		Type:    Types{"integer", "string"},
+		Format:  FormatI2CAddress,
+		Pattern: _addressPattern,
+	}).Range(0x03, 0x77)
+}
+
+func (s *Schema) Describe(d string) *Schema {
+	s.Description = d
+	return s
+}
+
+func (s *Schema) WithDefault(v interface{}) *Schema {
+	s.Default = v
+	return s
+}
+
+func (s *Schema) Min(v float64) *Schema {
+	s.Minimum = &v
+	return s
+}
+
+func (s *Schema) Max(v float64) *Schema {
+	s.Maximum = &v
+	return s
+}
+
+func (s *Schema) Range(min, max float64) *Schema {
+	return s.Min(min).Max(max)
+}
+
+func (s *Schema) OneOf(values ...interface{}) *Schema {
+	s.Enum = values
+	return s
+}
+
+func (s *Schema) NonEmpty() *Schema {
+	s.MinLength = 1
+	return s
+}
+
+// FieldError is a validation failure of a single config field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// Errors lists every field that failed validation
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	var msgs []string
+	for _, f := range e {
+		msgs = append(msgs, f.Error())
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks a JSON config against the schema. Failures are returned as
+// Errors
+func (s *Schema) Validate(config []byte) error {
+	_, err := s.Apply(config)
+	return err
+}
+
+// Apply validates a JSON config and returns it with defaults filled in and
+// I2C addresses and whole integers converted to plain numbers, ready to be
+// unmarshalled. An empty config is treated as an empty object
+func (s *Schema) Apply(config []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(config)) == 0 {
+		config = []byte("{}")
+	}
+	d := json.NewDecoder(bytes.NewReader(config))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, Errors{{Message: err.Error()}}
+	}
+	var errs Errors
+	v = s.apply("", v, &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return json.Marshal(v)
+}
+
+func (s *Schema) apply(field string, v interface{}, errs *Errors) interface{} {
+	fail := func(format string, args ...interface{}) interface{} {
+		*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		return v
+	}
+	if s.Format == FormatI2CAddress {
+		if str, ok := v.(string); ok {
+			if !regexp.MustCompile(s.Pattern).MatchString(str) {
+				return fail("invalid address %q", str)
+			}
+			n, err := strconv.ParseUint(strings.ToLower(str), 0, 8)
+			if err != nil {
+				return fail("invalid address %q", str)
+			}
+			v = json.Number(strconv.FormatUint(n, 10))
+		}
+	}
+	if !s.matchesType(v) {
+		return fail("expected %s", strings.Join(s.Type, " or "))
+	}
+	if n, ok := v.(json.Number); ok && s.hasType("integer") {
+		if _, err := n.Int64(); err != nil {
+			// whole numbers like 1e3 or 64.0 do not unmarshal into ints
+			f, _ := n.Float64()
+			v = json.Number(strconv.FormatInt(int64(f), 10))
+		}
+	}
+	if len(s.Enum) > 0 && !s.inEnum(v) {
+		return fail("must be one of %v", s.Enum)
+	}
+	switch t := v.(type) {
+	case json.Number:
+		f, _ := t.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			return fail("%v is below minimum %v", t, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fail("%v is above maximum %v", t, *s.Maximum)
+		}
+	case string:
+		if len(t) < s.MinLength {
+			if s.MinLength == 1 {
+				return fail("can not be empty")
+			}
+			return fail("must be at least %d characters", s.MinLength)
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(t) {
+			return fail("does not match %s", s.Pattern)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range t {
+				t[i] = s.Items.apply(fmt.Sprintf("%s[%d]", field, i), item, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, r := range s.Required {
+			if _, ok := t[r]; !ok {
+				*errs = append(*errs, FieldError{Field: join(field, r), Message: "is required"})
+			}
+		}
+		var names []string
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p := s.Properties[name]
+			fv, ok := t[name]
+			if !ok || fv == nil {
+				if p.Default != nil {
+					t[name] = p.Default
+				}
+				continue
+			}
+			t[name] = p.apply(join(field, name), fv, errs)
+		}
+	}
+	return v
+}
+
+func join(parent, field string) string {
+	if parent == "" {
+		return field
+	}
+	return parent + "." + field
+}
+
+func (s *Schema) matchesType(v interface{}) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+	for _, t := range s.Type {
+		switch t {
+		case "object":
+			if _, ok := v.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "number":
+			if _, ok := v.(json.Number); ok {
+				return true
+			}
+		case "integer":
+			if n, ok := v.(json.Number); ok && isInteger(n) {
+				return true
+			}
+		case "null":
+			if v == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Schema) hasType(t string) bool {
+	for _, st := range s.Type {
+		if st == t {
+			return true
+		}
+	}
+	return false
+}
+
+// isInteger reports whether n is a whole number that fits an int64, in any
+// JSON notation
+func isInteger(n json.Number) bool {
+	if _, err := n.Int64(); err == nil {
+		return true
+	}
+	f, err := n.Float64()
+	return err == nil && f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64
+}
+
+func (s *Schema) inEnum(v interface{}) bool {
+	for _, e := range s.Enum {
+		switch t := v.(type) {
+		case json.Number:
+			f, _ := t.Float64()
+			if n, ok := toFloat(e); ok && n == f {
+				return true
+			}
+		default:
+			if e == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
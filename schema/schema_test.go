@@ -0,0 +1,115 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var testSchema = Object(Properties{
+	"address":   Address().WithDefault(0x40),
+	"frequency": Integer().Range(24, 1526).WithDefault(1500),
+	"mode":      String().OneOf("off", "hold"),
+	"name":      String().NonEmpty(),
+	"channels": Array(Object(Properties{
+		"number": Integer().Min(0),
+		"gain":   Number().OneOf(1, 2.5),
+	}, "number")),
+}, "name")
+
+func TestApply(t *testing.T) {
+	c, err := testSchema.Apply([]byte(`{"name":"pwm", "address":"0x41", "channels":[{"number":1, "gain":2.5}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Address   byte   `json:"address"`
+		Frequency int    `json:"frequency"`
+		Name      string `json:"name"`
+	}
+	if err := json.Unmarshal(c, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Address != 0x41 || config.Frequency != 1500 || config.Name != "pwm" {
+		t.Error("Unexpected config:", string(c))
+	}
+	for _, a := range []string{`64`, `"64"`, `"0X40"`} {
+		c, err := testSchema.Apply([]byte(`{"name":"pwm", "address":` + a + `}`))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if err := json.Unmarshal(c, &config); err != nil || config.Address != 0x40 {
+			t.Error("Unexpected address for", a, string(c), err)
+		}
+	}
+	for _, f := range []string{`1e3`, `64.0`, `1.5e2`} {
+		c, err := testSchema.Apply([]byte(`{"name":"pwm", "frequency":` + f + `}`))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if err := json.Unmarshal(c, &config); err != nil {
+			t.Error("Unexpected frequency for", f, string(c), err)
+		}
+	}
+	c, err = Object(Properties{"frequency": Integer().WithDefault(1500)}).Apply([]byte(` `))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(c) != `{"frequency":1500}` {
+		t.Error("Empty config should get defaults, found:", string(c))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	err := testSchema.Validate([]byte(`{
+		"address": "0x90",
+		"frequency": 0,
+		"mode": "on",
+		"name": "",
+		"channels": [{"gain": 3}, {"number": 1.5}, {"number": 2e0}]
+	}`))
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatal("Expected field errors, found:", err)
+	}
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	expected := []string{"address", "channels[0].number", "channels[0].gain", "channels[1].number", "frequency", "mode", "name"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Error("Unexpected failing fields:", fields, errs)
+	}
+	if err := testSchema.Validate([]byte(`{}`)); err == nil || err.Error() != "invalid config: name: is required" {
+		t.Error("Missing required field should fail, found:", err)
+	}
+	if err := testSchema.Validate([]byte(`{"name": 1}`)); err == nil {
+		t.Error("Wrong type should fail")
+	}
+	if err := testSchema.Validate([]byte(``)); err == nil {
+		t.Error("Empty config should fail")
+	}
+	if err := testSchema.Validate([]byte(`{"name":"x", "address":"zz"}`)); err == nil {
+		t.Error("Invalid address should fail")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	buf, err := json.Marshal(Object(Properties{"address": Address().WithDefault(0x40)}, "address"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"object","properties":{"address":{"type":["integer","string"],"format":"i2c-address","default":64,"minimum":3,"maximum":119,"pattern":"^(0[xX][0-9a-fA-F]{1,2}|[0-9]{1,3})$"}},"required":["address"]}`
+	if string(buf) != expected {
+		t.Error("Unexpected schema:", string(buf))
+	}
+	var s Schema
+	if err := json.Unmarshal(buf, &s); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Properties["address"].Type, Types{"integer", "string"}) {
+		t.Error("Unexpected types:", s.Properties["address"].Type)
+	}
+}
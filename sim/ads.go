@@ -0,0 +1,326 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Waveform returns an input voltage at the time elapsed since the simulator
+// was created
+type Waveform func(t time.Duration) float64
+
+func Constant(v float64) Waveform {
+	return func(time.Duration) float64 { return v }
+}
+
+// Sine oscillates around offset
+func Sine(offset, amplitude float64, period time.Duration) Waveform {
+	return func(t time.Duration) float64 {
+		return offset + amplitude*math.Sin(2*math.Pi*float64(t)/float64(period))
+	}
+}
+
+// Ramp rises linearly from one voltage to another and starts over every period
+func Ramp(from, to float64, period time.Duration) Waveform {
+	return func(t time.Duration) float64 {
+		return from + (to-from)*float64(t%period)/float64(period)
+	}
+}
+
+// Square alternates between two voltages every half period
+func Square(low, high float64, period time.Duration) Waveform {
+	return func(t time.Duration) float64 {
+		if t%period < period/2 {
+			return low
+		}
+		return high
+	}
+}
+
+// analogInputs holds the waveforms of a converter's inputs
+type analogInputs struct {
+	// Now is the clock waveforms are sampled with
+	Now    func() time.Time
+	start  time.Time
+	inputs [4]Waveform
+}
+
+func newAnalogInputs() analogInputs {
+	return analogInputs{Now: time.Now, start: time.Now()}
+}
+
+func (a *analogInputs) input(ch int) float64 {
+	if a.inputs[ch] == nil {
+		return 0
+	}
+	return a.inputs[ch](a.Now().Sub(a.start))
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, math.Round(v)))
+}
+
+const (
+	adsConversion = 0x00
+	adsConfig     = 0x01
+	adsOS         = 0x8000
+	adsSingle     = 0x0100
+)
+
+var adsFSR = []float64{6.144, 4.096, 2.048, 1.024, 0.512, 0.256, 0.256, 0.256}
+
+// ADS1x15 simulates the registers of an ADS1115 (16 bit) or ADS1015 (12 bit)
+// converter. Single-shot conversions complete as soon as they are started,
+// continuous conversions sample the inputs whenever the conversion register
+// is read
+type ADS1x15 struct {
+	mu sync.Mutex
+	analogInputs
+	bits int
+	regs [4]uint16
+	ptr  byte
+}
+
+func NewADS1115() *ADS1x15 { return newADS1x15(16) }
+func NewADS1015() *ADS1x15 { return newADS1x15(12) }
+
+func newADS1x15(bits int) *ADS1x15 {
+	return &ADS1x15{
+		analogInputs: newAnalogInputs(),
+		bits:         bits,
+		regs:         [4]uint16{0x0000, 0x0583, 0x8000, 0x7FFF},
+	}
+}
+
+// SetInput sets the waveform of an input (0-3)
+func (a *ADS1x15) SetInput(ch int, w Waveform) error {
+	if ch < 0 || ch > 3 {
+		return fmt.Errorf("invalid input %d", ch)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inputs[ch] = w
+	return nil
+}
+
+func (a *ADS1x15) Write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ptr = data[0] & 0x03
+	if len(data) < 3 {
+		return nil
+	}
+	v := uint16(data[1])<<8 | uint16(data[2])
+	switch a.ptr {
+	case adsConversion:
+		// read only
+	case adsConfig:
+		a.regs[adsConfig] = v &^ adsOS
+		if v&adsSingle != 0 && v&adsOS != 0 {
+			a.regs[adsConversion] = a.convert()
+		}
+	default:
+		a.regs[a.ptr] = v
+	}
+	return nil
+}
+
+func (a *ADS1x15) Read(n int) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	v := a.regs[a.ptr]
+	switch a.ptr {
+	case adsConversion:
+		if a.regs[adsConfig]&adsSingle == 0 {
+			a.regs[adsConversion] = a.convert()
+			v = a.regs[adsConversion]
+		}
+	case adsConfig:
+		v |= adsOS // no conversion in progress
+	}
+	buf := make([]byte, n)
+	for i := range buf {
+		if i < 2 {
+			buf[i] = byte(v >> uint(8-8*i))
+		}
+	}
+	return buf, nil
+}
+
+// volts returns the input selected by the multiplexer
+func (a *ADS1x15) volts(mux uint16) float64 {
+	switch mux {
+	case 0:
+		return a.input(0) - a.input(1)
+	case 1:
+		return a.input(0) - a.input(3)
+	case 2:
+		return a.input(1) - a.input(3)
+	case 3:
+		return a.input(2) - a.input(3)
+	}
+	return a.input(int(mux - 4))
+}
+
+func (a *ADS1x15) convert() uint16 {
+	cfg := a.regs[adsConfig]
+	fsr := adsFSR[(cfg>>9)&0x07]
+	v := a.volts((cfg >> 12) & 0x07)
+	full := math.Pow(2, float64(a.bits-1))
+	code := int16(clamp(v/fsr*full, -full, full-1))
+	return uint16(code) << uint(16-a.bits)
+}
+
+// Config returns the config register, without the OS bit
+func (a *ADS1x15) Config() uint16 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.regs[adsConfig]
+}
+
+// Register returns a register (0-3), e.g. the comparator thresholds
+func (a *ADS1x15) Register(reg byte) uint16 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.regs[reg&0x03]
+}
+
+const (
+	ads1219Reset     = 0x06
+	ads1219Start     = 0x08
+	ads1219PowerDown = 0x02
+	ads1219RData     = 0x10
+	ads1219RRegCfg   = 0x20
+	ads1219RRegSts   = 0x24
+	ads1219WReg      = 0x40
+	ads1219DRDY      = 0x80
+	ads1219ID        = 0x60 // device identifier in the status register
+)
+
+// ADS1219 simulates the command set of an ADS1219 24 bit converter
+type ADS1219 struct {
+	mu sync.Mutex
+	analogInputs
+	// ExternalVRef is the voltage of the external reference, used when
+	// the config selects it
+	ExternalVRef float64
+	// Timed makes a conversion take one period of the configured data rate,
+	// DRDY rises a period after START or the previous RDATA
+	Timed   bool
+	config  byte
+	running bool
+	drdy    bool
+	data    int32
+	next    byte // register returned by the next read
+	readyAt time.Time
+}
+
+func NewADS1219() *ADS1219 {
+	return &ADS1219{analogInputs: newAnalogInputs(), ExternalVRef: 2.048}
+}
+
+// SetInput sets the waveform of an input (0-3)
+func (a *ADS1219) SetInput(ch int, w Waveform) error {
+	if ch < 0 || ch > 3 {
+		return fmt.Errorf("invalid input %d", ch)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inputs[ch] = w
+	return nil
+}
+
+func (a *ADS1219) Write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch cmd := data[0]; {
+	case cmd == ads1219Reset:
+		a.config, a.running, a.drdy = 0, false, false
+	case cmd == ads1219Start:
+		a.running = true
+		a.data, a.drdy = a.convert(), true
+		a.readyAt = a.Now().Add(a.period())
+	case cmd == ads1219PowerDown:
+		a.running = false
+	case cmd == ads1219RData, cmd == ads1219RRegCfg, cmd == ads1219RRegSts:
+		a.next = cmd
+	case cmd&0xFC == ads1219WReg:
+		if len(data) != 2 {
+			return fmt.Errorf("wreg expects one data byte, got %d", len(data)-1)
+		}
+		a.config = data[1]
+	default:
+		return fmt.Errorf("unknown ads1219 command 0x%02x", cmd)
+	}
+	return nil
+}
+
+func (a *ADS1219) continuous() bool {
+	return a.config&0x02 != 0
+}
+
+func (a *ADS1219) Read(n int) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	buf := make([]byte, n)
+	if n == 0 {
+		return buf, nil
+	}
+	switch a.next {
+	case ads1219RData:
+		if a.continuous() && a.running {
+			a.data = a.convert()
+		}
+		v := uint32(a.data)
+		copy(buf, []byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		a.drdy = a.continuous() && a.running
+		a.readyAt = a.Now().Add(a.period())
+	case ads1219RRegCfg:
+		buf[0] = a.config
+	case ads1219RRegSts:
+		buf[0] = ads1219ID
+		ready := a.drdy || (a.continuous() && a.running)
+		if ready && (!a.Timed || !a.Now().Before(a.readyAt)) {
+			buf[0] |= ads1219DRDY
+		}
+	}
+	return buf, nil
+}
+
+// period is the conversion time at the configured data rate
+func (a *ADS1219) period() time.Duration {
+	rates := [4]time.Duration{20, 90, 330, 1000}
+	return time.Second / rates[a.config>>2&0x03]
+}
+
+func (a *ADS1219) convert() int32 {
+	var v float64
+	switch a.config >> 5 {
+	case 0:
+		v = a.input(0) - a.input(1)
+	case 1:
+		v = a.input(2) - a.input(3)
+	case 2:
+		v = a.input(1) - a.input(2)
+	case 3, 4, 5, 6:
+		v = a.input(int(a.config>>5) - 3)
+	}
+	vref := 2.048
+	if a.config&0x01 != 0 {
+		vref = a.ExternalVRef
+	}
+	gain := 1.0
+	if a.config&0x10 != 0 {
+		gain = 4
+	}
+	full := float64(1 << 23)
+	return int32(clamp(v*gain/vref*full, -full, full-1))
+}
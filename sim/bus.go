@@ -0,0 +1,153 @@
+// Package sim provides register-level simulators of the chips driven by this
+// repo behind an address-multiplexed i2c.Bus, so drivers (and reef-pi) can run
+// against a virtual tank without hardware.
+package sim
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+// Device is a simulated I2C chip. Write receives the bytes of a write
+// transaction, register writes arrive with the register as first byte. Read
+// answers a read transaction of n bytes
+type Device interface {
+	Write(data []byte) error
+	Read(n int) ([]byte, error)
+}
+
+// Bus routes transactions to the device attached at their address. Register
+// accesses are carried out as a write of the register followed by a read or
+// write, as on the wire
+type Bus struct {
+	mu      sync.Mutex
+	devices map[byte]Device
+}
+
+func NewBus() *Bus {
+	return &Bus{devices: make(map[byte]Device)}
+}
+
+// Attach places a device at the given address
+func (b *Bus) Attach(addr byte, d Device) error {
+	if addr > 0x7F {
+		return fmt.Errorf("invalid address 0x%02x", addr)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.devices[addr]; ok {
+		return fmt.Errorf("address 0x%02x is already in use", addr)
+	}
+	b.devices[addr] = d
+	return nil
+}
+
+// Detach removes the device at the given address
+func (b *Bus) Detach(addr byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.devices, addr)
+}
+
+// Device returns the device attached at the given address, nil when none is
+func (b *Bus) Device(addr byte) Device {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.devices[addr]
+}
+
+func (b *Bus) device(addr byte) (Device, error) {
+	d, ok := b.devices[addr]
+	if !ok {
+		// ENXIO, as returned by i2c-dev for an unanswered address
+		return nil, fmt.Errorf("no device acknowledged address 0x%02x: %w", addr, syscall.ENXIO)
+	}
+	return d, nil
+}
+
+func (b *Bus) SetAddress(addr byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.device(addr)
+	return err
+}
+
+func (b *Bus) ReadBytes(addr byte, num int) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, err := b.device(addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.Read(num)
+}
+
+func (b *Bus) WriteBytes(addr byte, value []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, err := b.device(addr)
+	if err != nil {
+		return err
+	}
+	return d.Write(value)
+}
+
+func (b *Bus) ReadFromReg(addr, reg byte, value []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, err := b.device(addr)
+	if err != nil {
+		return err
+	}
+	if err := d.Write([]byte{reg}); err != nil {
+		return err
+	}
+	data, err := d.Read(len(value))
+	if err != nil {
+		return err
+	}
+	copy(value, data)
+	return nil
+}
+
+func (b *Bus) WriteToReg(addr, reg byte, value []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, err := b.device(addr)
+	if err != nil {
+		return err
+	}
+	return d.Write(append([]byte{reg}, value...))
+}
+
+func (b *Bus) Close() error { return nil }
+
+// Tank is a bus with one of each simulated chip at its default address
+type Tank struct {
+	*Bus
+	PCA9685 *PCA9685
+	HT16K33 *HT16K33
+	EZO     *EZO
+	ADS1115 *ADS1x15
+	ADS1219 *ADS1219
+}
+
+// NewTank builds a virtual controller: a PCA9685 at 0x40, an ADS1219 at 0x41,
+// an ADS1115 at 0x48, an EZO pH circuit at 0x63 and an HT16K33 at 0x70
+func NewTank() *Tank {
+	t := &Tank{
+		Bus:     NewBus(),
+		PCA9685: NewPCA9685(),
+		HT16K33: NewHT16K33(),
+		EZO:     NewEZO(),
+		ADS1115: NewADS1115(),
+		ADS1219: NewADS1219(),
+	}
+	t.Attach(0x40, t.PCA9685)
+	t.Attach(0x41, t.ADS1219)
+	t.Attach(0x48, t.ADS1115)
+	t.Attach(0x63, t.EZO)
+	t.Attach(0x70, t.HT16K33)
+	return t
+}
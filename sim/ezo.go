@@ -0,0 +1,254 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EZO response codes
+const (
+	EZOSuccess    = 1
+	EZOSyntax     = 2
+	EZOProcessing = 254
+	EZONoData     = 255
+)
+
+type ezoPoint struct {
+	raw, expected float64
+}
+
+// EZO simulates an Atlas Scientific EZO pH circuit in I2C mode: its command
+// parser, calibration state and processing delays. Responses are available
+// once the processing delay has passed, reads before that return
+// EZOProcessing
+type EZO struct {
+	mu sync.Mutex
+	// PH returns the uncalibrated probe reading at the given temperature (°C)
+	PH func(temperature float64) float64
+	// Now is the clock used for processing delays
+	Now func() time.Time
+	// ReadDelay applies to readings and calibrations, CommandDelay to
+	// every other command
+	ReadDelay    time.Duration
+	CommandDelay time.Duration
+	Version      string
+	Voltage      float64
+
+	led         bool
+	name        string
+	temperature float64
+	cal         map[string]ezoPoint
+	sleeping    bool
+	code        byte
+	response    string
+	ready       time.Time
+}
+
+// NewEZO returns a circuit reading pH 7 with the datasheet processing delays
+func NewEZO() *EZO {
+	return &EZO{
+		PH:           func(float64) float64 { return 7 },
+		Now:          time.Now,
+		ReadDelay:    900 * time.Millisecond,
+		CommandDelay: 300 * time.Millisecond,
+		Version:      "1.98",
+		Voltage:      5.038,
+		led:          true,
+		temperature:  25,
+		cal:          make(map[string]ezoPoint),
+		code:         EZONoData,
+	}
+}
+
+func (e *EZO) Write(data []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	cmd := strings.TrimRight(string(data), "\000")
+	e.sleeping = false
+	e.code, e.response = EZOSuccess, ""
+	delay := e.CommandDelay
+	parts := strings.Split(cmd, ",")
+	switch strings.ToLower(parts[0]) {
+	case "r":
+		delay = e.ReadDelay
+		e.response = strconv.FormatFloat(e.reading(), 'f', 3, 64)
+	case "cal":
+		delay = e.ReadDelay
+		e.calibrate(parts[1:])
+	case "i":
+		e.response = "?I,pH," + e.Version
+	case "status":
+		e.response = fmt.Sprintf("?Status,P,%.3f", e.Voltage)
+	case "l":
+		e.led = e.flag(parts[1:], e.led, "?L,")
+	case "t":
+		e.setTemperature(parts[1:])
+	case "name":
+		switch {
+		case len(parts) == 2 && parts[1] == "?":
+			e.response = "?Name," + e.name
+		case len(parts) == 2:
+			e.name = parts[1]
+		default:
+			e.code = EZOSyntax
+		}
+	case "sleep":
+		e.sleeping = true
+		e.code = EZONoData
+		return nil
+	case "factory":
+		e.led, e.name, e.temperature = true, "", 25
+		e.cal = make(map[string]ezoPoint)
+	case "find", "baud", "plock", "i2c":
+	default:
+		e.code = EZOSyntax
+	}
+	e.ready = e.Now().Add(delay)
+	return nil
+}
+
+func (e *EZO) flag(args []string, v bool, query string) bool {
+	if len(args) != 1 {
+		e.code = EZOSyntax
+		return v
+	}
+	switch args[0] {
+	case "1":
+		return true
+	case "0":
+		return false
+	case "?":
+		if v {
+			e.response = query + "1"
+		} else {
+			e.response = query + "0"
+		}
+	default:
+		e.code = EZOSyntax
+	}
+	return v
+}
+
+func (e *EZO) setTemperature(args []string) {
+	if len(args) != 1 {
+		e.code = EZOSyntax
+		return
+	}
+	if args[0] == "?" {
+		e.response = fmt.Sprintf("?T,%.2f", e.temperature)
+		return
+	}
+	t, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		e.code = EZOSyntax
+		return
+	}
+	e.temperature = t
+}
+
+func (e *EZO) calibrate(args []string) {
+	if len(args) == 1 {
+		switch args[0] {
+		case "?":
+			e.response = fmt.Sprintf("?Cal,%d", len(e.cal))
+		case "clear":
+			e.cal = make(map[string]ezoPoint)
+		default:
+			e.code = EZOSyntax
+		}
+		return
+	}
+	if len(args) != 2 {
+		e.code = EZOSyntax
+		return
+	}
+	v, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		e.code = EZOSyntax
+		return
+	}
+	p := ezoPoint{raw: e.PH(e.temperature), expected: v}
+	switch args[0] {
+	case "mid":
+		// a mid point calibration clears the other points
+		e.cal = map[string]ezoPoint{"mid": p}
+	case "low", "high":
+		if _, ok := e.cal["mid"]; !ok {
+			e.code = EZOSyntax
+			return
+		}
+		e.cal[args[0]] = p
+	default:
+		e.code = EZOSyntax
+	}
+}
+
+// reading applies the calibration, piecewise linear between points
+func (e *EZO) reading() float64 {
+	raw := e.PH(e.temperature)
+	var points []ezoPoint
+	for _, p := range e.cal {
+		points = append(points, p)
+	}
+	switch len(points) {
+	case 0:
+		return raw
+	case 1:
+		return raw + points[0].expected - points[0].raw
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].raw < points[j].raw })
+	i := 0
+	for i < len(points)-2 && raw > points[i+1].raw {
+		i++
+	}
+	a, b := points[i], points[i+1]
+	if a.raw == b.raw {
+		return raw + a.expected - a.raw
+	}
+	return a.expected + (raw-a.raw)*(b.expected-a.expected)/(b.raw-a.raw)
+}
+
+func (e *EZO) Read(n int) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.sleeping {
+		return nil, fmt.Errorf("ezo is asleep")
+	}
+	buf := make([]byte, n)
+	if n == 0 {
+		return buf, nil
+	}
+	if e.code != EZONoData && e.Now().Before(e.ready) {
+		buf[0] = EZOProcessing
+		return buf, nil
+	}
+	buf[0] = e.code
+	copy(buf[1:], e.response)
+	e.code, e.response = EZONoData, ""
+	return buf, nil
+}
+
+// Temperature returns the compensation temperature
+func (e *EZO) Temperature() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.temperature
+}
+
+// Led reports whether the status led is on
+func (e *EZO) Led() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.led
+}
+
+// Calibrated returns the number of calibration points
+func (e *EZO) Calibrated() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.cal)
+}
@@ -0,0 +1,135 @@
+package sim
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	htSystem  = 0x20
+	htDisplay = 0x80
+	htDimming = 0xE0
+)
+
+// HT16K33 simulates the display RAM and setup registers of an HT16K33 driving
+// a 4 digit 14-segment display
+type HT16K33 struct {
+	mu         sync.Mutex
+	ram        [16]byte
+	ptr        byte
+	oscillator bool
+	display    bool
+	blink      byte
+	brightness byte
+}
+
+func NewHT16K33() *HT16K33 {
+	return &HT16K33{brightness: 15}
+}
+
+func (h *HT16K33) Write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cmd := data[0]
+	switch cmd & 0xF0 {
+	case 0x00:
+		// display RAM address pointer, followed by data
+		h.ptr = cmd & 0x0F
+		for _, v := range data[1:] {
+			h.ram[h.ptr] = v
+			h.ptr = (h.ptr + 1) & 0x0F
+		}
+	case htSystem:
+		h.oscillator = cmd&0x01 != 0
+	case htDisplay:
+		h.display = cmd&0x01 != 0
+		h.blink = (cmd >> 1) & 0x03
+	case htDimming:
+		h.brightness = cmd & 0x0F
+	}
+	return nil
+}
+
+func (h *HT16K33) Read(n int) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = h.ram[h.ptr]
+		h.ptr = (h.ptr + 1) & 0x0F
+	}
+	return buf, nil
+}
+
+// On reports whether the oscillator and display are enabled
+func (h *HT16K33) On() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.oscillator && h.display
+}
+
+// Blink returns the blink rate setting (0 off, 1 2Hz, 2 1Hz, 3 0.5Hz)
+func (h *HT16K33) Blink() byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.blink
+}
+
+// Brightness returns the dimming setting (0-15)
+func (h *HT16K33) Brightness() byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.brightness
+}
+
+// Segments returns the 14-segment pattern of a digit (0-3)
+func (h *HT16K33) Segments(digit int) uint16 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return uint16(h.ram[digit*2]) | uint16(h.ram[digit*2+1])<<8
+}
+
+// segment bits of each character cell, in a 5x5 grid
+var htCells = [5][5]struct {
+	bit uint
+	c   byte
+}{
+	{{}, {0, '-'}, {0, '-'}, {0, '-'}, {}},
+	{{5, '|'}, {8, '\\'}, {9, '|'}, {10, '/'}, {1, '|'}},
+	{{}, {6, '-'}, {}, {7, '-'}, {}},
+	{{4, '|'}, {11, '/'}, {12, '|'}, {13, '\\'}, {2, '|'}},
+	{{}, {3, '-'}, {3, '-'}, {3, '-'}, {}},
+}
+
+// Render draws the 4 digits as ASCII art, 5 lines high. A blank display is
+// rendered when the display is off
+func (h *HT16K33) Render() string {
+	on := h.On()
+	lines := make([]string, 5)
+	for d := 0; d < 4; d++ {
+		s := h.Segments(d)
+		for row, cells := range htCells {
+			var b strings.Builder
+			for _, cell := range cells {
+				if on && cell.c != 0 && s&(1<<cell.bit) != 0 {
+					b.WriteByte(cell.c)
+				} else {
+					b.WriteByte(' ')
+				}
+			}
+			if s&(1<<14) != 0 && on && row == 4 {
+				b.WriteByte('.')
+			} else {
+				b.WriteByte(' ')
+			}
+			lines[row] += b.String()
+		}
+	}
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,192 @@
+package sim
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	pcaMode1    = 0x00
+	pcaMode2    = 0x01
+	pcaLED0     = 0x06
+	pcaAllLED   = 0xFA
+	pcaPreScale = 0xFE
+
+	pcaRestart = 0x80
+	pcaExtClk  = 0x40
+	pcaAI      = 0x20
+	pcaSleep   = 0x10
+
+	pcaFull    = 0x1000
+	pcaClock   = 25000000
+	pcaTicks   = 4096
+	pcaDefault = 0x1E // 200Hz
+)
+
+// PCA9685 simulates the register file of a PCA9685 PWM controller, including
+// the MODE1 sleep and restart semantics and the prescaler, which only accepts
+// writes while the oscillator is asleep
+type PCA9685 struct {
+	mu      sync.Mutex
+	regs    [256]byte
+	ptr     byte
+	halted  bool // outputs stopped by sleep, until restarted
+	running bool
+	// ExtClock is the frequency of a clock fed to EXTCLK, 0 for none
+	ExtClock int
+}
+
+// NewPCA9685 returns a chip in its power-on state
+func NewPCA9685() *PCA9685 {
+	p := new(PCA9685)
+	p.Reset()
+	return p
+}
+
+// Reset restores the power-on register values
+func (p *PCA9685) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.regs = [256]byte{}
+	p.regs[pcaMode1] = 0x11 // sleep, all call
+	p.regs[pcaMode2] = 0x04 // totem pole
+	p.regs[0xFB] = 0x10     // ALL_LED off
+	p.regs[0xFD] = 0x10
+	for ch := 0; ch < 16; ch++ {
+		p.regs[pcaLED0+4*ch+3] = 0x10 // full off
+	}
+	p.regs[pcaPreScale] = pcaDefault
+	p.ptr = 0
+	p.halted = false
+	p.running = false
+}
+
+func (p *PCA9685) Write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ptr = data[0]
+	for i, v := range data[1:] {
+		if i > 0 && p.regs[pcaMode1]&pcaAI != 0 {
+			p.ptr++
+		}
+		p.write(p.ptr, v)
+	}
+	return nil
+}
+
+func (p *PCA9685) write(reg, v byte) {
+	switch {
+	case reg == pcaMode1:
+		p.writeMode1(v)
+	case reg == pcaPreScale:
+		// PRE_SCALE is write protected while the oscillator runs
+		if p.regs[pcaMode1]&pcaSleep != 0 && v >= 0x03 {
+			p.regs[reg] = v
+		}
+	case reg >= pcaAllLED && reg < pcaPreScale:
+		p.regs[reg] = v
+		for ch := 0; ch < 16; ch++ {
+			p.regs[pcaLED0+4*ch+int(reg-pcaAllLED)] = v
+		}
+	case reg >= pcaLED0 && reg < pcaLED0+64:
+		p.regs[reg] = v
+	case reg == pcaMode2 || (reg >= 0x02 && reg <= 0x05):
+		p.regs[reg] = v
+	}
+}
+
+func (p *PCA9685) writeMode1(v byte) {
+	old := p.regs[pcaMode1]
+	mode := v &^ pcaRestart
+	// EXTCLK is sticky and can only be set while asleep
+	if old&pcaExtClk != 0 || old&pcaSleep == 0 {
+		mode = mode&^pcaExtClk | old&pcaExtClk
+	}
+	restart := old & pcaRestart
+	switch {
+	case old&pcaSleep == 0 && mode&pcaSleep != 0:
+		// going to sleep stops the outputs, RESTART flags they were running
+		if p.running {
+			restart = pcaRestart
+			p.halted = true
+		}
+		p.running = false
+	case old&pcaSleep != 0 && mode&pcaSleep == 0:
+		p.running = !p.halted
+	}
+	if v&pcaRestart != 0 && restart != 0 && mode&pcaSleep == 0 {
+		// writing 1 to RESTART resumes the previously active channels
+		restart = 0
+		p.halted = false
+		p.running = true
+	}
+	p.regs[pcaMode1] = mode | restart
+}
+
+func (p *PCA9685) Read(n int) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = p.regs[p.ptr]
+		if p.regs[pcaMode1]&pcaAI != 0 {
+			p.ptr++
+		}
+	}
+	return buf, nil
+}
+
+// Register returns the value of a register
+func (p *PCA9685) Register(reg byte) byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.regs[reg]
+}
+
+// Awake reports whether the oscillator runs
+func (p *PCA9685) Awake() bool {
+	return p.Register(pcaMode1)&pcaSleep == 0
+}
+
+// Frequency returns the PWM frequency set by the prescaler
+func (p *PCA9685) Frequency() float64 {
+	clock := pcaClock
+	if p.Register(pcaMode1)&pcaExtClk != 0 && p.ExtClock > 0 {
+		clock = p.ExtClock
+	}
+	return float64(clock) / float64(pcaTicks*(int(p.Register(pcaPreScale))+1))
+}
+
+// Channel returns the on and off registers of a channel, full on/off bits included
+func (p *PCA9685) Channel(ch int) (uint16, uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.regs[pcaLED0+4*ch : pcaLED0+4*ch+4]
+	return uint16(r[0]) | uint16(r[1])<<8, uint16(r[2]) | uint16(r[3])<<8
+}
+
+// Duty returns the output duty cycle (0-100) of a channel, 0 while the
+// outputs are stopped
+func (p *PCA9685) Duty(ch int) (float64, error) {
+	if ch < 0 || ch > 15 {
+		return 0, fmt.Errorf("invalid channel %d", ch)
+	}
+	on, off := p.Channel(ch)
+	p.mu.Lock()
+	running := p.running
+	p.mu.Unlock()
+	if !running {
+		return 0, nil
+	}
+	switch {
+	case off&pcaFull != 0:
+		return 0, nil
+	case on&pcaFull != 0:
+		return 100, nil
+	}
+	ticks := (int(off&0xFFF) - int(on&0xFFF) + pcaTicks) % pcaTicks
+	return float64(ticks) * 100 / pcaTicks, nil
+}
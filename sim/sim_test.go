@@ -0,0 +1,225 @@
+package sim_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/reef-pi/hal"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/ads1x15"
+	"github.com/dmolavi/drivers/pca9685"
+	"github.com/dmolavi/drivers/ph_board"
+	"github.com/dmolavi/drivers/sim"
+)
+
+func TestBus(t *testing.T) {
+	bus := sim.NewBus()
+	if err := bus.Attach(0x40, sim.NewPCA9685()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Attach(0x40, sim.NewHT16K33()); err == nil {
+		t.Error("Attaching two devices at one address should fail")
+	}
+	if _, err := bus.ReadBytes(0x41, 1); err == nil {
+		t.Error("Reading an empty address should fail")
+	}
+	bus.Detach(0x40)
+	if err := bus.SetAddress(0x40); err == nil {
+		t.Error("Detached device should not acknowledge")
+	}
+}
+
+func TestPCA9685(t *testing.T) {
+	tank := sim.NewTank()
+	d, err := pca9685.HALAdapter([]byte(`{"address":"0x40", "frequency":1500}`), tank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chip := tank.PCA9685
+	if !chip.Awake() {
+		t.Fatal("Chip should be awake after setup")
+	}
+	if f := chip.Frequency(); math.Abs(f-1525.9) > 0.1 {
+		t.Error("Unexpected frequency:", f)
+	}
+	ch, err := d.(hal.PWMDriver).PWMChannel(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Set(25); err != nil {
+		t.Fatal(err)
+	}
+	if duty, _ := chip.Duty(3); math.Abs(duty-25) > 0.05 {
+		t.Error("Unexpected duty:", duty)
+	}
+
+	// sleeping stops the outputs until restarted
+	p := pca9685.New(0x40, tank)
+	if err := p.Sleep(); err != nil {
+		t.Fatal(err)
+	}
+	if duty, _ := chip.Duty(3); duty != 0 {
+		t.Error("Outputs should stop while asleep, found:", duty)
+	}
+	if chip.Register(0x00)&0x80 == 0 {
+		t.Error("RESTART should be flagged after sleeping with active outputs")
+	}
+	if err := tank.WriteToReg(0x40, 0xFE, []byte{0x10}); err != nil {
+		t.Fatal(err)
+	}
+	if chip.Register(0xFE) != 0x10 {
+		t.Error("Prescale should be writable while asleep")
+	}
+	p.Freq = 1500
+	if err := p.Wake(); err != nil {
+		t.Fatal(err)
+	}
+	if duty, _ := chip.Duty(3); math.Abs(duty-25) > 0.05 {
+		t.Error("Restart should resume outputs, found:", duty)
+	}
+	if err := tank.WriteToReg(0x40, 0xFE, []byte{0x10}); err != nil {
+		t.Fatal(err)
+	}
+	if chip.Register(0xFE) != 0x03 {
+		t.Error("Prescale should be write protected while awake")
+	}
+}
+
+func TestHT16K33(t *testing.T) {
+	tank := sim.NewTank()
+	h := drivers.NewHT16K33(tank)
+	if err := h.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Display("R2D2"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		" ---   ---   ---   ---\n" +
+		"|   |     |   | |     |\n" +
+		" - -   - -         - -\n" +
+		"|  \\  |       | | |\n" +
+		"       ---   ---   ---\n"
+	if r := tank.HT16K33.Render(); r != expected {
+		t.Errorf("Unexpected rendering:\n%s\nexpected:\n%s", r, expected)
+	}
+	if !tank.HT16K33.On() || tank.HT16K33.Blink() != 0 {
+		t.Error("Display should be on without blinking")
+	}
+}
+
+func TestADS1115(t *testing.T) {
+	tank := sim.NewTank()
+	tank.ADS1115.SetInput(0, sim.Constant(1.5))
+	tank.ADS1115.SetInput(1, sim.Constant(0.5))
+	d, err := ads1x15.HalAdapter([]byte(`{"address":"0x48", "gain":4.096, "data_rate":860}`), tank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins := d.(hal.AnalogInputDriver).AnalogInputPins()
+	for i, expected := range []float64{1.5, 0.5, 0, 0, 1} {
+		v, err := pins[i].Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(v-expected) > 0.001 {
+			t.Error("Unexpected voltage on", pins[i].Name(), v)
+		}
+	}
+}
+
+func TestADS1219(t *testing.T) {
+	tank := sim.NewTank()
+	tank.ADS1219.SetInput(0, sim.Constant(0.25))
+	for _, c := range []string{`{"address":"0x41"}`, `{"address":"0x41", "single_shot":true, "gain":4}`} {
+		d, err := ph_board.HalAdapter([]byte(c), tank)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pin, _ := d.(hal.AnalogInputDriver).AnalogInputPin(0)
+		v, err := pin.(interface{ Volts() (float64, error) }).Volts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(v-0.25) > 1e-6 {
+			t.Error("Unexpected voltage:", v, c)
+		}
+	}
+}
+
+func TestWaveforms(t *testing.T) {
+	period := 4 * time.Second
+	cases := []struct {
+		w        sim.Waveform
+		t        time.Duration
+		expected float64
+	}{
+		{sim.Sine(1, 0.5, period), time.Second, 1.5},
+		{sim.Ramp(0, 2, period), 3 * time.Second, 1.5},
+		{sim.Square(0, 3, period), 3 * time.Second, 3},
+		{sim.Square(0, 3, period), time.Second, 0},
+	}
+	for i, c := range cases {
+		if v := c.w(c.t); math.Abs(v-c.expected) > 1e-9 {
+			t.Error("Waveform", i, "expected", c.expected, "found", v)
+		}
+	}
+}
+
+func TestEZO(t *testing.T) {
+	now := time.Unix(0, 0)
+	e := sim.NewEZO()
+	e.Now = func() time.Time { return now }
+	e.PH = func(float64) float64 { return 6.8 }
+	read := func(cmd string) (byte, string) {
+		if err := e.Write(append([]byte(cmd), 0)); err != nil {
+			t.Fatal(err)
+		}
+		buf, _ := e.Read(31)
+		if buf[0] == sim.EZOProcessing {
+			now = now.Add(time.Second)
+			buf, _ = e.Read(31)
+		}
+		return buf[0], string(buf[1:clen(buf)])
+	}
+	if err := e.Write([]byte("R\000")); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := e.Read(31); buf[0] != sim.EZOProcessing {
+		t.Error("Reading before the processing delay should be pending, found:", buf[0])
+	}
+	now = now.Add(time.Second)
+	if buf, _ := e.Read(31); buf[0] != sim.EZOSuccess || string(buf[1:6]) != "6.800" {
+		t.Error("Unexpected reading:", buf)
+	}
+	if buf, _ := e.Read(31); buf[0] != sim.EZONoData {
+		t.Error("Response should be consumed, found:", buf[0])
+	}
+	if code, _ := read("Cal,low,4.00"); code != sim.EZOSyntax {
+		t.Error("Low point calibration requires a mid point")
+	}
+	read("Cal,mid,7.00")
+	if _, r := read("R"); r != "7.000" {
+		t.Error("Unexpected calibrated reading:", r)
+	}
+	if _, r := read("Cal,?"); r != "?Cal,1" {
+		t.Error("Unexpected calibration state:", r)
+	}
+	if code, _ := read("Bogus"); code != sim.EZOSyntax {
+		t.Error("Unknown commands should fail")
+	}
+	if _, r := read("T,?"); r != "?T,25.00" {
+		t.Error("Unexpected temperature:", r)
+	}
+}
+
+func clen(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
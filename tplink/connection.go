@@ -2,11 +2,15 @@ package tplink
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"io"
 	"net"
 	"time"
+
+	"github.com/dmolavi/drivers"
 )
 
 const (
@@ -30,26 +34,75 @@ var TCPConnFactory = func(proto, addr string, t time.Duration) (Conn, error) {
 type cmd struct {
 	cf   ConnectionFactory
 	addr string
+	errs drivers.ErrorLog
 }
 
 func (c *cmd) Execute(command interface{}, pResult bool) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), _timeOut)
+	defer cancel()
+	return c.ExecuteContext(ctx, command, pResult)
+}
+
+// ExecuteContext sends command to the device and returns its response when
+// pResult is set. The connection deadline follows the one of ctx, and
+// cancelling ctx aborts pending I/O
+func (c *cmd) ExecuteContext(ctx context.Context, command interface{}, pResult bool) ([]byte, error) {
+	resp, err := c.execute(ctx, command, pResult)
+	// callers giving up say nothing about the device
+	if !errors.Is(err, context.Canceled) {
+		c.errs.Record(err)
+	}
+	return resp, err
+}
+
+func (c *cmd) execute(ctx context.Context, command interface{}, pResult bool) ([]byte, error) {
 	payload, err := json.Marshal(command)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := c.cf("tcp", c.addr, _timeOut)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	timeout := _timeOut
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		timeout = time.Until(deadline)
+	}
+	conn, err := c.cf("tcp", c.addr, timeout)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
-	if err := conn.SetDeadline(time.Now().Add(_timeOut)); err != nil {
-		return nil, err
+	if hasDeadline {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	resp, err := c.exchange(conn, payload, pResult)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	// the connection deadline can expire just before the context timer fires
+	if err != nil && hasDeadline && !time.Now().Before(deadline) {
+		return nil, context.DeadlineExceeded
 	}
+	return resp, err
+}
+
+func (c *cmd) exchange(conn Conn, payload []byte, pResult bool) ([]byte, error) {
 	header := make([]byte, 4)
 	binary.BigEndian.PutUint32(header, uint32(len(payload)))
 	bs := append(header, autokeyEncrypt(payload)...)
-	_, err = conn.Write(bs)
-	if err != nil {
+	if _, err := conn.Write(bs); err != nil {
 		return nil, err
 	}
 	if !pResult {
@@ -1,16 +1,34 @@
 package tplink
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/dmolavi/drivers"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
 
+var hs103Meta = hal.Metadata{
+	Name:        "tplink-hs103",
+	Description: "tplink hs103 series smart plug driver",
+	Capabilities: []hal.Capability{
+		hal.DigitalOutput,
+	},
+}
+
+func init() {
+	drivers.Register(hs103Meta, configSchema, HS103HALAdapter)
+}
+
 type HS103Plug struct {
+	// guards state, and is held while switching so the state follows the
+	// last command sent
+	mu      sync.Mutex
 	state   bool
 	command *cmd
 	meta    hal.Metadata
@@ -18,13 +36,7 @@ type HS103Plug struct {
 
 func NewHS103Plug(addr string) *HS103Plug {
 	return &HS103Plug{
-		meta: hal.Metadata{
-			Name:        "tplink-hs103",
-			Description: "tplink hs103 series smart plug driver",
-			Capabilities: []hal.Capability{
-				hal.DigitalOutput,
-			},
-		},
+		meta: hs103Meta,
 		command: &cmd{
 			addr: addr,
 			cf: func(proto, addr string, t time.Duration) (Conn, error) {
@@ -38,38 +50,33 @@ func (p *HS103Plug) SetFactory(cf ConnectionFactory) {
 	p.command.cf = cf
 }
 func (p *HS103Plug) On() error {
-	cmd := new(CmdRelayState)
-	cmd.System.RelayState.State = 1
-	if _, err := p.command.Execute(cmd, false); err != nil {
-		return err
-	}
-	p.state = true
-	return nil
+	return p.Write(true)
 }
 
 func (p *HS103Plug) Off() error {
-	cmd := new(CmdRelayState)
-	cmd.System.RelayState.State = 0
-	if _, err := p.command.Execute(cmd, false); err != nil {
-		return err
-	}
-	p.state = false
-	return nil
+	return p.Write(false)
 }
 
 func (p *HS103Plug) Info() (*Sysinfo, error) {
-	buf, err := p.command.Execute(new(Plug), true)
-	if err != nil {
-		return nil, err
-	}
-	var d Plug
-	if err := json.Unmarshal(buf, &d); err != nil {
-		return nil, err
+	ctx, cancel := context.WithTimeout(context.Background(), _timeOut)
+	defer cancel()
+	return p.command.sysinfo(ctx)
+}
+
+// Diagnose reports the model, firmware and WiFi signal of the plug
+func (p *HS103Plug) Diagnose(ctx context.Context) drivers.Diagnosis {
+	diag, info := p.command.diagnose(ctx)
+	if info != nil {
+		diag.Set("relay_state", info.RelayState)
 	}
-	return &d.System.Sysinfo, nil
+	return diag
 }
 
 func HS103HALAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
+	c, err := configSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
 	var conf Config
 	if err := json.Unmarshal(c, &conf); err != nil {
 		return nil, err
@@ -100,13 +107,28 @@ func (p *HS103Plug) DigitalOutputPin(i int) (hal.DigitalOutputPin, error) {
 }
 
 func (p *HS103Plug) Write(state bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), _timeOut)
+	defer cancel()
+	return p.WriteContext(ctx, state)
+}
+
+func (p *HS103Plug) WriteContext(ctx context.Context, state bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cmd := new(CmdRelayState)
 	if state {
-		return p.On()
+		cmd.System.RelayState.State = 1
 	}
-	return p.Off()
+	if _, err := p.command.ExecuteContext(ctx, cmd, false); err != nil {
+		return err
+	}
+	p.state = state
+	return nil
 }
 
 func (p *HS103Plug) LastState() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.state
 }
 
@@ -114,10 +136,5 @@ func (p *HS103Plug) Close() error {
 	return nil
 }
 func (p *HS103Plug) Pins(cap hal.Capability) ([]hal.Pin, error) {
-	switch cap {
-	case hal.DigitalOutput:
-		return []hal.Pin{p}, nil
-	default:
-		return nil, fmt.Errorf("unsupported capability:%s", cap.String())
-	}
+	return drivers.Pins(p, cap)
 }
@@ -1,7 +1,14 @@
 package tplink
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/reef-pi/hal"
 )
@@ -40,3 +47,71 @@ func TestHS103Plug(t *testing.T) {
 		t.Error("Expected initial state to be false")
 	}
 }
+
+// every connection answers {}, so concurrent commands do not share a conn
+func emptyResponses(_, _ string, _ time.Duration) (Conn, error) {
+	return &nopConn{Buffer: []byte(`{}`)}, nil
+}
+
+func TestHS103Concurrency(t *testing.T) {
+	p := NewHS103Plug("127.0.0.1:9999")
+	p.SetFactory(emptyResponses)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(state bool) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := p.Write(state); err != nil {
+					t.Error(err)
+				}
+				p.LastState()
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+}
+
+// replyConn answers with the encrypted contents of a testdata file
+type replyConn struct {
+	bytes.Reader
+}
+
+func (c *replyConn) Close() error                  { return nil }
+func (c *replyConn) SetDeadline(_ time.Time) error { return nil }
+func (c *replyConn) Write(b []byte) (int, error)   { return len(b), nil }
+
+func replyFactory(t *testing.T, file string) ConnectionFactory {
+	payload, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := make([]byte, 4)
+	binary.BigEndian.PutUint32(resp, uint32(len(payload)))
+	resp = append(resp, autokeyEncrypt(payload)...)
+	return func(_, _ string, _ time.Duration) (Conn, error) {
+		c := new(replyConn)
+		c.Reset(resp)
+		return c, nil
+	}
+}
+
+func TestHS103Diagnose(t *testing.T) {
+	p := NewHS103Plug("127.0.0.1:9999")
+	p.SetFactory(replyFactory(t, "testdata/hs103_info.json"))
+	diag := p.Diagnose(context.Background())
+	if !diag.Reachable || diag.Hardware != "HS103(US) rev 2.1" || diag.RSSI != -59 {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+	if diag.Firmware != "1.0.12 Build 190403 Rel.074805" || diag.Fields["relay_state"] != "1" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+
+	p.SetFactory(func(_, _ string, _ time.Duration) (Conn, error) {
+		return nil, errors.New("connection refused")
+	})
+	diag = p.Diagnose(context.Background())
+	if diag.Reachable || diag.LastError != "connection refused" || diag.LastErrorTime.IsZero() {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+}
@@ -1,13 +1,27 @@
 package tplink
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
+	"github.com/dmolavi/drivers"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
 
+var hs110Meta = hal.Metadata{
+	Name:        "tplink-hs110",
+	Description: "tplink hs110 series smart plug driver with current monitoring",
+	Capabilities: []hal.Capability{
+		hal.DigitalOutput, hal.AnalogInput,
+	},
+}
+
+func init() {
+	drivers.Register(hs110Meta, configSchema, HS110HALAdapter)
+}
+
 type (
 	EmeterCmd struct {
 		Emeter struct {
@@ -25,7 +39,7 @@ type (
 
 type HS110Plug struct {
 	HS103Plug
-	calibrator hal.Calibrator
+	calibrator hal.Calibrator // guarded by mu
 }
 
 func (p *HS110Plug) Number() int {
@@ -39,19 +53,17 @@ func NewHS110Plug(addr string) *HS110Plug {
 				addr: addr,
 				cf:   TCPConnFactory,
 			},
-			meta: hal.Metadata{
-				Name:        "tplink-hs110",
-				Description: "tplink hs110 series smart plug driver with current monitoring",
-				Capabilities: []hal.Capability{
-					hal.DigitalOutput, hal.AnalogInput,
-				},
-			},
+			meta: hs110Meta,
 		},
 		calibrator: cal,
 	}
 }
 
 func HS110HALAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
+	c, err := configSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
 	var conf Config
 	if err := json.Unmarshal(c, &conf); err != nil {
 		return nil, err
@@ -60,7 +72,13 @@ func HS110HALAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
 }
 
 func (p *HS110Plug) RTEmeter() (*Realtime, error) {
-	d, err := p.command.Execute(new(EmeterCmd), true)
+	ctx, cancel := context.WithTimeout(context.Background(), _timeOut)
+	defer cancel()
+	return p.rtEmeter(ctx)
+}
+
+func (p *HS110Plug) rtEmeter(ctx context.Context) (*Realtime, error) {
+	d, err := p.command.ExecuteContext(ctx, new(EmeterCmd), true)
 	if err != nil {
 		return nil, err
 	}
@@ -87,7 +105,13 @@ func (p *HS110Plug) AnalogInputPin(i int) (hal.AnalogInputPin, error) {
 }
 
 func (p *HS110Plug) Read() (float64, error) {
-	em, err := p.RTEmeter()
+	ctx, cancel := context.WithTimeout(context.Background(), _timeOut)
+	defer cancel()
+	return p.ReadContext(ctx)
+}
+
+func (p *HS110Plug) ReadContext(ctx context.Context) (float64, error) {
+	em, err := p.rtEmeter(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -99,25 +123,31 @@ func (p *HS110Plug) Calibrate(points []hal.Measurement) error {
 	if err != nil {
 		return err
 	}
+	p.mu.Lock()
 	p.calibrator = cal
+	p.mu.Unlock()
 	return nil
 }
 func (p *HS110Plug) Measure() (float64, error) {
-	v, err := p.Read()
+	ctx, cancel := context.WithTimeout(context.Background(), _timeOut)
+	defer cancel()
+	return p.MeasureContext(ctx)
+}
+
+func (p *HS110Plug) MeasureContext(ctx context.Context) (float64, error) {
+	v, err := p.ReadContext(ctx)
 	if err != nil {
 		return 0, err
 	}
-	if p.calibrator == nil {
+	p.mu.Lock()
+	cal := p.calibrator
+	p.mu.Unlock()
+	if cal == nil {
 		return 0, fmt.Errorf("Not calibrated")
 	}
-	return p.calibrator.Calibrate(v), nil
+	return cal.Calibrate(v), nil
 }
 
 func (p *HS110Plug) Pins(cap hal.Capability) ([]hal.Pin, error) {
-	switch cap {
-	case hal.DigitalOutput, hal.AnalogInput:
-		return []hal.Pin{p}, nil
-	default:
-		return nil, fmt.Errorf("unsupported capability:%s", cap)
-	}
+	return drivers.Pins(p, cap)
 }
@@ -1,7 +1,10 @@
 package tplink
 
 import (
+	"context"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/reef-pi/hal"
 )
@@ -42,3 +45,38 @@ func TestHS110Plug(t *testing.T) {
 		t.Error("Expected initial state to be false")
 	}
 }
+
+func TestHS110Context(t *testing.T) {
+	// accepts connections and never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	p := NewHS110Plug(l.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.ReadContext(ctx); err != context.DeadlineExceeded {
+		t.Error("Expected deadline exceeded, found:", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := p.MeasureContext(ctx); err != context.Canceled {
+		t.Error("Expected cancellation, found:", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Error("Cancellation should abort pending reads, took:", d)
+	}
+}
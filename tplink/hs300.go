@@ -1,13 +1,28 @@
 package tplink
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
+	"github.com/dmolavi/drivers"
 	"github.com/reef-pi/hal"
 	"github.com/reef-pi/rpi/i2c"
 )
 
+var hs300Meta = hal.Metadata{
+	Name:        "tplink-hs300",
+	Description: "tplink hs300 series smart power strip driver with current monitoring",
+	Capabilities: []hal.Capability{
+		hal.DigitalOutput, hal.AnalogInput,
+	},
+}
+
+func init() {
+	drivers.Register(hs300Meta, configSchema, HS300HALAdapter)
+}
+
 type (
 	HS300EmeterCmd struct {
 		Emeter struct {
@@ -26,29 +41,28 @@ type (
 	}
 	HS300Strip struct {
 		meta     hal.Metadata
-		children []*Outlet
+		mu       sync.Mutex
+		children []*Outlet // guarded by mu, replaced by FetchSysInfo
 		command  *cmd
 	}
 )
 
 func NewHS300Strip(addr string) *HS300Strip {
 	return &HS300Strip{
-		meta: hal.Metadata{
-			Name:        "tplink-hs300",
-			Description: "tplink hs300 series smart power strip driver with current monitoring",
-			Capabilities: []hal.Capability{
-				hal.DigitalOutput, hal.AnalogInput,
-			},
-		},
+		meta: hs300Meta,
 		command: &cmd{
 			cf:   TCPConnFactory,
 			addr: addr,
 		},
-		children: make([]*Outlet, 6),
+		children: []*Outlet{},
 	}
 }
 
 func HS300HALAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
+	c, err := configSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
 	var conf Config
 	if err := json.Unmarshal(c, &conf); err != nil {
 		return nil, err
@@ -70,17 +84,18 @@ func (s *HS300Strip) Name() string {
 
 func (s *HS300Strip) DigitalOutputPins() []hal.DigitalOutputPin {
 	var pins []hal.DigitalOutputPin
-	for _, o := range s.children {
+	for _, o := range s.Children() {
 		pins = append(pins, o)
 	}
 	return pins
 }
 
 func (s *HS300Strip) DigitalOutputPin(i int) (hal.DigitalOutputPin, error) {
-	if i < 0 || i > 5 {
+	children := s.Children()
+	if i < 0 || i >= len(children) {
 		return nil, fmt.Errorf("invalid pin: %d", i)
 	}
-	return s.children[i], nil
+	return children[i], nil
 }
 
 func (s *HS300Strip) Close() error {
@@ -106,38 +121,43 @@ func (s *HS300Strip) FetchSysInfo() error {
 		}
 		children = append(children, o)
 	}
+	s.mu.Lock()
 	s.children = children
+	s.mu.Unlock()
 	return nil
 }
 
+// Diagnose reports the model, firmware and WiFi signal of the strip
+func (s *HS300Strip) Diagnose(ctx context.Context) drivers.Diagnosis {
+	diag, info := s.command.diagnose(ctx)
+	if info != nil {
+		diag.Set("outlets", len(info.Children))
+	}
+	return diag
+}
+
 func (s *HS300Strip) Children() []*Outlet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.children
 }
 
 func (p *HS300Strip) AnalogInputPins() []hal.AnalogInputPin {
 	var channels []hal.AnalogInputPin
-	for _, o := range p.children {
+	for _, o := range p.Children() {
 		channels = append(channels, o)
 	}
 	return channels
 }
 
 func (p *HS300Strip) AnalogInputPin(i int) (hal.AnalogInputPin, error) {
-	if i < 0 || i > 5 {
+	children := p.Children()
+	if i < 0 || i >= len(children) {
 		return nil, fmt.Errorf("invalid channel number: %d", i)
 	}
-	return p.children[i], nil
+	return children[i], nil
 }
 
 func (p *HS300Strip) Pins(cap hal.Capability) ([]hal.Pin, error) {
-	switch cap {
-	case hal.DigitalOutput, hal.AnalogInput:
-		var channels []hal.Pin
-		for _, o := range p.children {
-			channels = append(channels, o)
-		}
-		return channels, nil
-	default:
-		return nil, fmt.Errorf("unsupported capability:%s", cap.String())
-	}
+	return drivers.Pins(p, cap)
 }
@@ -1,20 +1,24 @@
 package tplink
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/reef-pi/hal"
 )
 
 type (
 	Outlet struct {
-		name       string
-		id         string
-		command    *cmd
+		name    string
+		id      string
+		command *cmd
+		number  int
+		// guards state and calibrator, and is held while switching
+		mu         sync.Mutex
 		state      bool
 		calibrator hal.Calibrator
-		number     int
 	}
 )
 
@@ -26,16 +30,36 @@ func (o *Outlet) Number() int {
 }
 
 func (o *Outlet) Write(state bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), _timeOut)
+	defer cancel()
+	return o.WriteContext(ctx, state)
+}
+
+func (o *Outlet) WriteContext(ctx context.Context, state bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	cmd := new(CmdRelayState)
 	if state {
-		return o.On()
+		cmd.System.RelayState.State = 1
+	}
+	cmd.Context.Children = []string{o.id}
+	if _, err := o.command.ExecuteContext(ctx, cmd, false); err != nil {
+		return err
 	}
-	return o.Off()
+	o.state = state
+	return nil
 }
 
 func (o *Outlet) RTEmeter() (*HS300Realtime, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), _timeOut)
+	defer cancel()
+	return o.rtEmeter(ctx)
+}
+
+func (o *Outlet) rtEmeter(ctx context.Context) (*HS300Realtime, error) {
 	var cmd HS300EmeterCmd
 	cmd.Context.Children = []string{o.id}
-	d, err := o.command.Execute(&cmd, true)
+	d, err := o.command.ExecuteContext(ctx, &cmd, true)
 	if err != nil {
 		return nil, err
 	}
@@ -46,31 +70,25 @@ func (o *Outlet) RTEmeter() (*HS300Realtime, error) {
 }
 
 func (o *Outlet) LastState() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	return o.state
 }
 
 func (o *Outlet) On() error {
-	cmd := new(CmdRelayState)
-	cmd.System.RelayState.State = 1
-	cmd.Context.Children = []string{o.id}
-	if _, err := o.command.Execute(cmd, false); err != nil {
-		return err
-	}
-	o.state = true
-	return nil
+	return o.Write(true)
 }
 func (o *Outlet) Off() error {
-	cmd := new(CmdRelayState)
-	cmd.System.RelayState.State = 0
-	cmd.Context.Children = []string{o.id}
-	if _, err := o.command.Execute(cmd, false); err != nil {
-		return err
-	}
-	o.state = true
-	return nil
+	return o.Write(false)
 }
 func (o *Outlet) Read() (float64, error) {
-	em, err := o.RTEmeter()
+	ctx, cancel := context.WithTimeout(context.Background(), _timeOut)
+	defer cancel()
+	return o.ReadContext(ctx)
+}
+
+func (o *Outlet) ReadContext(ctx context.Context) (float64, error) {
+	em, err := o.rtEmeter(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -82,18 +100,29 @@ func (o *Outlet) Calibrate(points []hal.Measurement) error {
 	if err != nil {
 		return err
 	}
+	o.mu.Lock()
 	o.calibrator = cal
+	o.mu.Unlock()
 	return nil
 }
 func (o *Outlet) Measure() (float64, error) {
-	v, err := o.Read()
+	ctx, cancel := context.WithTimeout(context.Background(), _timeOut)
+	defer cancel()
+	return o.MeasureContext(ctx)
+}
+
+func (o *Outlet) MeasureContext(ctx context.Context) (float64, error) {
+	v, err := o.ReadContext(ctx)
 	if err != nil {
 		return 0, err
 	}
-	if o.calibrator == nil {
+	o.mu.Lock()
+	cal := o.calibrator
+	o.mu.Unlock()
+	if cal == nil {
 		return 0, fmt.Errorf("Not calibrated")
 	}
-	return o.calibrator.Calibrate(v), nil
+	return cal.Calibrate(v), nil
 }
 
 func (o *Outlet) Close() error {
@@ -1,7 +1,11 @@
 package tplink
 
 import (
+	"context"
+	"sync"
 	"testing"
+
+	"github.com/reef-pi/hal"
 )
 
 func TestHS300Strip(t *testing.T) {
@@ -12,4 +16,45 @@ func TestHS300Strip(t *testing.T) {
 		t.Error("HAL metadata should not have empty name")
 	}
 
+	d.SetFactory(replyFactory(t, "testdata/hs300_info.json"))
+	diag := d.Diagnose(context.Background())
+	if !diag.Reachable || diag.Hardware != "HS300(US) rev 1.0" || diag.Fields["outlets"] != "6" {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+}
+
+func TestHS300Concurrency(t *testing.T) {
+	s := NewHS300Strip("127.0.0.1:9999")
+	s.SetFactory(emptyResponses)
+	o := &Outlet{id: "0", command: s.command}
+	s.children = []*Outlet{o}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := o.Write(j%2 == 0); err != nil {
+					t.Error(err)
+				}
+				o.LastState()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				o.Calibrate([]hal.Measurement{{Expected: 1, Observed: 2}})
+				o.Measure()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				s.FetchSysInfo()
+				s.DigitalOutputPins()
+				s.AnalogInputPin(0)
+			}
+		}()
+	}
+	wg.Wait()
 }
@@ -1,5 +1,17 @@
 package tplink
 
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/schema"
+)
+
+var configSchema = schema.Object(schema.Properties{
+	"address": schema.String().NonEmpty().Describe("host:port of the device"),
+}, "address")
+
 type (
 	Action struct {
 		Type float64 `json:"type,omitempty"`
@@ -15,7 +27,7 @@ type (
 
 	Sysinfo struct {
 		Alias           string  `json:"alias,omitempty"`
-		SoftwareVersion string  `json:"sw_veri,omitempty"`
+		SoftwareVersion string  `json:"sw_ver,omitempty"`
 		HardwareVersion string  `json:"hw_ver,omitempty"`
 		Model           string  `json:"model,omitempty"`
 		DeviceID        string  `json:"deviceId,omitempty"`
@@ -54,3 +66,35 @@ type (
 		} `json:"context,omitempty"`
 	}
 )
+
+func (c *cmd) sysinfo(ctx context.Context) (*Sysinfo, error) {
+	buf, err := c.ExecuteContext(ctx, new(Plug), true)
+	if err != nil {
+		return nil, err
+	}
+	var d Plug
+	if err := json.Unmarshal(buf, &d); err != nil {
+		return nil, err
+	}
+	return &d.System.Sysinfo, nil
+}
+
+// diagnose fetches the system info of the device, which is nil when it
+// does not answer
+func (c *cmd) diagnose(ctx context.Context) (drivers.Diagnosis, *Sysinfo) {
+	info, err := c.sysinfo(ctx)
+	diag := c.errs.Diagnosis(err)
+	if err != nil {
+		return diag, nil
+	}
+	diag.Hardware = info.Model
+	if info.HardwareVersion != "" {
+		diag.Hardware += " rev " + info.HardwareVersion
+	}
+	diag.Firmware = info.SoftwareVersion
+	diag.RSSI = info.Rssi
+	diag.Set("address", c.addr)
+	diag.Set("alias", info.Alias)
+	diag.Set("device_id", info.DeviceID)
+	return diag, info
+}
@@ -0,0 +1,119 @@
+package w1
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dmolavi/drivers"
+	"github.com/reef-pi/hal"
+)
+
+type channel struct {
+	id         string
+	path       string
+	number     int
+	fahrenheit bool
+	mu         sync.Mutex // guards calibrator
+	calibrator hal.Calibrator
+	errs       drivers.ErrorLog
+}
+
+func NewChannel(dir string, number int, fahrenheit bool) (*channel, error) {
+	c, err := hal.CalibratorFactory([]hal.Measurement{})
+	if err != nil {
+		return nil, err
+	}
+	return &channel{
+		id:         filepath.Base(dir),
+		path:       filepath.Join(dir, "w1_slave"),
+		number:     number,
+		fahrenheit: fahrenheit,
+		calibrator: c,
+	}, nil
+}
+
+func (c *channel) Name() string {
+	return c.id
+}
+
+func (c *channel) Number() int {
+	return c.number
+}
+
+func (c *channel) Calibrate(points []hal.Measurement) error {
+	cal, err := hal.CalibratorFactory(points)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.calibrator = cal
+	c.mu.Unlock()
+	return nil
+}
+
+// Read returns the probe temperature in °C, or °F when configured
+func (c *channel) Read() (float64, error) {
+	t, err := c.read()
+	return t, c.errs.Record(err)
+}
+
+func (c *channel) read() (float64, error) {
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return 0, err
+	}
+	t, err := parse(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", c.id, err)
+	}
+	if c.fahrenheit {
+		return t*9/5 + 32, nil
+	}
+	return t, nil
+}
+
+// parse decodes w1_slave content, e.g.
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+func parse(data string) (float64, error) {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	if len(lines) != 2 {
+		return 0, fmt.Errorf("Malformed response:'%s'", data)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "YES") {
+		// a bit flipped on the wire, the next conversion may be fine
+		return 0, drivers.Transient(fmt.Errorf("CRC check failed:'%s'", lines[0]))
+	}
+	i := strings.LastIndex(lines[1], "t=")
+	if i < 0 {
+		return 0, fmt.Errorf("Malformed response:'%s'", lines[1])
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(lines[1][i+2:]))
+	if err != nil {
+		return 0, err
+	}
+	return float64(v) / 1000, nil
+}
+
+func (c *channel) Measure() (float64, error) {
+	v, err := c.Read()
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	cal := c.calibrator
+	c.mu.Unlock()
+	if cal == nil {
+		return 0, fmt.Errorf("Not calibrated")
+	}
+	return cal.Calibrate(v), nil
+}
+
+func (c *channel) Close() error {
+	return nil
+}
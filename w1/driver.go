@@ -0,0 +1,130 @@
+package w1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dmolavi/drivers"
+	"github.com/dmolavi/drivers/schema"
+	"github.com/reef-pi/hal"
+	"github.com/reef-pi/rpi/i2c"
+)
+
+var driverMeta = hal.Metadata{
+	Name:         "w1",
+	Description:  "DS18B20/DS18S20 1-Wire temperature probes via the w1 sysfs interface",
+	Capabilities: []hal.Capability{hal.AnalogInput},
+}
+
+func init() {
+	drivers.Register(driverMeta, configSchema, HalAdapter)
+}
+
+const _devicesPath = "/sys/bus/w1/devices"
+
+// 1-Wire family codes of supported temperature probes
+var _families = []string{
+	"28", // DS18B20
+	"10", // DS18S20
+}
+
+var configSchema = schema.Object(schema.Properties{
+	"path":       schema.String().Describe("w1 devices directory, defaults to " + _devicesPath),
+	"fahrenheit": schema.Boolean(),
+})
+
+type Config struct {
+	// Path is the w1 devices directory, defaults to /sys/bus/w1/devices
+	Path       string `json:"path"`
+	Fahrenheit bool   `json:"fahrenheit"`
+}
+
+type driver struct {
+	path     string
+	channels []hal.AnalogInputPin
+	meta     hal.Metadata
+}
+
+func HalAdapter(c []byte, _ i2c.Bus) (hal.Driver, error) {
+	c, err := configSchema.Apply(c)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(c, &config); err != nil {
+		return nil, err
+	}
+	return NewDriver(config)
+}
+
+// NewDriver discovers the DS18B20/DS18S20 probes under the configured path
+func NewDriver(config Config) (hal.AnalogInputDriver, error) {
+	p := config.Path
+	if p == "" {
+		p = _devicesPath
+	}
+	var dirs []string
+	for _, f := range _families {
+		matches, err := filepath.Glob(filepath.Join(p, f+"-*"))
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, matches...)
+	}
+	sort.Strings(dirs)
+	d := &driver{
+		path: p,
+		meta: driverMeta,
+	}
+	for i, dir := range dirs {
+		ch, err := NewChannel(dir, i, config.Fahrenheit)
+		if err != nil {
+			return nil, err
+		}
+		d.channels = append(d.channels, ch)
+	}
+	return d, nil
+}
+
+// Diagnose checks every probe is still on the bus, without starting a
+// conversion
+func (d *driver) Diagnose(_ context.Context) drivers.Diagnosis {
+	diag := drivers.Diagnosis{Reachable: true}
+	for _, pin := range d.channels {
+		ch := pin.(*channel)
+		if _, err := os.Stat(ch.path); ch.errs.Record(err) != nil {
+			diag.Reachable = false
+		}
+		ch.errs.Report(&diag)
+	}
+	diag.Set("path", d.path)
+	diag.Set("probes", len(d.channels))
+	return diag
+}
+
+func (d *driver) Metadata() hal.Metadata {
+	return d.meta
+}
+
+func (d *driver) Pins(cap hal.Capability) ([]hal.Pin, error) {
+	return drivers.Pins(d, cap)
+}
+
+func (d *driver) AnalogInputPins() []hal.AnalogInputPin {
+	return d.channels
+}
+
+func (d *driver) AnalogInputPin(n int) (hal.AnalogInputPin, error) {
+	if n < 0 || n >= len(d.channels) {
+		return nil, fmt.Errorf("w1 driver does not have channel %d", n)
+	}
+	return d.channels[n], nil
+}
+
+func (d *driver) Close() error {
+	return nil
+}
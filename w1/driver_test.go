@@ -0,0 +1,92 @@
+package w1
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/reef-pi/hal"
+)
+
+func TestW1Driver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "w1-driver-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	probes := map[string]string{
+		"28-0000075a1a2b": "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n",
+		"28-0000075a1a2c": "72 01 4b 46 7f ff 0e 10 57 : crc=00 NO\n72 01 4b 46 7f ff 0e 10 57 t=23125\n",
+		"10-000802b4f1a0": "2e 00 4b 46 ff ff 0c 10 1d : crc=1d YES\n2e 00 4b 46 ff ff 0c 10 1d t=-1500\n",
+		"w1_bus_master1":  "",
+	}
+	for id, content := range probes {
+		if err := os.Mkdir(filepath.Join(dir, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, id, "w1_slave"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := HalAdapter([]byte("{"), nil); err == nil {
+		t.Error("Adapter creation should fail when json config is invalid")
+	}
+	d, err := HalAdapter([]byte(`{"path":"`+dir+`"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Metadata().HasCapability(hal.AnalogInput) {
+		t.Error("Analog input Capability should exist")
+	}
+	input := d.(hal.AnalogInputDriver)
+	if len(input.AnalogInputPins()) != 3 {
+		t.Fatal("Expected 3 probes, found:", len(input.AnalogInputPins()))
+	}
+	ch, err := input.AnalogInputPin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.Name() != "10-000802b4f1a0" {
+		t.Error("Unexpected channel name:", ch.Name())
+	}
+	if v, err := ch.Read(); err != nil || v != -1.5 {
+		t.Error("Expected -1.5, found:", v, err)
+	}
+	ch, _ = input.AnalogInputPin(1)
+	if v, err := ch.Read(); err != nil || v != 23.125 {
+		t.Error("Expected 23.125, found:", v, err)
+	}
+	if err := ch.Calibrate([]hal.Measurement{{Expected: 24, Observed: 23.125}}); err != nil {
+		t.Error(err)
+	}
+	if v, err := ch.Measure(); err != nil || v != 24 {
+		t.Error("Expected calibrated 24, found:", v, err)
+	}
+	ch, _ = input.AnalogInputPin(2)
+	if v, err := ch.Read(); err == nil || v != 0 {
+		t.Error("Expected 0 and an error on failed CRC, found:", v, err)
+	}
+	diag := d.(*driver).Diagnose(context.Background())
+	if !diag.Reachable || diag.Fields["probes"] != "3" || !strings.Contains(diag.LastError, "CRC check failed") {
+		t.Errorf("Unexpected diagnosis: %+v", diag)
+	}
+	if _, err := input.AnalogInputPin(3); err == nil {
+		t.Error("Expected error for invalid channel")
+	}
+
+	f, err := NewDriver(Config{Path: dir, Fahrenheit: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, _ = f.AnalogInputPin(0)
+	if v, err := ch.Read(); err != nil || v != 29.3 {
+		t.Error("Expected 29.3°F, found:", v, err)
+	}
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+}